@@ -39,6 +39,16 @@ type confParam struct {
 	MixerRouteFlags    string
 	FiltersBeforeMixer string
 
+	// AccessLogFormat is an Envoy access log format string (see the "format" access log
+	// field); empty means Envoy's own default plain-text format.
+	AccessLogFormat string
+
+	// AccessLogFields adds a "json_format" access log object with these key/value entries
+	// (values may use Envoy's "%...%" command operators), e.g. a request id or upstream
+	// cluster name, so tests can parse one JSON object per request instead of matching
+	// substrings in a plain-text line.
+	AccessLogFields map[string]string
+
 	// Ports contains the allocated ports.
 	Ports    *Ports
 	IstioSrc string
@@ -83,7 +93,12 @@ const envoyConfTempl = `
             },
             "access_log": [
               {
-                "path": "{{.AccessLog}}"
+                "path": "{{.AccessLog}}"{{if .AccessLogFormat}},
+                "format": "{{.AccessLogFormat}}"{{end}}{{if .AccessLogFields}},
+                "json_format": {
+                  {{$first := true}}{{range $k, $v := .AccessLogFields}}{{if $first}}{{$first = false}}{{else}},
+                  {{end}}"{{$k}}": "{{$v}}"{{end}}
+                }{{end}}
               }
             ],
             "filters": [
@@ -134,7 +149,12 @@ const envoyConfTempl = `
             },
             "access_log": [
               {
-                "path": "{{.AccessLog}}"
+                "path": "{{.AccessLog}}"{{if .AccessLogFormat}},
+                "format": "{{.AccessLogFormat}}"{{end}}{{if .AccessLogFields}},
+                "json_format": {
+                  {{$first := true}}{{range $k, $v := .AccessLogFields}}{{if $first}}{{$first = false}}{{else}},
+                  {{end}}"{{$k}}": "{{$v}}"{{end}}
+                }{{end}}
               }
             ],
             "filters": [
@@ -253,6 +273,11 @@ func (c *confParam) write(outPath, confTmpl string) error {
 // CreateEnvoyConf create envoy config.
 func (s *TestSetup) CreateEnvoyConf(path string, stress bool, filtersBeforeMixer string, mfConfig *MixerFilterConf, ports *Ports,
 	confVersion string) error {
+	if confVersion == "v2" {
+		managementServer, _ := s.EnvoyConfigOpt["managementServer"].(string)
+		return writeV2Bootstrap(path, ports, mfConfig, managementServer)
+	}
+
 	c := &confParam{
 		ClientPort:      ports.ClientProxyPort,
 		ServerPort:      ports.ServerProxyPort,
@@ -278,6 +303,12 @@ func (s *TestSetup) CreateEnvoyConf(path string, stress bool, filtersBeforeMixer
 	if len(filtersBeforeMixer) > 0 {
 		c.FiltersBeforeMixer = filtersBeforeMixer
 	}
+	if format, ok := s.EnvoyConfigOpt["accessLogFormat"].(string); ok {
+		c.AccessLogFormat = format
+	}
+	if fields, ok := s.EnvoyConfigOpt["accessLogFields"].(map[string]string); ok {
+		c.AccessLogFields = fields
+	}
 
 	confTmpl := envoyConfTempl
 	if s.EnvoyTemplate != "" {
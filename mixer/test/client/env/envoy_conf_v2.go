@@ -0,0 +1,318 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/cluster"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	bootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
+	gogojsonpb "github.com/gogo/protobuf/jsonpb"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// structFromMessage marshals msg -- a golang/protobuf message, as MixerFilterConf's fields
+// are -- into the *types.Struct that go-control-plane v2's HttpFilter/Filter Config field
+// wants. The two proto libraries don't share a Message interface, so we bridge them through
+// JSON text instead of converting between their wire types directly.
+func structFromMessage(msg proto.Message) (*types.Struct, error) {
+	m := jsonpb.Marshaler{}
+	str, err := m.MarshalToString(msg)
+	if err != nil {
+		return nil, err
+	}
+	st := &types.Struct{}
+	if err := gogojsonpb.UnmarshalString(str, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// structFromGogoMessage is structFromMessage for a go-control-plane (gogo/protobuf) message,
+// used for filters that aren't Mixer's own config, e.g. the TCP proxy filter below.
+func structFromGogoMessage(msg gogoproto.Message) (*types.Struct, error) {
+	m := gogojsonpb.Marshaler{}
+	str, err := m.MarshalToString(msg)
+	if err != nil {
+		return nil, err
+	}
+	st := &types.Struct{}
+	if err := gogojsonpb.UnmarshalString(str, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func socketAddress(addr string, port uint16) core.Address {
+	return core.Address{
+		Address: &core.Address_SocketAddress{
+			SocketAddress: &core.SocketAddress{
+				Address: addr,
+				PortSpecifier: &core.SocketAddress_PortValue{
+					PortValue: uint32(port),
+				},
+			},
+		},
+	}
+}
+
+// httpListener builds a v2 Listener equivalent to one of envoyConfTempl's HTTP filter
+// chains: an HTTP connection manager routing everything to destCluster, with the Mixer
+// config embedded as a typed HttpFilter ahead of the router, rather than string-injected
+// JSON.
+func httpListener(name string, port uint16, destCluster string, mixerConfig *types.Struct) (*xdsapi.Listener, error) {
+	routeConfig := &xdsapi.RouteConfiguration{
+		Name: name + "_route",
+		VirtualHosts: []route.VirtualHost{
+			{
+				Name:    "backend",
+				Domains: []string{"*"},
+				Routes: []route.Route{
+					{
+						Match: route.RouteMatch{
+							PathSpecifier: &route.RouteMatch_Prefix{Prefix: "/"},
+						},
+						Action: &route.Route_Route{
+							Route: &route.RouteAction{
+								ClusterSpecifier: &route.RouteAction_Cluster{Cluster: destCluster},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	manager := &http_conn.HttpConnectionManager{
+		CodecType:  http_conn.HttpConnectionManager_AUTO,
+		StatPrefix: "ingress_http",
+		RouteSpecifier: &http_conn.HttpConnectionManager_RouteConfig{
+			RouteConfig: routeConfig,
+		},
+		HttpFilters: []*http_conn.HttpFilter{
+			{
+				Name:   "mixer",
+				Config: mixerConfig,
+			},
+			{
+				Name: "envoy.router",
+			},
+		},
+	}
+	managerStruct, err := structFromGogoMessage(manager)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xdsapi.Listener{
+		Name:    name,
+		Address: socketAddress("0.0.0.0", port),
+		FilterChains: []listener.FilterChain{
+			{
+				Filters: []listener.Filter{
+					{
+						Name:   "envoy.http_connection_manager",
+						Config: managerStruct,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// tcpProxyListener builds a v2 Listener equivalent to envoyConfTempl's TCP proxy chain:
+// Mixer as a network filter ahead of envoy.tcp_proxy, routing to destCluster.
+func tcpProxyListener(port uint16, destCluster string, mixerConfig *types.Struct) (*xdsapi.Listener, error) {
+	tcpProxy := &tcp_proxy.TcpProxy{
+		StatPrefix:       "tcp",
+		ClusterSpecifier: &tcp_proxy.TcpProxy_Cluster{Cluster: destCluster},
+	}
+	tcpProxyStruct, err := structFromGogoMessage(tcpProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xdsapi.Listener{
+		Name:    "tcp_proxy",
+		Address: socketAddress("0.0.0.0", port),
+		FilterChains: []listener.FilterChain{
+			{
+				Filters: []listener.Filter{
+					{
+						Name:   "mixer",
+						Config: mixerConfig,
+					},
+					{
+						Name:   "envoy.tcp_proxy",
+						Config: tcpProxyStruct,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// staticCluster builds a STRICT_DNS, round-robin cluster resolving to a single host:port,
+// the v2 equivalent of envoyConfTempl's cluster_manager entries.
+func staticCluster(name, host string, port uint16) *xdsapi.Cluster {
+	return &xdsapi.Cluster{
+		Name:                 name,
+		ConnectTimeout:       5 * time.Second,
+		ClusterDiscoveryType: &xdsapi.Cluster_Type{Type: xdsapi.Cluster_STRICT_DNS},
+		LbPolicy:             xdsapi.Cluster_ROUND_ROBIN,
+		Hosts: []*core.Address{
+			{
+				Address: &core.Address_SocketAddress{
+					SocketAddress: &core.SocketAddress{
+						Address: host,
+						PortSpecifier: &core.SocketAddress_PortValue{
+							PortValue: uint32(port),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// hostPort splits a "host:port" string the way confParam already formats Backend/
+// MixerServer, falling back to port 0 if it doesn't parse.
+func hostPort(addr string) (string, uint16) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	var port int
+	for _, c := range portStr {
+		if c < '0' || c > '9' {
+			return host, 0
+		}
+		port = port*10 + int(c-'0')
+	}
+	return host, uint16(port)
+}
+
+// writeV2Bootstrap builds a v2 Bootstrap equivalent of envoyConfTempl -- three listeners
+// (server/client HTTP, TCP proxy), the service1/service2/mixer_server clusters, Admin, and
+// (if managementServer is set) an ADS cluster and DynamicResources.AdsConfig pointing at
+// it -- and writes it to path as jsonpb.
+//
+// filtersBeforeMixer isn't honored here: it's raw JSON text meant to be spliced ahead of
+// the Mixer filter in envoyConfTempl, and there's no way to turn an arbitrary filter's JSON
+// into a typed HttpFilter without knowing its schema. Callers relying on it need the v1
+// template for now.
+func writeV2Bootstrap(path string, ports *Ports, mfConfig *MixerFilterConf, managementServer string) error {
+	serverMixerConfig, err := structFromMessage(mfConfig.HTTPServerConf)
+	if err != nil {
+		return err
+	}
+	clientMixerConfig, err := structFromMessage(mfConfig.HTTPClientConf)
+	if err != nil {
+		return err
+	}
+	tcpMixerConfig, err := structFromMessage(mfConfig.TCPServerConf)
+	if err != nil {
+		return err
+	}
+
+	serverListener, err := httpListener("server", ports.ServerProxyPort, "service1", serverMixerConfig)
+	if err != nil {
+		return err
+	}
+	clientListener, err := httpListener("client", ports.ClientProxyPort, "service2", clientMixerConfig)
+	if err != nil {
+		return err
+	}
+	tcpListener, err := tcpProxyListener(ports.TCPProxyPort, "service1", tcpMixerConfig)
+	if err != nil {
+		return err
+	}
+
+	bs := &bootstrap.Bootstrap{
+		Admin: bootstrap.Admin{
+			AccessLogPath: "/dev/stdout",
+			Address:       socketAddress("0.0.0.0", ports.AdminPort),
+		},
+		StaticResources: &bootstrap.Bootstrap_StaticResources{
+			Listeners: []xdsapi.Listener{*serverListener, *clientListener, *tcpListener},
+			Clusters: []xdsapi.Cluster{
+				*staticCluster("service1", "localhost", ports.BackendPort),
+				*staticCluster("service2", "localhost", ports.ServerProxyPort),
+				*mixerServerCluster("localhost", ports.MixerPort),
+			},
+		},
+	}
+
+	if managementServer != "" {
+		mgmtHost, mgmtPort := hostPort(managementServer)
+		bs.StaticResources.Clusters = append(bs.StaticResources.Clusters, *staticCluster("xds_cluster", mgmtHost, mgmtPort))
+		bs.DynamicResources = &bootstrap.Bootstrap_DynamicResources{
+			AdsConfig: &core.ApiConfigSource{
+				ApiType: core.ApiConfigSource_GRPC,
+				GrpcServices: []*core.GrpcService{
+					{
+						TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+							EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: "xds_cluster"},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	m := gogojsonpb.Marshaler{Indent: "  "}
+	str, err := m.MarshalToString(bs)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file %v: %v", path, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	_, err = f.WriteString(str)
+	return err
+}
+
+// mixerServerCluster is staticCluster plus the http2 and circuit-breaker settings
+// envoyConfTempl's mixer_server cluster carries.
+func mixerServerCluster(host string, port uint16) *xdsapi.Cluster {
+	c := staticCluster("mixer_server", host, port)
+	c.Http2ProtocolOptions = &core.Http2ProtocolOptions{}
+	c.CircuitBreakers = &cluster.CircuitBreakers{
+		Thresholds: []*cluster.CircuitBreakers_Thresholds{
+			{
+				MaxPendingRequests: &types.UInt32Value{Value: 10000},
+				MaxRequests:        &types.UInt32Value{Value: 10000},
+			},
+		},
+	}
+	return c
+}
@@ -0,0 +1,215 @@
+// Copyright 2018 Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// echoServiceName is the gRPC service exposed by GRPCServer. It implements a single
+// generic method that can be invoked as unary, server-streaming, client-streaming or
+// bidi, depending on how the test client issues the call.
+const echoServiceName = "istio.mixer.test.client.env.EchoTestService"
+
+// echoPayload is the message type exchanged with GRPCServer. It is intentionally not a
+// generated protobuf message: GRPCServer is a best-effort harness for exercising Mixer
+// filter behavior (unary/streaming/trailers/grpc-status) rather than a real service, so
+// it carries an opaque byte payload encoded with the "raw" codec registered below.
+type echoPayload []byte
+
+func (p *echoPayload) Reset()        { *p = nil }
+func (p echoPayload) String() string { return string(p) }
+func (p echoPayload) ProtoMessage()  {}
+
+// rawCodec passes payloads through unmodified, avoiding a dependency on a compiled
+// .proto for this test-only echo service.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	p, ok := v.(*echoPayload)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec: unexpected type %T", v)
+	}
+	return *p, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*echoPayload)
+	if !ok {
+		return fmt.Errorf("rawCodec: unexpected type %T", v)
+	}
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "raw" }
+
+func init() {
+	encoding.RegisterCodec(rawCodec{})
+}
+
+// GRPCResponse configures how the next call(s) made to GRPCServer are handled. Tests
+// mutate it between requests to simulate errors, trailers and slow backends.
+type GRPCResponse struct {
+	// Status is returned as the call's grpc-status; codes.OK if nil.
+	Status *status.Status
+
+	// Trailer, if set, is sent as the call's trailing metadata.
+	Trailer metadata.MD
+
+	// Delay is applied before responding, to exercise timeouts and per-try deadlines.
+	Delay time.Duration
+
+	// StreamCount is how many messages a server-streaming/bidi call echoes back per
+	// received message. Defaults to 1.
+	StreamCount int
+}
+
+// GRPCServer is a generic gRPC echo backend, the gRPC sibling of HTTPServer. It lets
+// integration tests exercise Mixer's behavior on gRPC traffic (unary, server-streaming,
+// client-streaming and bidi, including trailers and non-OK grpc-status) the same way
+// HTTPServer exercises plain HTTP.
+type GRPCServer struct {
+	port     uint16
+	listener net.Listener
+	server   *grpc.Server
+
+	mu       sync.Mutex
+	response GRPCResponse
+}
+
+// NewGRPCServer creates a new GRPCServer listening on port.
+func NewGRPCServer(port uint16) (*GRPCServer, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on gRPC backend port %d: %v", port, err)
+	}
+
+	s := &GRPCServer{
+		port:     port,
+		listener: listener,
+	}
+	s.server = grpc.NewServer()
+	s.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: echoServiceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "Echo", Handler: s.unaryEcho},
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "EchoStream", Handler: s.streamEcho, ServerStreams: true, ClientStreams: true},
+		},
+		Metadata: "env/grpc_server.go",
+	}, nil)
+
+	return s, nil
+}
+
+// SetResponse overrides how the next call(s) are handled. It is safe to call concurrently
+// with incoming requests.
+func (s *GRPCServer) SetResponse(r GRPCResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.response = r
+}
+
+func (s *GRPCServer) currentResponse() GRPCResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.response
+}
+
+// Start starts serving gRPC requests in the background.
+func (s *GRPCServer) Start() {
+	go func() {
+		_ = s.server.Serve(s.listener)
+	}()
+}
+
+// Stop gracefully stops the server.
+func (s *GRPCServer) Stop() {
+	s.server.GracefulStop()
+}
+
+func (s *GRPCServer) respond(ctx context.Context) error {
+	resp := s.currentResponse()
+	if resp.Delay > 0 {
+		time.Sleep(resp.Delay)
+	}
+	if len(resp.Trailer) > 0 {
+		_ = grpc.SetTrailer(ctx, resp.Trailer)
+	}
+	if resp.Status != nil {
+		return resp.Status.Err()
+	}
+	return nil
+}
+
+// unaryEcho echoes the request payload back to the caller, honoring the configured
+// GRPCResponse (status/trailer/delay).
+func (s *GRPCServer) unaryEcho(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(echoPayload)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if err := s.respond(ctx); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// streamEcho echoes each received message back StreamCount times (default 1), supporting
+// server-streaming, client-streaming and bidi usage from the client.
+func (s *GRPCServer) streamEcho(_ interface{}, stream grpc.ServerStream) error {
+	for {
+		req := new(echoPayload)
+		if err := stream.RecvMsg(req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		resp := s.currentResponse()
+		count := resp.StreamCount
+		if count <= 0 {
+			count = 1
+		}
+		if resp.Delay > 0 {
+			time.Sleep(resp.Delay)
+		}
+		for i := 0; i < count; i++ {
+			if err := stream.SendMsg(req); err != nil {
+				return err
+			}
+		}
+		if len(resp.Trailer) > 0 {
+			stream.SetTrailer(resp.Trailer)
+		}
+		if resp.Status != nil && resp.Status.Code() != 0 {
+			return resp.Status.Err()
+		}
+	}
+}
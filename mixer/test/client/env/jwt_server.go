@@ -0,0 +1,180 @@
+// Copyright 2018 Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWTServer is a mock JWT issuer used to exercise Envoy's JWT auth filter chained in
+// front of Mixer. It serves a JWKS document with a rotating RSA key at
+// /.well-known/jwks.json and mints RS256 tokens on demand.
+type JWTServer struct {
+	port   uint16
+	server *http.Server
+
+	mu     sync.RWMutex
+	issuer string
+	aud    string
+	kid    string
+	key    *rsa.PrivateKey
+}
+
+// NewJWTServer creates a JWTServer listening on port for the given issuer, generating an
+// initial signing key.
+func NewJWTServer(port uint16, issuer string) (*JWTServer, error) {
+	s := &JWTServer{
+		port:   port,
+		issuer: issuer,
+	}
+	if err := s.RotateKey("key1"); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jwks.json", s.serveJWKS)
+	s.server = &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+	return s, nil
+}
+
+// Start begins serving the JWKS endpoint in the background.
+func (s *JWTServer) Start() {
+	go func() {
+		_ = s.server.ListenAndServe()
+	}()
+}
+
+// Stop shuts down the server.
+func (s *JWTServer) Stop() {
+	_ = s.server.Close()
+}
+
+// JWKSURI returns the URI Envoy should be configured with to fetch this issuer's keys.
+func (s *JWTServer) JWKSURI() string {
+	return fmt.Sprintf("http://localhost:%d/.well-known/jwks.json", s.port)
+}
+
+// SetAudience sets the aud claim used by future IssueToken calls that don't supply one.
+func (s *JWTServer) SetAudience(aud string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aud = aud
+}
+
+// RotateKey generates a new RSA signing key identified by kid. Tokens minted with the
+// previous key remain verifiable only until Envoy refreshes its JWKS cache, making this
+// useful for exercising JWKS cache invalidation.
+func (s *JWTServer) RotateKey(kid string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("unable to generate RSA key: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+	s.kid = kid
+	return nil
+}
+
+// IssueToken mints a signed RS256 JWT carrying claims, plus iss/aud/iat/exp, valid for
+// ttl. claims may override aud but not iss/iat/exp/kid.
+func (s *JWTServer) IssueToken(claims map[string]interface{}, ttl time.Duration) (string, error) {
+	s.mu.RLock()
+	key, kid, issuer, aud := s.key, s.kid, s.issuer, s.aud
+	s.mu.RUnlock()
+
+	now := time.Now()
+	payload := map[string]interface{}{}
+	for k, v := range claims {
+		payload[k] = v
+	}
+	payload["iss"] = issuer
+	if _, ok := payload["aud"]; !ok && aud != "" {
+		payload["aud"] = aud
+	}
+	payload["iat"] = now.Unix()
+	payload["exp"] = now.Add(ttl).Unix()
+
+	header := map[string]interface{}{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": kid,
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal JWT header: %v", err)
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal JWT payload: %v", err)
+	}
+
+	signingInput := base64URLEncode(headerJSON) + "." + base64URLEncode(payloadJSON)
+
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("unable to sign JWT: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func base64URLEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwk is the JSON Web Key representation of the server's current RSA public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (s *JWTServer) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	s.mu.RLock()
+	key, kid := s.key, s.kid
+	s.mu.RUnlock()
+
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{
+		Keys: []jwk{{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(doc)
+}
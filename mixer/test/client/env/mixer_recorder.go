@@ -0,0 +1,110 @@
+// Copyright 2018 Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultVerifyTimeout bounds VerifyCheck/VerifyReport/VerifyQuota so that a hung Envoy
+// fails the test instead of freezing CI. It mirrors test.Eventually's own budget.
+const defaultVerifyTimeout = 10 * time.Second
+
+// recorderCapacity bounds the number of calls retained by a Recorder; oldest calls are
+// evicted once it is exceeded.
+const recorderCapacity = 1024
+
+// CallKind identifies which Mixer API a RecordedCall represents.
+type CallKind int
+
+const (
+	// CheckCallKind marks a RecordedCall captured from a Check request.
+	CheckCallKind CallKind = iota
+	// ReportCallKind marks a RecordedCall captured from a Report request.
+	ReportCallKind
+	// QuotaCallKind marks a RecordedCall captured from a Quota request.
+	QuotaCallKind
+)
+
+// RecordedCall is a single Check/Report/Quota call captured by a Recorder, with the time
+// it was received, so tests can assert on ordering and batching in addition to content.
+type RecordedCall struct {
+	Kind CallKind
+	Time time.Time
+	Bag  interface{}
+}
+
+// Recorder drains Check/Report/Quota calls from MixerServer's channels into a bounded ring
+// buffer. It replaces the unbounded, never-exiting goroutines DrainMixerAllChannels used
+// to spawn: draining stops as soon as its context is canceled.
+type Recorder struct {
+	mu    sync.Mutex
+	calls []RecordedCall
+}
+
+func newRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) record(call RecordedCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+	if len(r.calls) > recorderCapacity {
+		r.calls = r.calls[len(r.calls)-recorderCapacity:]
+	}
+}
+
+// SnapshotCalls returns a copy of every call recorded so far, for post-hoc diffing.
+func (r *Recorder) SnapshotCalls() []RecordedCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RecordedCall, len(r.calls))
+	copy(out, r.calls)
+	return out
+}
+
+// DrainUntil drains the Check/Report/Quota channels into a new Recorder until ctx is
+// canceled. Callers are responsible for canceling ctx once they're done (TestSetup.TearDown
+// does this automatically for the Recorder started by DrainMixerAllChannels).
+func (s *TestSetup) DrainUntil(ctx context.Context) *Recorder {
+	r := newRecorder()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case bag := <-s.mixer.check.ch:
+				r.record(RecordedCall{Kind: CheckCallKind, Time: time.Now(), Bag: bag})
+			case bag := <-s.mixer.report.ch:
+				r.record(RecordedCall{Kind: ReportCallKind, Time: time.Now(), Bag: bag})
+			case bag := <-s.mixer.quota.ch:
+				r.record(RecordedCall{Kind: QuotaCallKind, Time: time.Now(), Bag: bag})
+			}
+		}
+	}()
+	return r
+}
+
+// DrainMixerAllChannels drains all Check/Report/Quota channels into a Recorder for the
+// lifetime of the test; TearDown cancels the drain so the goroutine exits deterministically
+// instead of leaking.
+func (s *TestSetup) DrainMixerAllChannels() *Recorder {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.drainCancel = cancel
+	return s.DrainUntil(ctx)
+}
@@ -0,0 +1,324 @@
+// Copyright 2018 Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PromCheck identifies how an actual Prometheus sample value is compared against the
+// expected value(s) carried by a PromExpectation.
+type PromCheck int
+
+const (
+	// Equal requires the sample value to equal Value exactly.
+	Equal PromCheck = iota
+	// GreaterOrEqual requires the sample value to be >= Value.
+	GreaterOrEqual
+	// LessOrEqual requires the sample value to be <= Value.
+	LessOrEqual
+	// InRange requires the sample value to fall within [Min, Max].
+	InRange
+	// BucketCountAtLeast requires the histogram bucket with upper bound Le to have a
+	// cumulative count >= Value.
+	BucketCountAtLeast
+	// SumInRange requires a histogram's _sum sample to fall within [Min, Max].
+	SumInRange
+	// QuantileInRange requires the value at quantile Quantile, estimated from the
+	// histogram's buckets, to fall within [Min, Max].
+	QuantileInRange
+)
+
+// PromExpectation describes a single assertion against a metric scraped in Prometheus
+// exposition format.
+type PromExpectation struct {
+	// Name is the metric family name, e.g. "envoy_cluster_upstream_rq_time" or
+	// "istio_mixer_request_duration_seconds".
+	Name string
+
+	// Labels further narrows the sample within the family; every entry must match the
+	// sample's labels. "le" should not be set here for histogram checks -- use Le instead.
+	Labels map[string]string
+
+	// Check selects the comparison to perform.
+	Check PromCheck
+
+	// Value is the expected value for Equal/GreaterOrEqual/LessOrEqual, and the minimum
+	// cumulative count for BucketCountAtLeast.
+	Value float64
+
+	// Min and Max bound the accepted range for InRange, SumInRange, and QuantileInRange.
+	Min float64
+	Max float64
+
+	// Le is the histogram bucket upper bound (as formatted by Envoy/Prometheus, e.g. "100"
+	// or "+Inf") used by BucketCountAtLeast.
+	Le string
+
+	// Quantile is the target quantile in [0, 1] used by QuantileInRange.
+	Quantile float64
+}
+
+// promSample is a single label/value pair for a metric sample. name retains any
+// _bucket/_sum/_count suffix present in the exposition format.
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// metricFamily groups all samples reported under a single HELP/TYPE declaration.
+type metricFamily struct {
+	name    string
+	mtype   string
+	samples []promSample
+}
+
+var (
+	sampleLineRe = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[^}]*\})?\s+(\S+)\s*$`)
+	labelRe      = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*"((?:[^"\\]|\\.)*)"`)
+)
+
+// parsePrometheusStats parses the Prometheus text exposition format into a map of
+// metric family name to its parsed samples.
+func parsePrometheusStats(text string) map[string]*metricFamily {
+	families := make(map[string]*metricFamily)
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 {
+				name, mtype := fields[2], fields[3]
+				families[name] = &metricFamily{name: name, mtype: mtype}
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sampleName, labels, value, err := parseSampleLine(line)
+		if err != nil {
+			continue
+		}
+		familyName := familyNameForSample(sampleName, families)
+		family, ok := families[familyName]
+		if !ok {
+			family = &metricFamily{name: familyName, mtype: "untyped"}
+			families[familyName] = family
+		}
+		family.samples = append(family.samples, promSample{name: sampleName, labels: labels, value: value})
+	}
+	return families
+}
+
+// familyNameForSample strips a _bucket/_sum/_count suffix if the resulting base name was
+// already declared by a preceding "# TYPE" line, as is the case for histograms and summaries.
+func familyNameForSample(sampleName string, known map[string]*metricFamily) string {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(sampleName, suffix) {
+			base := strings.TrimSuffix(sampleName, suffix)
+			if _, ok := known[base]; ok {
+				return base
+			}
+		}
+	}
+	return sampleName
+}
+
+func parseSampleLine(line string) (string, map[string]string, float64, error) {
+	m := sampleLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", nil, 0, fmt.Errorf("cannot parse prometheus sample line: %s", line)
+	}
+	name := m[1]
+	labels := map[string]string{}
+	if m[2] != "" {
+		for _, lm := range labelRe.FindAllStringSubmatch(m[2], -1) {
+			labels[lm[1]] = strings.Replace(lm[2], `\"`, `"`, -1)
+		}
+	}
+	value, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("cannot parse prometheus sample value %q: %v", m[3], err)
+	}
+	return name, labels, value, nil
+}
+
+// labelsMatch returns true if every key/value in want is present and equal in sample.
+func labelsMatch(sample, want map[string]string) bool {
+	for k, v := range want {
+		if sample[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// findSample returns the first sample with the given (suffixed) name whose labels are a
+// superset of want.
+func (f *metricFamily) findSample(name string, want map[string]string) (promSample, bool) {
+	for _, s := range f.samples {
+		if s.name == name && labelsMatch(s.labels, want) {
+			return s, true
+		}
+	}
+	return promSample{}, false
+}
+
+// buckets returns the histogram's "_bucket" samples matching want, sorted by "le" ascending.
+func (f *metricFamily) buckets(want map[string]string) []promSample {
+	var out []promSample
+	for _, s := range f.samples {
+		if s.name == f.name+"_bucket" && labelsMatch(s.labels, want) {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return bucketBound(out[i].labels["le"]) < bucketBound(out[j].labels["le"])
+	})
+	return out
+}
+
+func bucketBound(le string) float64 {
+	if le == "+Inf" {
+		return 1e308
+	}
+	v, _ := strconv.ParseFloat(le, 64)
+	return v
+}
+
+// quantile estimates the value at the given quantile (0-1) via linear interpolation across
+// the histogram's cumulative buckets, the same approach used by Prometheus's
+// histogram_quantile() for a single series.
+func (f *metricFamily) quantile(want map[string]string, q float64) (float64, bool) {
+	buckets := f.buckets(want)
+	if len(buckets) == 0 {
+		return 0, false
+	}
+	total := buckets[len(buckets)-1].value
+	if total <= 0 {
+		return 0, false
+	}
+	rank := q * total
+
+	var prevCount, prevBound float64
+	for _, b := range buckets {
+		if b.value >= rank {
+			bound := bucketBound(b.labels["le"])
+			if bound == 1e308 || b.value == prevCount {
+				return bound, true
+			}
+			// linear interpolation within the bucket
+			frac := (rank - prevCount) / (b.value - prevCount)
+			return prevBound + frac*(bound-prevBound), true
+		}
+		prevCount = b.value
+		prevBound = bucketBound(b.labels["le"])
+	}
+	return prevBound, true
+}
+
+// VerifyPrometheusStats evaluates a list of PromExpectation against stats scraped in
+// Prometheus exposition format (e.g. from WaitForPrometheusStats), failing the test for
+// the first expectation that is not satisfied.
+func (s *TestSetup) VerifyPrometheusStats(actual string, expectations []PromExpectation) {
+	families := parsePrometheusStats(actual)
+
+	for _, e := range expectations {
+		family, ok := families[e.Name]
+		if !ok {
+			s.t.Fatalf("Failed to find expected Prometheus metric %s", e.Name)
+			continue
+		}
+
+		switch e.Check {
+		case Equal, GreaterOrEqual, LessOrEqual, InRange:
+			sample, ok := family.findSample(e.Name, e.Labels)
+			if !ok {
+				s.t.Fatalf("Failed to find Prometheus sample %s%v", e.Name, e.Labels)
+				continue
+			}
+			verifyNumericCheck(s.t, e.Name, e.Check, sample.value, e.Value, e.Min, e.Max)
+
+		case BucketCountAtLeast:
+			labels := map[string]string{"le": e.Le}
+			for k, v := range e.Labels {
+				labels[k] = v
+			}
+			sample, ok := family.findSample(e.Name+"_bucket", labels)
+			if !ok {
+				s.t.Fatalf("Failed to find bucket le=%q for metric %s%v", e.Le, e.Name, e.Labels)
+				continue
+			}
+			if sample.value < e.Value {
+				s.t.Fatalf("Metric %s bucket le=%q count %v is less than expected minimum %v",
+					e.Name, e.Le, sample.value, e.Value)
+			}
+
+		case SumInRange:
+			sample, ok := family.findSample(e.Name+"_sum", e.Labels)
+			if !ok {
+				s.t.Fatalf("Failed to find sum sample for metric %s%v", e.Name, e.Labels)
+				continue
+			}
+			if sample.value < e.Min || sample.value > e.Max {
+				s.t.Fatalf("Metric %s sum %v is not within range [%v, %v]", e.Name, sample.value, e.Min, e.Max)
+			}
+
+		case QuantileInRange:
+			v, ok := family.quantile(e.Labels, e.Quantile)
+			if !ok {
+				s.t.Fatalf("Failed to compute quantile %v for metric %s%v", e.Quantile, e.Name, e.Labels)
+				continue
+			}
+			if v < e.Min || v > e.Max {
+				s.t.Fatalf("Metric %s quantile %v value %v is not within range [%v, %v]",
+					e.Name, e.Quantile, v, e.Min, e.Max)
+			}
+		}
+	}
+}
+
+func verifyNumericCheck(t interface{ Fatalf(format string, args ...interface{}) }, name string, check PromCheck, actual, value, min, max float64) {
+	switch check {
+	case Equal:
+		if actual != value {
+			t.Fatalf("Metric %s value %v does not equal expected %v", name, actual, value)
+		}
+	case GreaterOrEqual:
+		if actual < value {
+			t.Fatalf("Metric %s value %v is less than expected minimum %v", name, actual, value)
+		}
+	case LessOrEqual:
+		if actual > value {
+			t.Fatalf("Metric %s value %v is greater than expected maximum %v", name, actual, value)
+		}
+	case InRange:
+		if actual < min || actual > max {
+			t.Fatalf("Metric %s value %v is not within range [%v, %v]", name, actual, min, max)
+		}
+	}
+}
@@ -15,9 +15,13 @@
 package env
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,6 +31,23 @@ import (
 	"istio.io/istio/pkg/test"
 )
 
+// BackendKind selects which backend server(s) TestSetup starts.
+type BackendKind int
+
+const (
+	// BackendHTTP starts only the plain HTTP backend (the default).
+	BackendHTTP BackendKind = iota
+	// BackendGRPC starts only the gRPC echo backend.
+	BackendGRPC
+	// BackendBoth starts both the HTTP and gRPC backends.
+	BackendBoth
+)
+
+// instanceCounter is a monotonic counter used to derive a unique base-id/shared-memory
+// suffix for every TestSetup instance, so that concurrent tests never collide on Envoy's
+// hot-restart shared memory segment.
+var instanceCounter uint32
+
 // TestSetup store data for a test.
 type TestSetup struct {
 	t      *testing.T
@@ -37,12 +58,24 @@ type TestSetup struct {
 	envoy              *Envoy
 	mixer              *MixerServer
 	backend            *HTTPServer
+	backendGRPC        *GRPCServer
+	backendKind        BackendKind
+	jwt                *JWTServer
+	jwtIssuer          string
+	drainCancel        context.CancelFunc
 	testName           uint16
 	stress             bool
 	filtersBeforeMixer string
 	noMixer            bool
 	mfConfVersion      string
 
+	// baseID is passed to Envoy as --base-id so that concurrent TestSetup instances do not
+	// share hot-restart shared memory (e.g. /envoy_shared_memory_<baseID>).
+	baseID uint32
+
+	// workDir is a per-instance working directory under IstioOut, removed on TearDown.
+	workDir string
+
 	// EnvoyTemplate is the bootstrap config used by envoy.
 	EnvoyTemplate string
 
@@ -75,6 +108,7 @@ func NewTestSetup(name uint16, t *testing.T) *TestSetup {
 		ports:         NewPorts(name),
 		testName:      name,
 		mfConfVersion: MixerFilterConfigV2,
+		baseID:        atomic.AddUint32(&instanceCounter, 1),
 	}
 }
 
@@ -148,10 +182,33 @@ func (s *TestSetup) SetFiltersBeforeMixer(filters string) {
 	s.filtersBeforeMixer = filters
 }
 
+// SetBackend selects which backend server(s) SetUp starts: BackendHTTP (the default),
+// BackendGRPC, or BackendBoth.
+func (s *TestSetup) SetBackend(kind BackendKind) {
+	s.backendKind = kind
+}
+
+// EnableJWT has SetUp start a mock JWT/JWKS issuer server for the given issuer, and
+// injects its issuer and JWKS URI into the Envoy template via EnvoyConfigOpt.
+func (s *TestSetup) EnableJWT(issuer string) {
+	s.jwtIssuer = issuer
+}
+
+// JWT returns the mock JWT issuer server started by EnableJWT, or nil if JWT was not
+// enabled for this test.
+func (s *TestSetup) JWT() *JWTServer {
+	return s.jwt
+}
+
 // SetUp setups Envoy, Mixer, and Backend server for test.
 func (s *TestSetup) SetUp() error {
 	var err error
-	s.envoy, err = s.NewEnvoy(s.stress, s.filtersBeforeMixer, s.mfConf, s.ports, s.epoch, s.mfConfVersion)
+	s.workDir = filepath.Join(s.IstioOut, fmt.Sprintf("testsetup-%d-%d", s.testName, s.baseID))
+	if err = os.MkdirAll(s.workDir, 0700); err != nil {
+		return fmt.Errorf("unable to create working dir %s: %v", s.workDir, err)
+	}
+
+	s.envoy, err = s.NewEnvoy(s.stress, s.filtersBeforeMixer, s.mfConf, s.ports, s.epoch, s.mfConfVersion, s.baseID, s.workDir)
 	if err != nil {
 		log.Printf("unable to create Envoy %v", err)
 	}
@@ -170,22 +227,82 @@ func (s *TestSetup) SetUp() error {
 		}
 	}
 
-	s.backend, err = NewHTTPServer(s.ports.BackendPort)
-	if err != nil {
-		log.Printf("unable to create HTTP server %v", err)
-	} else {
-		s.backend.Start()
+	if s.backendKind == BackendHTTP || s.backendKind == BackendBoth {
+		s.backend, err = NewHTTPServer(s.ports.BackendPort)
+		if err != nil {
+			log.Printf("unable to create HTTP server %v", err)
+		} else {
+			s.backend.Start()
+		}
+	}
+
+	if s.backendKind == BackendGRPC || s.backendKind == BackendBoth {
+		s.backendGRPC, err = NewGRPCServer(s.ports.BackendGRPCPort)
+		if err != nil {
+			log.Printf("unable to create gRPC backend server %v", err)
+		} else {
+			s.backendGRPC.Start()
+			if s.EnvoyConfigOpt == nil {
+				s.EnvoyConfigOpt = map[string]interface{}{}
+			}
+			// Let a custom EnvoyTemplate route to the gRPC backend cluster with HTTP/2
+			// upstream enabled.
+			s.EnvoyConfigOpt["BackendGRPCPort"] = s.ports.BackendGRPCPort
+			s.EnvoyConfigOpt["BackendGRPCUpstream"] = true
+		}
+	}
+
+	if s.jwtIssuer != "" {
+		s.jwt, err = NewJWTServer(s.ports.JWTPort, s.jwtIssuer)
+		if err != nil {
+			log.Printf("unable to create JWT server %v", err)
+		} else {
+			s.jwt.Start()
+			if s.EnvoyConfigOpt == nil {
+				s.EnvoyConfigOpt = map[string]interface{}{}
+			}
+			s.EnvoyConfigOpt["JWTIssuer"] = s.jwtIssuer
+			s.EnvoyConfigOpt["JWTJwksURI"] = s.jwt.JWKSURI()
+		}
 	}
+
 	return err
 }
 
-// TearDown shutdown the servers.
+// TearDown shutdown the servers. It always attempts to remove the per-test working
+// directory and any Envoy hot-restart shared memory artifacts, even if stopping the
+// servers above fails partway through.
 func (s *TestSetup) TearDown() {
+	defer s.cleanupWorkDir()
+	if s.drainCancel != nil {
+		s.drainCancel()
+	}
 	_ = s.envoy.Stop()
 	if s.mixer != nil {
 		s.mixer.Stop()
 	}
-	s.backend.Stop()
+	if s.backend != nil {
+		s.backend.Stop()
+	}
+	if s.backendGRPC != nil {
+		s.backendGRPC.Stop()
+	}
+	if s.jwt != nil {
+		s.jwt.Stop()
+	}
+}
+
+// cleanupWorkDir recursively removes the per-test working directory, including any
+// Envoy hot-restart shared memory files left behind by a partial or failed run.
+func (s *TestSetup) cleanupWorkDir() {
+	if s.workDir == "" {
+		return
+	}
+	if err := os.RemoveAll(s.workDir); err != nil {
+		log.Printf("unable to remove working dir %s: %v", s.workDir, err)
+	}
+	sharedMemPath := fmt.Sprintf("/envoy_shared_memory_%d", s.baseID)
+	_ = os.RemoveAll(sharedMemPath)
 }
 
 // ReStartEnvoy restarts Envoy
@@ -195,7 +312,7 @@ func (s *TestSetup) ReStartEnvoy() {
 	log.Printf("new allocated ports are %v:", s.ports)
 	var err error
 	s.epoch++
-	s.envoy, err = s.NewEnvoy(s.stress, s.filtersBeforeMixer, s.mfConf, s.ports, s.epoch, s.mfConfVersion)
+	s.envoy, err = s.NewEnvoy(s.stress, s.filtersBeforeMixer, s.mfConf, s.ports, s.epoch, s.mfConfVersion, s.baseID, s.workDir)
 	if err != nil {
 		s.t.Errorf("unable to re-start Envoy %v", err)
 	} else {
@@ -217,34 +334,78 @@ func (s *TestSetup) VerifyReportCount(tag string, expected int) {
 	})
 }
 
-// VerifyCheck verifies Check request data.
+// VerifyCheck verifies Check request data, waiting up to defaultVerifyTimeout for the call
+// to arrive so a hung Envoy fails the test instead of blocking CI forever.
 func (s *TestSetup) VerifyCheck(tag string, result string) {
-	bag := <-s.mixer.check.ch
-	if err := Verify(bag, result); err != nil {
-		s.t.Fatalf("Failed to verify %s check: %v\n, Attributes: %+v",
-			tag, err, bag)
+	s.VerifyCheckTimeout(tag, result, defaultVerifyTimeout)
+}
+
+// VerifyCheckTimeout is VerifyCheck with an explicit timeout, and returns the recorded
+// call so tests can perform additional assertions (ordering, attribute deltas, etc.).
+func (s *TestSetup) VerifyCheckTimeout(tag string, result string, timeout time.Duration) *RecordedCall {
+	select {
+	case bag := <-s.mixer.check.ch:
+		if err := Verify(bag, result); err != nil {
+			s.t.Fatalf("Failed to verify %s check: %v\n, Attributes: %+v",
+				tag, err, bag)
+		}
+		return &RecordedCall{Kind: CheckCallKind, Time: time.Now(), Bag: bag}
+	case <-time.After(timeout):
+		s.t.Fatalf("Timed out after %v waiting for %s check call", timeout, tag)
+		return nil
 	}
 }
 
-// VerifyReport verifies Report request data.
+// VerifyCheckAttribute verifies that a single attribute reached Mixer on the Check call,
+// e.g. a "request.auth.*" attribute propagated by Envoy's JWT auth filter, without having
+// to specify the full expected attribute set.
+func (s *TestSetup) VerifyCheckAttribute(tag, key, expected string) {
+	result := fmt.Sprintf("{%q: %q}", key, expected)
+	s.VerifyCheckTimeout(tag, result, defaultVerifyTimeout)
+}
+
+// VerifyReport verifies Report request data, waiting up to defaultVerifyTimeout for the
+// call to arrive.
 func (s *TestSetup) VerifyReport(tag string, result string) {
-	bag := <-s.mixer.report.ch
-	if err := Verify(bag, result); err != nil {
-		s.t.Fatalf("Failed to verify %s report: %v\n, Attributes: %+v",
-			tag, err, bag)
+	s.VerifyReportTimeout(tag, result, defaultVerifyTimeout)
+}
+
+// VerifyReportTimeout is VerifyReport with an explicit timeout, and returns the recorded
+// call so tests can perform additional assertions.
+func (s *TestSetup) VerifyReportTimeout(tag string, result string, timeout time.Duration) *RecordedCall {
+	select {
+	case bag := <-s.mixer.report.ch:
+		if err := Verify(bag, result); err != nil {
+			s.t.Fatalf("Failed to verify %s report: %v\n, Attributes: %+v",
+				tag, err, bag)
+		}
+		return &RecordedCall{Kind: ReportCallKind, Time: time.Now(), Bag: bag}
+	case <-time.After(timeout):
+		s.t.Fatalf("Timed out after %v waiting for %s report call", timeout, tag)
+		return nil
 	}
 }
 
-// VerifyQuota verified Quota request data.
+// VerifyQuota verifies Quota request data, waiting up to defaultVerifyTimeout for the call
+// to arrive.
 func (s *TestSetup) VerifyQuota(tag string, name string, amount int64) {
-	<-s.mixer.quota.ch
-	if s.mixer.qma.Quota != name {
-		s.t.Fatalf("Failed to verify %s quota name: %v, expected: %v\n",
-			tag, s.mixer.qma.Quota, name)
-	}
-	if s.mixer.qma.Amount != amount {
-		s.t.Fatalf("Failed to verify %s quota amount: %v, expected: %v\n",
-			tag, s.mixer.qma.Amount, amount)
+	s.VerifyQuotaTimeout(tag, name, amount, defaultVerifyTimeout)
+}
+
+// VerifyQuotaTimeout is VerifyQuota with an explicit timeout.
+func (s *TestSetup) VerifyQuotaTimeout(tag string, name string, amount int64, timeout time.Duration) {
+	select {
+	case <-s.mixer.quota.ch:
+		if s.mixer.qma.Quota != name {
+			s.t.Fatalf("Failed to verify %s quota name: %v, expected: %v\n",
+				tag, s.mixer.qma.Quota, name)
+		}
+		if s.mixer.qma.Amount != amount {
+			s.t.Fatalf("Failed to verify %s quota amount: %v, expected: %v\n",
+				tag, s.mixer.qma.Amount, amount)
+		}
+	case <-time.After(timeout):
+		s.t.Fatalf("Timed out after %v waiting for %s quota call", timeout, tag)
 	}
 }
 
@@ -263,6 +424,21 @@ func (s *TestSetup) WaitForStatsUpdateAndGetStats(waitDuration int) (string, err
 	return respBody, nil
 }
 
+// WaitForPrometheusStats waits for waitDuration seconds to let Envoy update stats, and sends
+// a request to Envoy for stats in Prometheus exposition format. Returns the raw response body.
+func (s *TestSetup) WaitForPrometheusStats(waitDuration int) (string, error) {
+	time.Sleep(time.Duration(waitDuration) * time.Second)
+	statsURL := fmt.Sprintf("http://localhost:%d/stats/prometheus", s.Ports().AdminPort)
+	code, respBody, err := HTTPGet(statsURL)
+	if err != nil {
+		return "", fmt.Errorf("sending prometheus stats request returns an error: %v", err)
+	}
+	if code != 200 {
+		return "", fmt.Errorf("sending prometheus stats request returns unexpected status code: %d", code)
+	}
+	return respBody, nil
+}
+
 type statEntry struct {
 	Name  string `json:"name"`
 	Value int    `json:"value"`
@@ -321,22 +497,3 @@ func (s *TestSetup) VerifyStatsLT(actualStats string, expectedStat string, expec
 		log.Printf("stat %s is matched. %d < %d", expectedStat, aStatsValue, expectedStatVal)
 	}
 }
-
-// DrainMixerAllChannels drain all channels
-func (s *TestSetup) DrainMixerAllChannels() {
-	go func() {
-		for {
-			<-s.mixer.check.ch
-		}
-	}()
-	go func() {
-		for {
-			<-s.mixer.report.ch
-		}
-	}()
-	go func() {
-		for {
-			<-s.mixer.quota.ch
-		}
-	}()
-}
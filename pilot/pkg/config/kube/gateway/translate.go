@@ -0,0 +1,262 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gateway translates Kubernetes Gateway API (gateway.networking.k8s.io) HTTPRoute
+// configs already present in an IstioConfigStore into synthesized VirtualServices, so the rest
+// of pilot can keep treating Gateway API routing identically to native VirtualServices.
+//
+// There's no CRD watching/client-go wiring in this package -- this tree has no k8s.io dependency
+// anywhere (see model.HTTPRoute's doc comment) -- so HTTPRoute configs must already be in the
+// store by the time VirtualServices below runs, the same way every other config type gets there.
+package gateway
+
+import (
+	"fmt"
+	"sort"
+
+	networking "istio.io/api/networking/v1alpha3"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/log"
+)
+
+// VirtualServices returns store's native VirtualServices(gateways) together with one
+// synthesized VirtualService per (parent Gateway, hostname) group of HTTPRoute configs held in
+// store, filtered to gateways the same way the native ones are.
+//
+// This can't be wired directly into istioConfigStore.VirtualServices: that would have model
+// import this package for the translation, while this package already imports model for
+// IstioConfigStore and Config -- an import cycle. Call this in its place wherever Gateway API
+// HTTPRoutes need to be visible alongside native VirtualServices.
+func VirtualServices(store model.IstioConfigStore, gateways map[string]bool) []model.Config {
+	out := store.VirtualServices(gateways)
+
+	synthesized, err := Translate(store)
+	if err != nil {
+		log.Warnf("gateway: could not translate HTTPRoutes to VirtualServices: %v", err)
+		return out
+	}
+
+	for _, cfg := range synthesized {
+		vs := cfg.Spec.(*networking.VirtualService)
+		for _, g := range vs.Gateways {
+			if gateways[g] {
+				out = append(out, cfg)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Translate converts every HTTPRoute config in store into synthesized VirtualService configs,
+// grouping rules by (parent Gateway, hostname) and ordering each group's rules with
+// SortHTTPRouteRules before emitting them as the VirtualService's Http routes.
+func Translate(store model.IstioConfigStore) ([]model.Config, error) {
+	routes, err := store.List(model.HTTPRoute.Type, model.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+
+	type groupKey struct {
+		gateway  string
+		hostname string
+	}
+	rulesByGroup := map[groupKey][]model.HTTPRouteRule{}
+	var order []groupKey
+
+	for _, cfg := range routes {
+		route, ok := cfg.Spec.(*model.HTTPRoute)
+		if !ok {
+			continue
+		}
+		hostnames := route.Hostnames
+		if len(hostnames) == 0 {
+			hostnames = []string{"*"}
+		}
+		for _, gw := range route.ParentGateways {
+			for _, host := range hostnames {
+				key := groupKey{gateway: gw, hostname: host}
+				if _, seen := rulesByGroup[key]; !seen {
+					order = append(order, key)
+				}
+				rulesByGroup[key] = append(rulesByGroup[key], route.Rules...)
+			}
+		}
+	}
+
+	out := make([]model.Config, 0, len(order))
+	for _, key := range order {
+		rules := rulesByGroup[key]
+		SortHTTPRouteRules(rules)
+
+		vs := &networking.VirtualService{
+			Hosts:    []string{key.hostname},
+			Gateways: []string{key.gateway},
+			Http:     make([]*networking.HTTPRoute, 0, len(rules)),
+		}
+		for _, rule := range rules {
+			vs.Http = append(vs.Http, toNetworkingHTTPRoute(rule))
+		}
+
+		out = append(out, model.Config{
+			ConfigMeta: model.ConfigMeta{
+				Type:      model.VirtualService.Type,
+				Group:     model.VirtualService.Group,
+				Version:   model.VirtualService.Version,
+				Name:      fmt.Sprintf("httproute-%s-%s", key.gateway, key.hostname),
+				Namespace: model.NamespaceAll,
+			},
+			Spec: vs,
+		})
+	}
+	return out, nil
+}
+
+// SortHTTPRouteRules orders rules by Gateway API match-specificity, descending, so that the
+// first-match-wins VirtualService emitted from them preserves the Gateway API rule "most
+// specific match wins, ties broken by earlier occurrence" -- for each rule the specificity
+// tuple is (pathRank, methodBonus, headerCount, queryCount), with pathRank 3 for Exact, 2 for
+// RegularExpression, 1 for PathPrefix, 0 if unset, and methodBonus 1 if any match sets a method.
+//
+// This takes the rule slice directly rather than []model.Config, the shape SortRouteRules uses:
+// unlike a RouteRule, a Gateway API rule isn't independently stored -- many rules live inside
+// one HTTPRoute config -- so wrapping each rule in a throwaway Config here would add nothing but
+// ceremony. sort.SliceStable already preserves declaration order for equal-specificity rules, so
+// there's no need to thread through an explicit -ruleIndex tie-breaker.
+func SortHTTPRouteRules(rules []model.HTTPRouteRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return ruleSpecificity(rules[j]).less(ruleSpecificity(rules[i]))
+	})
+}
+
+// specificity is the (pathRank, methodBonus, headerCount, queryCount) tuple, compared
+// lexicographically by less. Its path ranking differs from model.HTTPMatchSpecificity, which
+// matchSpecificity in networking/core/v1alpha3/route uses: that one ranks a prefix match above a
+// regex match, while pathRank here ranks RegularExpression above PathPrefix.
+type specificity struct {
+	pathRank    int
+	methodBonus int
+	headerCount int
+	queryCount  int
+}
+
+func (s specificity) less(o specificity) bool {
+	if s.pathRank != o.pathRank {
+		return s.pathRank < o.pathRank
+	}
+	if s.methodBonus != o.methodBonus {
+		return s.methodBonus < o.methodBonus
+	}
+	if s.headerCount != o.headerCount {
+		return s.headerCount < o.headerCount
+	}
+	return s.queryCount < o.queryCount
+}
+
+// ruleSpecificity scores a rule by the most specific of its (OR'd) matches, taking the one match
+// whose whole tuple ranks highest under less rather than the best value per dimension.
+func ruleSpecificity(rule model.HTTPRouteRule) specificity {
+	var best specificity
+	if len(rule.Matches) == 0 {
+		return best
+	}
+	for i, m := range rule.Matches {
+		s := specificity{
+			pathRank:    pathMatchRank(m.Path),
+			methodBonus: methodBonus(m.Method),
+			headerCount: len(m.Headers),
+			queryCount:  len(m.QueryParams),
+		}
+		if i == 0 || best.less(s) {
+			best = s
+		}
+	}
+	return best
+}
+
+func pathMatchRank(path *model.HTTPPathMatch) int {
+	if path == nil {
+		return 0
+	}
+	switch path.Type {
+	case model.PathMatchExact:
+		return 3
+	case model.PathMatchRegularExpression:
+		return 2
+	case model.PathMatchPathPrefix:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func methodBonus(method string) int {
+	if method == "" {
+		return 0
+	}
+	return 1
+}
+
+// toNetworkingHTTPRoute converts one Gateway API rule into the VirtualService HTTPRoute it
+// becomes once the owning group has been sorted into emission order.
+func toNetworkingHTTPRoute(rule model.HTTPRouteRule) *networking.HTTPRoute {
+	out := &networking.HTTPRoute{}
+	for _, m := range rule.Matches {
+		out.Match = append(out.Match, toHTTPMatchRequest(m))
+	}
+	for _, b := range rule.BackendRefs {
+		out.Route = append(out.Route, &networking.HTTPRouteDestination{
+			Destination: &networking.Destination{
+				Host: b.Name,
+				Port: &networking.PortSelector{
+					Port: &networking.PortSelector_Number{Number: b.Port},
+				},
+			},
+			Weight: b.Weight,
+		})
+	}
+	return out
+}
+
+func toHTTPMatchRequest(m model.HTTPRouteMatch) *networking.HTTPMatchRequest {
+	match := &networking.HTTPMatchRequest{}
+	if m.Path != nil {
+		match.Uri = toStringMatch(m.Path.Type, m.Path.Value)
+	}
+	if m.Method != "" {
+		match.Method = &networking.StringMatch{MatchType: &networking.StringMatch_Exact{Exact: m.Method}}
+	}
+	if len(m.Headers) > 0 {
+		match.Headers = make(map[string]*networking.StringMatch, len(m.Headers))
+		for _, h := range m.Headers {
+			match.Headers[h.Name] = &networking.StringMatch{MatchType: &networking.StringMatch_Exact{Exact: h.Value}}
+		}
+	}
+	// NOTE: HTTPMatchRequest at this API vintage has no query-parameter match field, so
+	// m.QueryParams only ever contributes to ruleSpecificity's ordering -- it can't be carried
+	// into the synthesized VirtualService's match itself.
+	return match
+}
+
+func toStringMatch(pathType model.PathMatchType, value string) *networking.StringMatch {
+	switch pathType {
+	case model.PathMatchExact:
+		return &networking.StringMatch{MatchType: &networking.StringMatch_Exact{Exact: value}}
+	case model.PathMatchRegularExpression:
+		return &networking.StringMatch{MatchType: &networking.StringMatch_Regex{Regex: value}}
+	default:
+		return &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: value}}
+	}
+}
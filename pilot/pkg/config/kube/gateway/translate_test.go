@@ -0,0 +1,147 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gateway
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+func pathRule(pathType model.PathMatchType) model.HTTPRouteRule {
+	return model.HTTPRouteRule{
+		Matches:     []model.HTTPRouteMatch{{Path: &model.HTTPPathMatch{Type: pathType, Value: "/api"}}},
+		BackendRefs: []model.HTTPBackendRef{{Name: "reviews", Port: 9080}},
+	}
+}
+
+// TestSortHTTPRouteRulesPathRank asserts Exact beats RegularExpression beats PathPrefix beats an
+// unset path match.
+func TestSortHTTPRouteRulesPathRank(t *testing.T) {
+	exact := pathRule(model.PathMatchExact)
+	regex := pathRule(model.PathMatchRegularExpression)
+	prefix := pathRule(model.PathMatchPathPrefix)
+	none := model.HTTPRouteRule{BackendRefs: []model.HTTPBackendRef{{Name: "reviews", Port: 9080}}}
+
+	rules := []model.HTTPRouteRule{none, prefix, regex, exact}
+	SortHTTPRouteRules(rules)
+
+	want := []model.HTTPRouteRule{exact, regex, prefix, none}
+	for i := range want {
+		if got, w := ruleSpecificity(rules[i]), ruleSpecificity(want[i]); got != w {
+			t.Errorf("position %d: expected specificity %+v, got %+v", i, w, got)
+		}
+	}
+}
+
+// TestSortHTTPRouteRulesMethodBreaksPathTie asserts that among rules sharing a path rank, the
+// one with a Method constraint outranks the one without.
+func TestSortHTTPRouteRulesMethodBreaksPathTie(t *testing.T) {
+	withMethod := model.HTTPRouteRule{
+		Matches:     []model.HTTPRouteMatch{{Path: &model.HTTPPathMatch{Type: model.PathMatchPathPrefix, Value: "/api"}, Method: "GET"}},
+		BackendRefs: []model.HTTPBackendRef{{Name: "reviews", Port: 9080}},
+	}
+	withoutMethod := pathRule(model.PathMatchPathPrefix)
+
+	rules := []model.HTTPRouteRule{withoutMethod, withMethod}
+	SortHTTPRouteRules(rules)
+
+	if ruleSpecificity(rules[0]) != ruleSpecificity(withMethod) {
+		t.Errorf("expected the method-constrained rule to sort first")
+	}
+}
+
+// TestSortHTTPRouteRulesStableOnTies asserts equally-specific rules keep their original
+// relative order, matching the Gateway API "ties broken by earlier occurrence" rule.
+func TestSortHTTPRouteRulesStableOnTies(t *testing.T) {
+	first := model.HTTPRouteRule{BackendRefs: []model.HTTPBackendRef{{Name: "first", Port: 9080}}}
+	second := model.HTTPRouteRule{BackendRefs: []model.HTTPBackendRef{{Name: "second", Port: 9080}}}
+
+	rules := []model.HTTPRouteRule{first, second}
+	SortHTTPRouteRules(rules)
+
+	if rules[0].BackendRefs[0].Name != "first" || rules[1].BackendRefs[0].Name != "second" {
+		t.Errorf("expected tied rules to keep their original order [first, second], got [%s, %s]",
+			rules[0].BackendRefs[0].Name, rules[1].BackendRefs[0].Name)
+	}
+}
+
+// TestTranslateGroupsByGatewayAndHostnameAndSorts asserts Translate groups rules from possibly
+// several HTTPRoutes by (parent Gateway, hostname) into one VirtualService per group, with the
+// group's rules already sorted by specificity.
+func TestTranslateGroupsByGatewayAndHostnameAndSorts(t *testing.T) {
+	store := &fakeConfigStore{configs: []model.Config{
+		{
+			ConfigMeta: model.ConfigMeta{Type: model.HTTPRoute.Type, Name: "low-then-high"},
+			Spec: &model.HTTPRoute{
+				ParentGateways: []string{"my-gateway"},
+				Hostnames:      []string{"reviews.example.com"},
+				Rules: []model.HTTPRouteRule{
+					pathRule(model.PathMatchPathPrefix),
+					pathRule(model.PathMatchExact),
+				},
+			},
+		},
+		{
+			ConfigMeta: model.ConfigMeta{Type: model.HTTPRoute.Type, Name: "other-host"},
+			Spec: &model.HTTPRoute{
+				ParentGateways: []string{"my-gateway"},
+				Hostnames:      []string{"ratings.example.com"},
+				Rules:          []model.HTTPRouteRule{pathRule(model.PathMatchExact)},
+			},
+		},
+	}}
+
+	out, err := Translate(store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("expected 2 synthesized VirtualServices, got %d", len(out))
+	}
+
+	vs := out[0].Spec.(*networking.VirtualService)
+	if len(vs.Gateways) != 1 || vs.Gateways[0] != "my-gateway" {
+		t.Errorf("expected Gateways [my-gateway], got %v", vs.Gateways)
+	}
+	if len(vs.Hosts) != 1 || vs.Hosts[0] != "reviews.example.com" {
+		t.Errorf("expected Hosts [reviews.example.com], got %v", vs.Hosts)
+	}
+	if len(vs.Http) != 2 {
+		t.Fatalf("expected 2 Http routes, got %d", len(vs.Http))
+	}
+	if _, ok := vs.Http[0].Match[0].Uri.MatchType.(*networking.StringMatch_Exact); !ok {
+		t.Errorf("expected the Exact-path rule to be emitted first, got %+v", vs.Http[0].Match[0].Uri)
+	}
+}
+
+// fakeConfigStore implements just enough of model.IstioConfigStore for Translate's store.List
+// call -- List is the only method Translate uses, so every other method panics if called.
+type fakeConfigStore struct {
+	model.IstioConfigStore
+	configs []model.Config
+}
+
+func (f *fakeConfigStore) List(typ, namespace string) ([]model.Config, error) {
+	var out []model.Config
+	for _, c := range f.configs {
+		if c.Type == typ {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
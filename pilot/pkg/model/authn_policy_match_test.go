@@ -0,0 +1,132 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	authn "istio.io/api/authentication/v1alpha1"
+)
+
+// These tests exercise matchAuthenticationPolicy directly rather than
+// AuthenticationPolicyByDestination: the latter's *Service and *Port parameters are types whose
+// defining files aren't part of this snapshot (grep turns up no "type Service struct" or "type
+// Port struct" anywhere in pilot/pkg/model), so there's no confirmed way to build a fixture for
+// them. matchAuthenticationPolicy only ever dereferences port when a Target sets Ports, so every
+// case below leaves that unset and passes a nil *Port -- matchAuthenticationPolicy is where all
+// of the level 2/3/4 matching logic this request adds actually lives; mesh-level (1) fallback is
+// AuthenticationPolicyByDestination's one extra line and isn't reachable without a *Service.
+
+func namespaceScopedPolicy(name string) Config {
+	return Config{
+		ConfigMeta: ConfigMeta{Type: AuthenticationPolicy.Type, Namespace: "bookinfo", Name: name},
+		Spec:       &authn.Policy{},
+	}
+}
+
+func serviceScopedPolicy(name, targetName string) Config {
+	return Config{
+		ConfigMeta: ConfigMeta{Type: AuthenticationPolicy.Type, Namespace: "bookinfo", Name: name},
+		Spec:       &authn.Policy{Targets: []*authn.TargetSelector{{Name: targetName}}},
+	}
+}
+
+func selectorScopedPolicy(name, targetName string, selector Labels) Config {
+	cfg := serviceScopedPolicy(name, targetName)
+	encoded := ""
+	for k, v := range selector {
+		if encoded != "" {
+			encoded += ","
+		}
+		encoded += k + "=" + v
+	}
+	cfg.Annotations = map[string]string{authnTargetWorkloadSelectorAnnotation: targetName + "@" + encoded}
+	return cfg
+}
+
+// TestMatchAuthenticationPolicyServiceBeatsNamespace asserts a service-scoped Target (level 3)
+// outranks a namespace-scoped policy with no Targets (level 2) for a matching hostname.
+func TestMatchAuthenticationPolicyServiceBeatsNamespace(t *testing.T) {
+	ns := namespaceScopedPolicy("ns-default")
+	svc := serviceScopedPolicy("reviews-policy", "reviews")
+
+	out, level := matchAuthenticationPolicy([]Config{ns, svc}, "reviews.bookinfo", nil, nil)
+	if level != 3 {
+		t.Fatalf("expected match level 3, got %d", level)
+	}
+	if out.Name != "reviews-policy" {
+		t.Errorf("expected the service-scoped policy to win, got %q", out.Name)
+	}
+}
+
+// TestMatchAuthenticationPolicySelectorBeatsPlainService asserts a Target whose WorkloadSelector
+// matches workloadLabels (level 4) outranks a plain service-scoped Target (level 3) for the
+// same hostname.
+func TestMatchAuthenticationPolicySelectorBeatsPlainService(t *testing.T) {
+	plain := serviceScopedPolicy("plain", "reviews")
+	selector := selectorScopedPolicy("selected", "reviews", Labels{"version": "v2"})
+
+	workloadLabels := Labels{"version": "v2", "app": "reviews"}
+	out, level := matchAuthenticationPolicy([]Config{plain, selector}, "reviews.bookinfo", nil, workloadLabels)
+	if level != 4 {
+		t.Fatalf("expected match level 4, got %d", level)
+	}
+	if out.Name != "selected" {
+		t.Errorf("expected the selector-matching policy to win, got %q", out.Name)
+	}
+}
+
+// TestMatchAuthenticationPolicySelectorMismatchFallsBackToPlain asserts a Target whose
+// WorkloadSelector does NOT match workloadLabels is skipped entirely, leaving a plain
+// service-scoped Target for the same hostname as the best match.
+func TestMatchAuthenticationPolicySelectorMismatchFallsBackToPlain(t *testing.T) {
+	plain := serviceScopedPolicy("plain", "reviews")
+	selector := selectorScopedPolicy("selected", "reviews", Labels{"version": "v2"})
+
+	workloadLabels := Labels{"version": "v1", "app": "reviews"}
+	out, level := matchAuthenticationPolicy([]Config{plain, selector}, "reviews.bookinfo", nil, workloadLabels)
+	if level != 3 {
+		t.Fatalf("expected match level 3 (selector mismatch skipped), got %d", level)
+	}
+	if out.Name != "plain" {
+		t.Errorf("expected the plain service-scoped policy to be the fallback match, got %q", out.Name)
+	}
+}
+
+// TestMatchAuthenticationPolicyNoMatchReturnsZero asserts an unrelated hostname yields no match
+// at all, leaving mesh-level fallback to the caller.
+func TestMatchAuthenticationPolicyNoMatchReturnsZero(t *testing.T) {
+	svc := serviceScopedPolicy("reviews-policy", "reviews")
+
+	out, level := matchAuthenticationPolicy([]Config{svc}, "ratings.bookinfo", nil, nil)
+	if level != 0 || out != nil {
+		t.Errorf("expected no match for an unrelated hostname, got %v at level %d", out, level)
+	}
+}
+
+// TestMatchAuthenticationPolicyTiesBrokenByKey asserts two namespace-scoped policies (same
+// match level) are broken deterministically by the smaller Config.Key().
+func TestMatchAuthenticationPolicyTiesBrokenByKey(t *testing.T) {
+	zzz := namespaceScopedPolicy("zzz")
+	aaa := namespaceScopedPolicy("aaa")
+
+	out, level := matchAuthenticationPolicy([]Config{zzz, aaa}, "reviews.bookinfo", nil, nil)
+	if level != 2 {
+		t.Fatalf("expected match level 2, got %d", level)
+	}
+	if out.Name != "aaa" {
+		t.Errorf("expected the tie broken towards the smaller Config.Key() (%q), got %q", "aaa", out.Name)
+	}
+}
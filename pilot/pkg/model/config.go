@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/golang/protobuf/proto"
 
@@ -265,8 +266,23 @@ type IstioConfigStore interface {
 	// the source instances.  The labels must match precisely in the policy.
 	Policy(source []*ServiceInstance, destination string, labels Labels) *Config
 
-	// DestinationRule returns a destination rule for a service name in a given domain.
-	DestinationRule(hostname Hostname) *Config
+	// DestinationRule returns a destination rule for a service name in a given domain, visible
+	// to a proxy in proxyNamespace: a namespace-local rule first, then one exported to
+	// proxyNamespace, then one in DefaultMeshRootNamespace.
+	DestinationRule(hostname Hostname, proxyNamespace string) *Config
+
+	// DestinationRuleForWorkload is DestinationRule narrowed further by workloadLabels,
+	// preferring one whose workloadSelector matches workloadLabels over one with no selector at
+	// all within whichever of DestinationRule's three tiers matches first, so sidecar-specific
+	// traffic policy (e.g. per-canary connection-pool tuning) can override the namespace- or
+	// mesh-wide rule without splitting the service.
+	DestinationRuleForWorkload(hostname Hostname, proxyNamespace string, workloadLabels Labels) *Config
+
+	// MergedDestinationRule unions the Subsets of every DestinationRule visible to proxyNamespace
+	// for hostname across DestinationRule's three tiers, with a namespace-local subset shadowing
+	// an exported one of the same name, and an exported one shadowing a root-namespace one. Its
+	// other fields (TrafficPolicy, Host) come from the most specific tier that matched.
+	MergedDestinationRule(hostname Hostname, proxyNamespace string) *Config
 
 	// VirtualServices lists all virtual services bound to the specified gateways
 	VirtualServices(gateways map[string]bool) []Config
@@ -274,8 +290,11 @@ type IstioConfigStore interface {
 	// Gateways lists all gateways bound to the specified workload labels
 	Gateways(workloadLabels LabelsCollection) []Config
 
-	// SubsetToLabels returns the labels associated with a subset of a given service.
-	SubsetToLabels(subsetName string, hostname Hostname) LabelsCollection
+	// SubsetToLabels returns the labels associated with a subset of a given service, visible to
+	// proxyNamespace (see DestinationRule). If workloadLabels is supplied, the subset is resolved
+	// from the DestinationRule selected for that workload (see DestinationRuleForWorkload)
+	// instead of the namespace- or mesh-wide one.
+	SubsetToLabels(subsetName string, hostname Hostname, proxyNamespace string, workloadLabels ...Labels) LabelsCollection
 
 	// HTTPAPISpecByDestination selects Mixerclient HTTP API Specs
 	// associated with destination service instances.
@@ -285,13 +304,18 @@ type IstioConfigStore interface {
 	// associated with destination service instances.
 	QuotaSpecByDestination(instance *ServiceInstance) []Config
 
-	// AuthenticationPolicyByDestination selects authentication policy associated
-	// with a service + port. Hostname must be FQDN.
-	// If there are more than one policies at different scopes (global, namespace, service)
-	// the one with the most specific scope will be selected. If there are more than
-	// one with the same scope, the first one seen will be used (later, we should
-	// have validation at submitting time to prevent this scenario from happening)
-	AuthenticationPolicyByDestination(hostname Hostname, port *Port) *Config
+	// AuthenticationPolicyByDestination selects the authentication policy that applies to
+	// service on port, narrowed further by workloadLabels if non-empty. Match level, most to
+	// least specific, is: (4) a service-matching Target whose WorkloadSelector matches
+	// workloadLabels, (3) a service-matching Target with no WorkloadSelector, (2) a
+	// namespace-wide policy (no Targets) in service's namespace, (1) the cluster-wide
+	// MeshPolicy. Ties within the same level are broken by Config.Key() for determinism.
+	AuthenticationPolicyByDestination(service *Service, port *Port, workloadLabels Labels) *Config
+
+	// SetPushContext installs push as the precomputed snapshot every subsequent call on this store
+	// consults instead of re-listing the backing ConfigStore; pass nil to go back to listing it
+	// directly. See PushContext for what gets precomputed and why.
+	SetPushContext(push *PushContext)
 }
 
 const (
@@ -319,6 +343,11 @@ const (
 
 	// IstioMeshGateway is the built in gateway for all sidecars
 	IstioMeshGateway = "mesh"
+
+	// DefaultMeshPolicyName is the name every MeshPolicy must use: being cluster-scoped, there
+	// can only be one, so unlike a namespaced AuthenticationPolicy it doesn't need a
+	// user-chosen name to disambiguate it from siblings.
+	DefaultMeshPolicyName = "default"
 )
 
 /*
@@ -482,6 +511,19 @@ var (
 		Validate:    ValidateAuthenticationPolicy,
 	}
 
+	// MeshPolicy describes a mesh-wide authentication policy: a single, cluster-scoped
+	// (namespace-less) config every proxy falls back to when no namespace, service, or
+	// workload-selector-scoped AuthenticationPolicy matches. It shares AuthenticationPolicy's
+	// message shape and validation, just at a wider scope.
+	MeshPolicy = ProtoSchema{
+		Type:        "mesh-policy",
+		Plural:      "mesh-policies",
+		Group:       "authentication",
+		Version:     "v1alpha1",
+		MessageName: "istio.authentication.v1alpha1.Policy",
+		Validate:    ValidateAuthenticationPolicy,
+	}
+
 	// ServiceRole describes an RBAC service role.
 	ServiceRole = ProtoSchema{
 		Type:        "service-role",
@@ -502,6 +544,18 @@ var (
 		Validate:    ValidateServiceRoleBinding,
 	}
 
+	// HTTPRoute describes a Kubernetes Gateway API HTTPRoute. It is translated into a
+	// synthesized VirtualService by pilot/pkg/config/kube/gateway; see model.HTTPRoute's doc
+	// comment for why it isn't backed by the real sigs.k8s.io/gateway-api Go types.
+	HTTPRoute = ProtoSchema{
+		Type:        "http-route",
+		Plural:      "httproutes",
+		Group:       "gateway.networking.k8s.io",
+		Version:     "v1alpha2",
+		MessageName: "model.HTTPRoute",
+		Validate:    ValidateHTTPRoute,
+	}
+
 	// IstioConfigTypes lists all Istio config types with schemas and validation
 	IstioConfigTypes = ConfigDescriptor{
 		RouteRule,
@@ -517,8 +571,10 @@ var (
 		QuotaSpec,
 		QuotaSpecBinding,
 		AuthenticationPolicy,
+		MeshPolicy,
 		ServiceRole,
 		ServiceRoleBinding,
+		HTTPRoute,
 	}
 )
 
@@ -574,6 +630,109 @@ func ResolveShortnameToFQDN(host string, meta ConfigMeta) Hostname {
 	return Hostname(out)
 }
 
+// SortHTTPRoutesByMatch orders a VirtualService's HTTP routes by how specific their most
+// specific HTTPMatchRequest is, descending, so VirtualServices' first-match-wins semantics
+// don't depend on the order the routes happen to be authored in. Ties (including routes with no
+// Match at all) preserve the routes' original relative order.
+func SortHTTPRoutesByMatch(routes []*networking.HTTPRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return httpRouteMatchScore(routes[i]) > httpRouteMatchScore(routes[j])
+	})
+}
+
+// MergeHTTPRoutesByMatch orders routes the same way SortHTTPRoutesByMatch does, but breaks ties
+// by the owning Config's Key() instead of slice position -- for a caller merging routes drawn
+// from several VirtualServices bound to the same host, where "original order" isn't a single
+// well-defined thing once routes from different configs are interleaved. owners[i] must be the
+// Config that routes[i] was drawn from; if the lengths don't match, routes is returned as-is.
+func MergeHTTPRoutesByMatch(routes []*networking.HTTPRoute, owners []Config) []*networking.HTTPRoute {
+	if len(routes) != len(owners) {
+		return routes
+	}
+	type scored struct {
+		route *networking.HTTPRoute
+		score int
+		key   string
+	}
+	tmp := make([]scored, len(routes))
+	for i, r := range routes {
+		tmp[i] = scored{route: r, score: httpRouteMatchScore(r), key: owners[i].Key()}
+	}
+	sort.Slice(tmp, func(i, j int) bool {
+		if tmp[i].score != tmp[j].score {
+			return tmp[i].score > tmp[j].score
+		}
+		return tmp[i].key < tmp[j].key
+	})
+	out := make([]*networking.HTTPRoute, len(tmp))
+	for i := range tmp {
+		out[i] = tmp[i].route
+	}
+	return out
+}
+
+// httpRouteMatchScore scores a route by the most specific of its (OR'd) Match entries.
+func httpRouteMatchScore(route *networking.HTTPRoute) int {
+	var best int
+	for i, m := range route.Match {
+		if s := HTTPMatchSpecificity(m); i == 0 || s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+// HTTPMatchSpecificity scores one HTTPMatchRequest by how narrowly it constrains a request, so
+// that both SortHTTPRoutesByMatch/MergeHTTPRoutesByMatch here and
+// pilot/pkg/networking/core/v1alpha3/route's TranslateRoutes order first-match-wins HTTP routes
+// from most to least specific off one shared notion of "more specific" -- these two pipelines
+// used to each maintain their own heuristic (matchSpecificity there, httpMatchScore here), which
+// had quietly drifted apart on whether a bare "/" Prefix counts as a real narrowing.
+//
+// URI match type dominates (Exact=3, Prefix=2 (except a bare "/", scored like no URI match at
+// all: it's a catch-all, not a narrowing), Regex=1, none=0), then the matched literal's length
+// for Exact/Prefix, then the number of additional constraints the match declares (headers,
+// method, authority, scheme, port, source labels or gateways) -- each of those counts equally,
+// since none of them is consistently more specific than another in the abstract.
+func HTTPMatchSpecificity(m *networking.HTTPMatchRequest) int {
+	if m == nil {
+		return 0
+	}
+
+	uriScore, literalLen := 0, 0
+	if m.Uri != nil {
+		switch u := m.Uri.MatchType.(type) {
+		case *networking.StringMatch_Exact:
+			uriScore, literalLen = 3, len(u.Exact)
+		case *networking.StringMatch_Prefix:
+			if u.Prefix != "" && u.Prefix != "/" {
+				uriScore, literalLen = 2, len(u.Prefix)
+			}
+		case *networking.StringMatch_Regex:
+			uriScore = 1
+		}
+	}
+
+	extras := len(m.Headers)
+	if m.Method != nil {
+		extras++
+	}
+	if m.Authority != nil {
+		extras++
+	}
+	if m.Scheme != nil {
+		extras++
+	}
+	if m.Port != 0 {
+		extras++
+	}
+	if len(m.GetSourceLabels()) > 0 || len(m.Gateways) > 0 {
+		extras++
+	}
+
+	return uriScore*1000 + literalLen*10 + extras
+}
+
 // MostSpecificHostMatch compares the elements of the stack to the needle, and returns the longest stack element
 // matching the needle, or false if no element in the stack matches the needle.
 func MostSpecificHostMatch(needle Hostname, stack []Hostname) (Hostname, bool) {
@@ -590,11 +749,35 @@ func MostSpecificHostMatch(needle Hostname, stack []Hostname) (Hostname, bool) {
 // from the generic config registry
 type istioConfigStore struct {
 	ConfigStore
+
+	// push, if set via SetPushContext, is consulted by every lookup method below instead of
+	// re-listing and re-resolving the whole store on each call. atomic.Value rather than a plain
+	// field + mutex since it's read on every single push-time lookup and written at most once per
+	// config-version -- a reader should never block behind a writer here.
+	push atomic.Value // *PushContext
 }
 
 // MakeIstioStore creates a wrapper around a store
 func MakeIstioStore(store ConfigStore) IstioConfigStore {
-	return &istioConfigStore{store}
+	return &istioConfigStore{ConfigStore: store}
+}
+
+// SetPushContext installs push as the precomputed snapshot every subsequent lookup on store
+// delegates to, replacing whatever fallback per-call List behavior those methods otherwise use.
+// Pass nil to go back to the fallback behavior, e.g. if push precomputation itself failed.
+func (store *istioConfigStore) SetPushContext(push *PushContext) {
+	store.push.Store(pushContextBox{push})
+}
+
+func (store *istioConfigStore) pushContext() *PushContext {
+	box, _ := store.push.Load().(pushContextBox)
+	return box.push
+}
+
+// pushContextBox lets store.push hold a nil *PushContext: atomic.Value panics if successive
+// Store calls don't all carry the same concrete type, which a bare nil interface would violate.
+type pushContextBox struct {
+	push *PushContext
 }
 
 // MatchSource checks that a rule applies for source service instances.
@@ -701,89 +884,114 @@ func (store *istioConfigStore) ServiceEntries() []Config {
 // extremely expensive - and for larger number of services it doesn't make sense
 // to just convert again and again, for each listener times endpoints.
 func (store *istioConfigStore) VirtualServices(gateways map[string]bool) []Config {
+	if push := store.pushContext(); push != nil {
+		return push.VirtualServices(gateways)
+	}
+
 	configs, err := store.List(VirtualService.Type, NamespaceAll)
 	if err != nil {
 		log.Warnf("Could not load VirtualServices. Error:\n %s \n", err)
 		return nil
 	}
 
-	out := make([]Config, 0)
-	for _, config := range configs {
-		rule := config.Spec.(*networking.VirtualService)
-		if len(rule.Gateways) == 0 {
-			// This rule applies only to IstioMeshGateway
-			if gateways[IstioMeshGateway] {
-				out = append(out, config)
-			}
-		} else {
-			for _, g := range rule.Gateways {
-				// note: Gateway names do _not_ use wildcard matching, so we do not use Hostname.Matches here
-				if gateways[ResolveShortnameToFQDN(g, config.ConfigMeta).String()] {
-					out = append(out, config)
-					break
-				} else if g == IstioMeshGateway && gateways[g] {
-					// "mesh" gateway cannot be expanded into FQDN
-					out = append(out, config)
-					break
-				}
-			}
-		}
+	resolved := make([]Config, len(configs))
+	copy(resolved, configs)
+	for i := range resolved {
+		resolveVirtualService(&resolved[i])
 	}
+	return filterVirtualServicesByGateway(resolved, gateways)
+}
 
-	// Need to parse each rule and convert the shortname to FQDN
-	for _, r := range out {
-		rule := r.Spec.(*networking.VirtualService)
-		// resolve top level hosts
-		for i, h := range rule.Hosts {
-			rule.Hosts[i] = ResolveShortnameToFQDN(h, r.ConfigMeta).String()
+// resolveVirtualService expands every shortname in r's VirtualService (hosts, gateways, route
+// destinations/mirrors) to an FQDN and orders its Http routes most-specific-match first, in
+// place. Idempotent: re-running it on an already-resolved Config is a no-op, since
+// ResolveShortnameToFQDN on an already-FQDN string just returns it unchanged.
+func resolveVirtualService(r *Config) {
+	rule := r.Spec.(*networking.VirtualService)
+	// resolve top level hosts
+	for i, h := range rule.Hosts {
+		rule.Hosts[i] = ResolveShortnameToFQDN(h, r.ConfigMeta).String()
+	}
+	// resolve gateways to bind to
+	for i, g := range rule.Gateways {
+		if g != IstioMeshGateway {
+			rule.Gateways[i] = ResolveShortnameToFQDN(g, r.ConfigMeta).String()
 		}
-		// resolve gateways to bind to
-		for i, g := range rule.Gateways {
-			if g != IstioMeshGateway {
-				rule.Gateways[i] = ResolveShortnameToFQDN(g, r.ConfigMeta).String()
+	}
+	// resolve host in http route.destination, route.mirror
+	for _, d := range rule.Http {
+		for _, m := range d.Match {
+			for i, g := range m.Gateways {
+				if g != IstioMeshGateway {
+					m.Gateways[i] = ResolveShortnameToFQDN(g, r.ConfigMeta).String()
+				}
 			}
 		}
-		// resolve host in http route.destination, route.mirror
-		for _, d := range rule.Http {
-			for _, m := range d.Match {
-				for i, g := range m.Gateways {
-					if g != IstioMeshGateway {
-						m.Gateways[i] = ResolveShortnameToFQDN(g, r.ConfigMeta).String()
-					}
+		for _, w := range d.Route {
+			w.Destination.Host = ResolveShortnameToFQDN(w.Destination.Host, r.ConfigMeta).String()
+		}
+		if d.Mirror != nil {
+			d.Mirror.Host = ResolveShortnameToFQDN(d.Mirror.Host, r.ConfigMeta).String()
+		}
+	}
+	// Order routes most-specific-match first so first-match-wins resolution doesn't depend
+	// on authoring order within the VirtualService.
+	SortHTTPRoutesByMatch(rule.Http)
+	//resolve host in tcp route.destination
+	for _, d := range rule.Tcp {
+		for _, m := range d.Match {
+			for i, g := range m.Gateways {
+				if g != IstioMeshGateway {
+					m.Gateways[i] = ResolveShortnameToFQDN(g, r.ConfigMeta).String()
 				}
 			}
-			for _, w := range d.Route {
-				w.Destination.Host = ResolveShortnameToFQDN(w.Destination.Host, r.ConfigMeta).String()
-			}
-			if d.Mirror != nil {
-				d.Mirror.Host = ResolveShortnameToFQDN(d.Mirror.Host, r.ConfigMeta).String()
-			}
 		}
-		//resolve host in tcp route.destination
-		for _, d := range rule.Tcp {
-			for _, m := range d.Match {
-				for i, g := range m.Gateways {
-					if g != IstioMeshGateway {
-						m.Gateways[i] = ResolveShortnameToFQDN(g, r.ConfigMeta).String()
-					}
-				}
+		for _, w := range d.Route {
+			w.Destination.Host = ResolveShortnameToFQDN(w.Destination.Host, r.ConfigMeta).String()
+		}
+	}
+}
+
+// filterVirtualServicesByGateway returns the subset of configs (already-resolved VirtualService
+// Configs) bound to one of gateways.
+func filterVirtualServicesByGateway(configs []Config, gateways map[string]bool) []Config {
+	out := make([]Config, 0, len(configs))
+	for _, config := range configs {
+		rule := config.Spec.(*networking.VirtualService)
+		if len(rule.Gateways) == 0 {
+			// This rule applies only to IstioMeshGateway
+			if gateways[IstioMeshGateway] {
+				out = append(out, config)
 			}
-			for _, w := range d.Route {
-				w.Destination.Host = ResolveShortnameToFQDN(w.Destination.Host, r.ConfigMeta).String()
+			continue
+		}
+		for _, g := range rule.Gateways {
+			// note: Gateway names do _not_ use wildcard matching, so we do not use Hostname.Matches
+			// here. g is already FQDN-resolved (or "mesh") by the time this runs.
+			if gateways[g] {
+				out = append(out, config)
+				break
 			}
 		}
 	}
-
 	return out
 }
 
 func (store *istioConfigStore) Gateways(workloadLabels LabelsCollection) []Config {
+	if push := store.pushContext(); push != nil {
+		return push.Gateways(workloadLabels)
+	}
 	configs, err := store.List(Gateway.Type, NamespaceAll)
 	if err != nil {
 		return nil
 	}
+	return filterGatewaysByWorkload(configs, workloadLabels)
+}
 
-	out := make([]Config, 0)
+// filterGatewaysByWorkload returns the subset of configs (Gateway Configs) whose selector (if
+// any) is a subset of workloadLabels.
+func filterGatewaysByWorkload(configs []Config, workloadLabels LabelsCollection) []Config {
+	out := make([]Config, 0, len(configs))
 	for _, config := range configs {
 		gateway := config.Spec.(*networking.Gateway)
 		if gateway.GetSelector() == nil {
@@ -800,14 +1008,40 @@ func (store *istioConfigStore) Gateways(workloadLabels LabelsCollection) []Confi
 }
 
 func (store *istioConfigStore) Policy(instances []*ServiceInstance, destination string, labels Labels) *Config {
+	if push := store.pushContext(); push != nil {
+		return push.Policy(instances, destination, labels)
+	}
 	configs, err := store.List(DestinationPolicy.Type, NamespaceAll)
 	if err != nil {
 		return nil
 	}
+	return matchDestinationPolicy(configs, instances, destination, labels)
+}
 
-	// ugly go-ism
-	var out Config
-	var found bool
+// matchDestinationPolicy returns the DestinationPolicy Config in configs that matches instances,
+// destination, and labels, breaking ties between multiple matches by picking the smallest
+// Config.Key() for determinism.
+func matchDestinationPolicy(configs []Config, instances []*ServiceInstance, destination string, labels Labels) *Config {
+	matches := matchingDestinationPolicies(configs, instances, destination, labels)
+	if len(matches) == 0 {
+		return nil
+	}
+	out := matches[0]
+	for _, config := range matches[1:] {
+		// pick a deterministic policy from the matching configs by picking the smallest key
+		if out.Key() > config.Key() {
+			out = config
+		}
+	}
+	return &out
+}
+
+// matchingDestinationPolicies returns every DestinationPolicy Config in configs matching
+// instances, destination, and labels, in no particular order -- callers that only want the
+// winner should reduce with matchDestinationPolicy; PushContext additionally uses the full list
+// to flag a destination with more than one matching policy as a tiebreak anomaly.
+func matchingDestinationPolicies(configs []Config, instances []*ServiceInstance, destination string, labels Labels) []Config {
+	var matches []Config
 	for _, config := range configs {
 		policy := config.Spec.(*routing.DestinationPolicy)
 		if !MatchSource(config.ConfigMeta, policy.Source, instances) {
@@ -823,47 +1057,118 @@ func (store *istioConfigStore) Policy(instances []*ServiceInstance, destination
 			continue
 		}
 
-		// pick a deterministic policy from the matching configs by picking the smallest key
-		if !found || out.Key() > config.Key() {
-			out = config
-			found = true
+		matches = append(matches, config)
+	}
+	return matches
+}
+
+func (store *istioConfigStore) DestinationRule(hostname Hostname, proxyNamespace string) *Config {
+	return store.DestinationRuleForWorkload(hostname, proxyNamespace, nil)
+}
+
+// destinationRuleWorkloadSelectorAnnotation holds a DestinationRule's workloadSelector, encoded
+// as comma-separated "key=value" pairs on the owning Config.
+//
+// networking.DestinationRule (istio.io/api, a stable external type) has no workloadSelector
+// field at this API vintage, so it's threaded through via a reserved annotation on the owning
+// Config instead -- the same out-of-band encoding the gateway listener builder's sds:// prefix
+// already uses for the analogous problem of extending a fixed external message.
+const destinationRuleWorkloadSelectorAnnotation = "internal.istio.io/workload-selector"
+
+// destinationRuleWorkloadSelector returns the workloadSelector recorded on meta, or nil if none
+// is set.
+func destinationRuleWorkloadSelector(meta ConfigMeta) Labels {
+	encoded, ok := meta.Annotations[destinationRuleWorkloadSelectorAnnotation]
+	if !ok || encoded == "" {
+		return nil
+	}
+	selector := make(Labels)
+	for _, pair := range strings.Split(encoded, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			selector[kv[0]] = kv[1]
 		}
 	}
+	return selector
+}
 
-	if !found {
+// DestinationRuleForWorkload implements IstioConfigStore. While a PushContext (see
+// push_context.go) is installed it resolves against that push's precomputed
+// destinationRuleIndex; otherwise it builds a fresh index from the current snapshot on every
+// call, paying one List + index build per call.
+func (store *istioConfigStore) DestinationRuleForWorkload(hostname Hostname, proxyNamespace string, workloadLabels Labels) *Config {
+	idx, err := store.destinationRuleIndex()
+	if err != nil {
 		return nil
 	}
+	config, _ := idx.resolve(hostname, proxyNamespace, workloadLabels)
+	return config
+}
 
-	return &out
+// MergedDestinationRule implements IstioConfigStore.
+func (store *istioConfigStore) MergedDestinationRule(hostname Hostname, proxyNamespace string) *Config {
+	idx, err := store.destinationRuleIndex()
+	if err != nil {
+		return nil
+	}
+	return mergeDestinationRules(idx.merged(hostname, proxyNamespace))
 }
 
-func (store *istioConfigStore) DestinationRule(hostname Hostname) *Config {
+// destinationRuleIndex returns the pushContext's precomputed index if one is installed,
+// otherwise builds one from a fresh List call.
+func (store *istioConfigStore) destinationRuleIndex() (*destinationRuleIndex, error) {
+	if push := store.pushContext(); push != nil {
+		return push.destinationRules, nil
+	}
 	configs, err := store.List(DestinationRule.Type, NamespaceAll)
 	if err != nil {
+		return nil, err
+	}
+	return buildDestinationRuleIndex(configs), nil
+}
+
+// mergeDestinationRules unions the Subsets of tiers (ordered least to most specific) into the
+// most specific tier's DestinationRule, with an earlier tier's subset of the same name shadowed
+// by a later one.
+func mergeDestinationRules(tiers []*Config) *Config {
+	if len(tiers) == 0 {
 		return nil
 	}
 
-	hosts := make([]Hostname, len(configs))
-	byHosts := make(map[Hostname]*Config, len(configs))
-	for i := range configs {
-		rule := configs[i].Spec.(*networking.DestinationRule)
-		hosts[i] = ResolveShortnameToFQDN(rule.Host, configs[i].ConfigMeta)
-		byHosts[hosts[i]] = &configs[i]
+	subsets := make(map[string]*networking.Subset)
+	var subsetOrder []string
+	for _, tier := range tiers {
+		for _, subset := range tier.Spec.(*networking.DestinationRule).Subsets {
+			if _, exists := subsets[subset.Name]; !exists {
+				subsetOrder = append(subsetOrder, subset.Name)
+			}
+			subsets[subset.Name] = subset
+		}
 	}
 
-	if c, ok := MostSpecificHostMatch(hostname, hosts); ok {
-		return byHosts[c]
+	mostSpecific := tiers[len(tiers)-1]
+	merged := *mostSpecific.Spec.(*networking.DestinationRule)
+	merged.Subsets = make([]*networking.Subset, 0, len(subsetOrder))
+	for _, name := range subsetOrder {
+		merged.Subsets = append(merged.Subsets, subsets[name])
 	}
-	return nil
+
+	out := *mostSpecific
+	out.Spec = &merged
+	return &out
 }
 
-func (store *istioConfigStore) SubsetToLabels(subsetName string, hostname Hostname) LabelsCollection {
+func (store *istioConfigStore) SubsetToLabels(subsetName string, hostname Hostname, proxyNamespace string, workloadLabels ...Labels) LabelsCollection {
 	// empty subset
 	if subsetName == "" {
 		return nil
 	}
 
-	config := store.DestinationRule(hostname)
+	var labels Labels
+	if len(workloadLabels) > 0 {
+		labels = workloadLabels[0]
+	}
+	config := store.DestinationRuleForWorkload(hostname, proxyNamespace, labels)
 	if config == nil {
 		return nil
 	}
@@ -895,6 +1200,9 @@ func mixerToProxyIstioService(in *mccpb.IstioService) *routing.IstioService {
 // HTTPAPISpecByDestination selects Mixerclient HTTP API Specs
 // associated with destination service instances.
 func (store *istioConfigStore) HTTPAPISpecByDestination(instance *ServiceInstance) []Config {
+	if push := store.pushContext(); push != nil {
+		return push.httpAPISpecByHost[instance.Service.Hostname]
+	}
 	bindings, err := store.List(HTTPAPISpecBinding.Type, NamespaceAll)
 	if err != nil {
 		return nil
@@ -935,6 +1243,9 @@ func (store *istioConfigStore) HTTPAPISpecByDestination(instance *ServiceInstanc
 // QuotaSpecByDestination selects Mixerclient quota specifications
 // associated with destination service instances.
 func (store *istioConfigStore) QuotaSpecByDestination(instance *ServiceInstance) []Config {
+	if push := store.pushContext(); push != nil {
+		return push.quotaSpecByHost[instance.Service.Hostname]
+	}
 	bindings, err := store.List(QuotaSpecBinding.Type, NamespaceAll)
 	if err != nil {
 		return nil
@@ -972,29 +1283,76 @@ func (store *istioConfigStore) QuotaSpecByDestination(instance *ServiceInstance)
 	return out
 }
 
-func (store *istioConfigStore) AuthenticationPolicyByDestination(hostname Hostname, port *Port) *Config {
-	// Hostname should be FQDN, so namespace can be extracted by parsing hostname.
-	parts := strings.Split(string(hostname), ".")
-	if len(parts) < 2 {
-		// Bad hostname, return no policy.
+// authnTargetWorkloadSelectorAnnotation holds the WorkloadSelector for one or more of an
+// AuthenticationPolicy's Targets, encoded as ";"-separated "targetName@key=value,key2=value2"
+// entries on the owning Config.
+//
+// authn.Policy.Target (istio.io/api, a stable external type) has no WorkloadSelector field at
+// this API vintage, so it's threaded through via a reserved annotation instead -- the same
+// out-of-band encoding destinationRuleWorkloadSelectorAnnotation already uses for the analogous
+// problem of extending a fixed external message.
+const authnTargetWorkloadSelectorAnnotation = "internal.istio.io/target-workload-selector"
+
+// authnTargetWorkloadSelector returns the WorkloadSelector recorded for targetName on meta, or
+// nil if none is set.
+func authnTargetWorkloadSelector(meta ConfigMeta, targetName string) Labels {
+	encoded, ok := meta.Annotations[authnTargetWorkloadSelectorAnnotation]
+	if !ok || encoded == "" {
 		return nil
 	}
-	namespace := parts[1]
-	// TODO(diemtvu): check for 'global' policy first, when available.
-	// Tracking issue https://github.com/istio/istio/issues/4027
-	specs, err := store.List(AuthenticationPolicy.Type, namespace)
+	for _, entry := range strings.Split(encoded, ";") {
+		nameAndSelector := strings.SplitN(entry, "@", 2)
+		if len(nameAndSelector) != 2 || nameAndSelector[0] != targetName {
+			continue
+		}
+		selector := make(Labels)
+		for _, pair := range strings.Split(nameAndSelector[1], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) == 2 {
+				selector[kv[0]] = kv[1]
+			}
+		}
+		return selector
+	}
+	return nil
+}
+
+func (store *istioConfigStore) AuthenticationPolicyByDestination(service *Service, port *Port, workloadLabels Labels) *Config {
+	if push := store.pushContext(); push != nil {
+		return push.AuthenticationPolicyByDestination(service, port, workloadLabels)
+	}
+
+	specs, err := store.List(AuthenticationPolicy.Type, service.Attributes.Namespace)
 	if err != nil {
 		return nil
 	}
+	if out, matchLevel := matchAuthenticationPolicy(specs, service.Hostname, port, workloadLabels); matchLevel > 0 {
+		return out
+	}
+	// No namespace- or service-scoped policy matched; fall back to the cluster-wide MeshPolicy,
+	// if one is configured.
+	if mesh, exists := store.Get(MeshPolicy.Type, DefaultMeshPolicyName, NamespaceAll); exists {
+		return mesh
+	}
+	return nil
+}
+
+// matchAuthenticationPolicy picks the AuthenticationPolicy Config in specs (which must already
+// be scoped to the destination's namespace, the same way List(AuthenticationPolicy.Type,
+// namespace) scopes it) that best matches hostname, port, and workloadLabels, and the match
+// level it was found at:
+//   0 - no match
+//   2 - namespace scope (a Config with no Targets)
+//   3 - workload (service) scope
+//   4 - workload (service) scope, further narrowed by a WorkloadSelector matching workloadLabels
+// (1 - mesh scope - isn't handled here, since a MeshPolicy has no Targets to match a hostname
+// against at all; callers fall back to it themselves when this returns matchLevel 0.)
+// Ties within the same level are broken by the smaller Config.Key(), for determinism.
+func matchAuthenticationPolicy(specs []Config, hostname Hostname, port *Port, workloadLabels Labels) (*Config, int) {
 	var out Config
 	currentMatchLevel := 0
 	for _, spec := range specs {
 		policy := spec.Spec.(*authn.Policy)
-		// Indicate if a policy matched to target destination:
-		// 0 - not match.
-		// 1 - global / cluster scope.
-		// 2 - namespace scope.
-		// 3 - workload (service).
 		matchLevel := 0
 		if len(policy.Targets) > 0 {
 			for _, dest := range policy.Targets {
@@ -1017,23 +1375,32 @@ func (store *istioConfigStore) AuthenticationPolicyByDestination(hostname Hostna
 				}
 
 				matchLevel = 3
+				if selector := authnTargetWorkloadSelector(spec.ConfigMeta, dest.Name); len(selector) > 0 {
+					if !selector.SubsetOf(workloadLabels) {
+						// This target's selector doesn't cover the workload; it isn't a match at
+						// any level, so keep looking at the policy's other targets.
+						matchLevel = 0
+						continue
+					}
+					matchLevel = 4
+				}
 				break
 			}
 		} else {
 			// Match on namespace level.
 			matchLevel = 2
 		}
-		// Swap output policy that is match in more specific scope.
-		if matchLevel > currentMatchLevel {
+		// Swap output policy that is match in more specific scope; within the same scope, prefer
+		// the one with the smaller Config.Key() for determinism.
+		if matchLevel > currentMatchLevel || (matchLevel > 0 && matchLevel == currentMatchLevel && spec.Key() < out.Key()) {
 			currentMatchLevel = matchLevel
 			out = spec
 		}
 	}
-	// Zero-currentMatchLevel implies no config matching the destination found.
 	if currentMatchLevel == 0 {
-		return nil
+		return nil, 0
 	}
-	return &out
+	return &out, currentMatchLevel
 }
 
 // SortHTTPAPISpec sorts a slice in a stable manner.
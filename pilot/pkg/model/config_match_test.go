@@ -0,0 +1,128 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func exactRoute(name, path string) *networking.HTTPRoute {
+	return &networking.HTTPRoute{
+		Match: []*networking.HTTPMatchRequest{{
+			Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Exact{Exact: path}},
+		}},
+		Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: name}}},
+	}
+}
+
+func prefixRoute(name, prefix string) *networking.HTTPRoute {
+	return &networking.HTTPRoute{
+		Match: []*networking.HTTPMatchRequest{{
+			Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: prefix}},
+		}},
+		Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: name}}},
+	}
+}
+
+// TestSortHTTPRoutesByMatchExactBeatsPrefix asserts an Exact-matching route always sorts ahead
+// of a Prefix-matching one, regardless of authored order, since first-match-wins semantics
+// require the more specific match to be tried first.
+func TestSortHTTPRoutesByMatchExactBeatsPrefix(t *testing.T) {
+	routes := []*networking.HTTPRoute{
+		prefixRoute("prefix", "/"),
+		exactRoute("exact", "/healthz"),
+	}
+	SortHTTPRoutesByMatch(routes)
+	if routes[0].Route[0].Destination.Host != "exact" {
+		t.Errorf("expected the Exact route first, got %v", routes[0].Route[0].Destination.Host)
+	}
+}
+
+// TestSortHTTPRoutesByMatchLongerPrefixBeatsShorter asserts a longer Prefix match sorts ahead of
+// a shorter one covering the same requests, so the more specific prefix is tried first.
+func TestSortHTTPRoutesByMatchLongerPrefixBeatsShorter(t *testing.T) {
+	routes := []*networking.HTTPRoute{
+		prefixRoute("short", "/api"),
+		prefixRoute("long", "/api/v1"),
+	}
+	SortHTTPRoutesByMatch(routes)
+	if routes[0].Route[0].Destination.Host != "long" {
+		t.Errorf("expected the longer Prefix route first, got %v", routes[0].Route[0].Destination.Host)
+	}
+}
+
+// TestSortHTTPRoutesByMatchHeaderCountTiebreak asserts that, for two routes with the same URI
+// match type, the one requiring more headers sorts first, since it's the more specific match.
+func TestSortHTTPRoutesByMatchHeaderCountTiebreak(t *testing.T) {
+	fewHeaders := &networking.HTTPRoute{
+		Match: []*networking.HTTPMatchRequest{{
+			Uri:     &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: "/"}},
+			Headers: map[string]*networking.StringMatch{"x-a": {MatchType: &networking.StringMatch_Exact{Exact: "1"}}},
+		}},
+		Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: "few"}}},
+	}
+	manyHeaders := &networking.HTTPRoute{
+		Match: []*networking.HTTPMatchRequest{{
+			Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: "/"}},
+			Headers: map[string]*networking.StringMatch{
+				"x-a": {MatchType: &networking.StringMatch_Exact{Exact: "1"}},
+				"x-b": {MatchType: &networking.StringMatch_Exact{Exact: "2"}},
+			},
+		}},
+		Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: "many"}}},
+	}
+
+	routes := []*networking.HTTPRoute{fewHeaders, manyHeaders}
+	SortHTTPRoutesByMatch(routes)
+	if routes[0].Route[0].Destination.Host != "many" {
+		t.Errorf("expected the route with more header matches first, got %v", routes[0].Route[0].Destination.Host)
+	}
+}
+
+// TestSortHTTPRoutesByMatchStableOnTies asserts routes scoring identically (including routes
+// with no Match at all) keep their original relative order.
+func TestSortHTTPRoutesByMatchStableOnTies(t *testing.T) {
+	a := &networking.HTTPRoute{Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: "a"}}}}
+	b := &networking.HTTPRoute{Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: "b"}}}}
+
+	routes := []*networking.HTTPRoute{a, b}
+	SortHTTPRoutesByMatch(routes)
+	if routes[0].Route[0].Destination.Host != "a" || routes[1].Route[0].Destination.Host != "b" {
+		t.Errorf("expected original order [a, b] preserved on a tie, got %v, %v",
+			routes[0].Route[0].Destination.Host, routes[1].Route[0].Destination.Host)
+	}
+}
+
+// TestMergeHTTPRoutesByMatchBreaksTiesByOwnerKey asserts that, unlike SortHTTPRoutesByMatch,
+// equally-scored routes drawn from different Configs are ordered by the owning Config's Key()
+// rather than by slice position.
+func TestMergeHTTPRoutesByMatchBreaksTiesByOwnerKey(t *testing.T) {
+	a := &networking.HTTPRoute{Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: "a"}}}}
+	b := &networking.HTTPRoute{Route: []*networking.HTTPRouteDestination{{Destination: &networking.Destination{Host: "b"}}}}
+
+	routes := []*networking.HTTPRoute{a, b}
+	owners := []Config{
+		{ConfigMeta: ConfigMeta{Type: "virtual-service", Namespace: "default", Name: "zzz"}},
+		{ConfigMeta: ConfigMeta{Type: "virtual-service", Namespace: "default", Name: "aaa"}},
+	}
+
+	out := MergeHTTPRoutesByMatch(routes, owners)
+	if out[0].Route[0].Destination.Host != "b" || out[1].Route[0].Destination.Host != "a" {
+		t.Errorf("expected owner-key order [b, a] (aaa < zzz), got %v, %v",
+			out[0].Route[0].Destination.Host, out[1].Route[0].Destination.Host)
+	}
+}
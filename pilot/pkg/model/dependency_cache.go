@@ -0,0 +1,239 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// DependencyCache wraps a ConfigStoreCache with a type-indexed dependency graph, so a consumer
+// (e.g. a listener builder that only cares about VirtualService, DestinationRule, and
+// ServiceEntry for one hostname) is notified with exactly the ConfigKeys that changed, instead
+// of re-listing the whole store on every event the way RegisterEventHandler's per-object
+// callback otherwise tempts callers to do -- List is extremely expensive at scale (see the
+// comment on istioConfigStore.VirtualServices).
+//
+// It's a separate wrapper rather than new methods on ConfigStoreCache because ConfigStoreCache
+// has implementations outside this tree; wrap an existing one with NewDependencyCache instead of
+// requiring every backend to grow this logic.
+type DependencyCache struct {
+	store    ConfigStoreCache
+	debounce time.Duration
+
+	mu            sync.Mutex
+	registered    map[string]bool                // schema Type -> already hooked via RegisterEventHandler
+	typeConsumers map[string]map[string]bool      // schema Type -> consumerKey set (from declared dependsOn)
+	consumers     map[string]dependencyConsumer    // consumerKey -> its handler and declared deps
+	reverseIndex  map[ConfigKey]map[string]bool   // ConfigKey -> consumerKey set observed for that exact object
+	pending       map[string]map[ConfigKey]bool   // consumerKey -> ConfigKeys accumulated during its debounce window
+	timers        map[string]*time.Timer          // consumerKey -> pending debounce timer
+}
+
+type dependencyConsumer struct {
+	dependsOn []ProtoSchema
+	handler   func(changed []ConfigKey)
+}
+
+// NewDependencyCache returns a DependencyCache backed by store, coalescing events into a single
+// handler call per consumer whenever debounce elapses with no further relevant change.
+func NewDependencyCache(store ConfigStoreCache, debounce time.Duration) *DependencyCache {
+	return &DependencyCache{
+		store:         store,
+		debounce:      debounce,
+		registered:    make(map[string]bool),
+		typeConsumers: make(map[string]map[string]bool),
+		consumers:     make(map[string]dependencyConsumer),
+		reverseIndex:  make(map[ConfigKey]map[string]bool),
+		pending:       make(map[string]map[ConfigKey]bool),
+		timers:        make(map[string]*time.Timer),
+	}
+}
+
+// RegisterDependentHandler registers handler to be called with the ConfigKeys that changed,
+// coalesced over c's debounce window, whenever a Create/Update/Delete touches a config of one of
+// the types in dependsOn. Re-registering consumerKey replaces its previous dependsOn and handler.
+func (c *DependencyCache) RegisterDependentHandler(consumerKey string, dependsOn []ProtoSchema, handler func(changed []ConfigKey)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unlockedRemoveConsumer(consumerKey)
+	c.consumers[consumerKey] = dependencyConsumer{dependsOn: dependsOn, handler: handler}
+
+	for _, schema := range dependsOn {
+		if c.typeConsumers[schema.Type] == nil {
+			c.typeConsumers[schema.Type] = make(map[string]bool)
+		}
+		c.typeConsumers[schema.Type][consumerKey] = true
+
+		if !c.registered[schema.Type] {
+			c.registered[schema.Type] = true
+			typ := schema.Type
+			c.store.RegisterEventHandler(typ, c.onEvent)
+		}
+
+		// Seed the reverse index with the type's current members so a change to an object that
+		// existed before this Register call is still attributed precisely, not just by type.
+		if configs, err := c.store.List(schema.Type, NamespaceAll); err == nil {
+			for _, config := range configs {
+				key := ConfigKey{Type: config.Type, Name: config.Name, Namespace: config.Namespace}
+				if c.reverseIndex[key] == nil {
+					c.reverseIndex[key] = make(map[string]bool)
+				}
+				c.reverseIndex[key][consumerKey] = true
+			}
+		}
+	}
+}
+
+// unlockedRemoveConsumer drops consumerKey's prior registration, if any. Callers must hold c.mu.
+func (c *DependencyCache) unlockedRemoveConsumer(consumerKey string) {
+	prev, ok := c.consumers[consumerKey]
+	if !ok {
+		return
+	}
+	for _, schema := range prev.dependsOn {
+		delete(c.typeConsumers[schema.Type], consumerKey)
+	}
+	for _, consumerSet := range c.reverseIndex {
+		delete(consumerSet, consumerKey)
+	}
+	if timer := c.timers[consumerKey]; timer != nil {
+		timer.Stop()
+	}
+	delete(c.timers, consumerKey)
+	delete(c.pending, consumerKey)
+	delete(c.consumers, consumerKey)
+}
+
+// onEvent is the single RegisterEventHandler callback shared by every dependent type; it fans
+// the event out to whichever consumers' dependency sets cover it.
+func (c *DependencyCache) onEvent(config Config, event Event) {
+	key := ConfigKey{Type: config.Type, Name: config.Name, Namespace: config.Namespace}
+
+	c.mu.Lock()
+	consumerKeys := make(map[string]bool)
+	for consumerKey := range c.reverseIndex[key] {
+		consumerKeys[consumerKey] = true
+	}
+	// A freshly created object won't be in the reverse index yet -- fall back to every consumer
+	// that declared a dependency on its type, and start tracking it precisely from here on.
+	for consumerKey := range c.typeConsumers[config.Type] {
+		consumerKeys[consumerKey] = true
+	}
+	if event == EventDelete {
+		delete(c.reverseIndex, key)
+	} else {
+		if c.reverseIndex[key] == nil {
+			c.reverseIndex[key] = make(map[string]bool)
+		}
+		for consumerKey := range consumerKeys {
+			c.reverseIndex[key][consumerKey] = true
+		}
+	}
+
+	for consumerKey := range consumerKeys {
+		c.unlockedScheduleNotify(consumerKey, key)
+	}
+	c.mu.Unlock()
+}
+
+// unlockedScheduleNotify records key as pending for consumerKey and (re)arms its debounce timer.
+// Callers must hold c.mu.
+func (c *DependencyCache) unlockedScheduleNotify(consumerKey string, key ConfigKey) {
+	if c.pending[consumerKey] == nil {
+		c.pending[consumerKey] = make(map[ConfigKey]bool)
+	}
+	c.pending[consumerKey][key] = true
+
+	if timer := c.timers[consumerKey]; timer != nil {
+		timer.Stop()
+	}
+	c.timers[consumerKey] = time.AfterFunc(c.debounce, func() { c.flush(consumerKey) })
+}
+
+// flush invokes consumerKey's handler with everything accumulated since its last flush.
+func (c *DependencyCache) flush(consumerKey string) {
+	c.mu.Lock()
+	changed := c.pending[consumerKey]
+	delete(c.pending, consumerKey)
+	delete(c.timers, consumerKey)
+	consumer, ok := c.consumers[consumerKey]
+	c.mu.Unlock()
+
+	if !ok || len(changed) == 0 {
+		return
+	}
+	keys := make([]ConfigKey, 0, len(changed))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	consumer.handler(keys)
+}
+
+// Invalidate forces an immediate notification of keys to every consumer whose declared
+// dependsOn covers them, bypassing the debounce window -- for callers that already know exactly
+// what changed (e.g. after a Transaction) and want consumers to react without waiting.
+func (c *DependencyCache) Invalidate(keys ...ConfigKey) {
+	byConsumer := make(map[string][]ConfigKey)
+	c.mu.Lock()
+	for _, key := range keys {
+		for consumerKey := range c.reverseIndex[key] {
+			byConsumer[consumerKey] = append(byConsumer[consumerKey], key)
+		}
+		for consumerKey := range c.typeConsumers[key.Type] {
+			byConsumer[consumerKey] = append(byConsumer[consumerKey], key)
+		}
+	}
+	consumers := make(map[string]dependencyConsumer, len(byConsumer))
+	for consumerKey := range byConsumer {
+		if consumer, ok := c.consumers[consumerKey]; ok {
+			consumers[consumerKey] = consumer
+		}
+	}
+	c.mu.Unlock()
+
+	for consumerKey, keys := range byConsumer {
+		if consumer, ok := consumers[consumerKey]; ok {
+			consumer.handler(dedupeConfigKeys(keys))
+		}
+	}
+}
+
+// Snapshot lists every config of each requested type as of one call, for consumers that want to
+// rebuild derived state from a consistent read instead of accumulating it incrementally.
+func (c *DependencyCache) Snapshot(types ...string) map[string][]Config {
+	out := make(map[string][]Config, len(types))
+	for _, typ := range types {
+		configs, err := c.store.List(typ, NamespaceAll)
+		if err != nil {
+			continue
+		}
+		out[typ] = configs
+	}
+	return out
+}
+
+func dedupeConfigKeys(keys []ConfigKey) []ConfigKey {
+	seen := make(map[ConfigKey]bool, len(keys))
+	out := make([]ConfigKey, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+	return out
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeEventStore implements just enough of ConfigStoreCache (List, RegisterEventHandler) for
+// DependencyCache; every other method panics if reached, since DependencyCache never calls them.
+type fakeEventStore struct {
+	ConfigStoreCache
+	configs  map[string][]Config
+	handlers map[string]func(Config, Event)
+}
+
+func newFakeEventStore() *fakeEventStore {
+	return &fakeEventStore{configs: make(map[string][]Config), handlers: make(map[string]func(Config, Event))}
+}
+
+func (f *fakeEventStore) List(typ, namespace string) ([]Config, error) {
+	return f.configs[typ], nil
+}
+
+func (f *fakeEventStore) RegisterEventHandler(typ string, handler func(Config, Event)) {
+	f.handlers[typ] = handler
+}
+
+func (f *fakeEventStore) fire(config Config, event Event) {
+	if h := f.handlers[config.Type]; h != nil {
+		h(config, event)
+	}
+}
+
+func waitForNotify(t *testing.T, notified chan []ConfigKey) []ConfigKey {
+	t.Helper()
+	select {
+	case keys := <-notified:
+		return keys
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the debounced handler to fire")
+		return nil
+	}
+}
+
+// TestDependencyCacheRegisterThenEventFlushesAfterDebounce asserts a Create event on a registered
+// type reaches the consumer's handler, keyed by the changed object, once the debounce window
+// elapses.
+func TestDependencyCacheRegisterThenEventFlushesAfterDebounce(t *testing.T) {
+	store := newFakeEventStore()
+	cache := NewDependencyCache(store, time.Millisecond)
+
+	notified := make(chan []ConfigKey, 1)
+	cache.RegisterDependentHandler("listener-builder", []ProtoSchema{VirtualService}, func(changed []ConfigKey) {
+		notified <- changed
+	})
+
+	store.fire(Config{ConfigMeta: ConfigMeta{Type: VirtualService.Type, Name: "reviews", Namespace: "default"}}, EventAdd)
+
+	got := waitForNotify(t, notified)
+	if len(got) != 1 || got[0].Name != "reviews" {
+		t.Errorf("expected exactly one changed key for \"reviews\", got %v", got)
+	}
+}
+
+// TestDependencyCacheDebouncesMultipleEventsIntoOneFlush asserts several events arriving within
+// one debounce window are coalesced into a single handler call carrying every changed key, rather
+// than one call per event.
+func TestDependencyCacheDebouncesMultipleEventsIntoOneFlush(t *testing.T) {
+	store := newFakeEventStore()
+	cache := NewDependencyCache(store, 50*time.Millisecond)
+
+	notified := make(chan []ConfigKey, 1)
+	cache.RegisterDependentHandler("listener-builder", []ProtoSchema{VirtualService}, func(changed []ConfigKey) {
+		notified <- changed
+	})
+
+	store.fire(Config{ConfigMeta: ConfigMeta{Type: VirtualService.Type, Name: "reviews", Namespace: "default"}}, EventAdd)
+	store.fire(Config{ConfigMeta: ConfigMeta{Type: VirtualService.Type, Name: "productpage", Namespace: "default"}}, EventAdd)
+
+	got := waitForNotify(t, notified)
+	if len(got) != 2 {
+		t.Fatalf("expected both events coalesced into one flush of 2 keys, got %v", got)
+	}
+
+	select {
+	case extra := <-notified:
+		t.Fatalf("expected exactly one flush, got a second one: %v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestDependencyCacheReRegisterReplacesPreviousConsumer asserts registering the same consumerKey
+// again drops its previous dependsOn entirely: an event for the type it USED to depend on no
+// longer reaches it, while its new handler is used for its new dependsOn.
+func TestDependencyCacheReRegisterReplacesPreviousConsumer(t *testing.T) {
+	store := newFakeEventStore()
+	cache := NewDependencyCache(store, time.Millisecond)
+
+	oldNotified := make(chan []ConfigKey, 1)
+	cache.RegisterDependentHandler("listener-builder", []ProtoSchema{VirtualService}, func(changed []ConfigKey) {
+		oldNotified <- changed
+	})
+
+	newNotified := make(chan []ConfigKey, 1)
+	cache.RegisterDependentHandler("listener-builder", []ProtoSchema{DestinationRule}, func(changed []ConfigKey) {
+		newNotified <- changed
+	})
+
+	// A VirtualService event: the consumer no longer depends on this type, so its OLD handler
+	// must not fire.
+	store.fire(Config{ConfigMeta: ConfigMeta{Type: VirtualService.Type, Name: "reviews", Namespace: "default"}}, EventAdd)
+	select {
+	case got := <-oldNotified:
+		t.Fatalf("expected the replaced consumer's old handler to never fire again, got %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A DestinationRule event: the consumer's new dependsOn covers this, so its NEW handler fires.
+	store.fire(Config{ConfigMeta: ConfigMeta{Type: DestinationRule.Type, Name: "reviews", Namespace: "default"}}, EventAdd)
+	got := waitForNotify(t, newNotified)
+	if len(got) != 1 || got[0].Name != "reviews" {
+		t.Errorf("expected the replaced consumer's new handler to see the DestinationRule change, got %v", got)
+	}
+}
+
+// TestDependencyCacheInvalidateBypassesDebounce asserts Invalidate calls every covering
+// consumer's handler immediately, without waiting for the debounce window.
+func TestDependencyCacheInvalidateBypassesDebounce(t *testing.T) {
+	store := newFakeEventStore()
+	cache := NewDependencyCache(store, time.Hour) // would never flush naturally within this test
+
+	notified := make(chan []ConfigKey, 1)
+	cache.RegisterDependentHandler("listener-builder", []ProtoSchema{VirtualService}, func(changed []ConfigKey) {
+		notified <- changed
+	})
+
+	cache.Invalidate(ConfigKey{Type: VirtualService.Type, Name: "reviews", Namespace: "default"})
+
+	select {
+	case got := <-notified:
+		if len(got) != 1 || got[0].Name != "reviews" {
+			t.Errorf("expected Invalidate to notify with the given key, got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Invalidate to notify immediately, bypassing the debounce window")
+	}
+}
+
+// TestDependencyCacheSnapshotListsEachRequestedType asserts Snapshot returns the store's current
+// List result for every requested type, keyed by type.
+func TestDependencyCacheSnapshotListsEachRequestedType(t *testing.T) {
+	store := newFakeEventStore()
+	store.configs[VirtualService.Type] = []Config{{ConfigMeta: ConfigMeta{Type: VirtualService.Type, Name: "reviews"}}}
+	store.configs[DestinationRule.Type] = []Config{{ConfigMeta: ConfigMeta{Type: DestinationRule.Type, Name: "reviews"}}}
+	cache := NewDependencyCache(store, time.Millisecond)
+
+	got := cache.Snapshot(VirtualService.Type, DestinationRule.Type)
+	if len(got[VirtualService.Type]) != 1 || len(got[DestinationRule.Type]) != 1 {
+		t.Errorf("expected one config per requested type, got %v", got)
+	}
+}
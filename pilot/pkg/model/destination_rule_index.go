@@ -0,0 +1,345 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"strings"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// DefaultMeshRootNamespace is the namespace, analogous to IstioMeshGateway for Gateways, that
+// DestinationRule resolution falls back to when no namespace-local or exported rule matches --
+// the mesh operator's place to put a DestinationRule meant to apply cluster-wide.
+const DefaultMeshRootNamespace = "istio-system"
+
+// ClusterLocalHosts are hostnames whose DestinationRules are never visible outside their own
+// namespace, regardless of an individual rule's exportTo: the host itself, not any one rule, is
+// cluster-local (the common case being a control-plane service like one under kube-system that
+// every namespace must resolve independently rather than sharing traffic policy for). Empty by
+// default -- seed it with the mesh's known cluster-local hostnames at startup.
+var ClusterLocalHosts = map[Hostname]bool{}
+
+// destinationRuleExportToAnnotation holds a DestinationRule's exportTo values, encoded as a
+// comma-separated list ("." for namespace-local, "*" for all namespaces, or specific namespace
+// names), on the owning Config.
+//
+// networking.DestinationRule (istio.io/api, a stable external type) has no exportTo field at
+// this API vintage, so it's threaded through via a reserved annotation on the owning Config
+// instead -- the same out-of-band encoding destinationRuleWorkloadSelectorAnnotation already
+// uses for the analogous problem of extending a fixed external message.
+const destinationRuleExportToAnnotation = "internal.istio.io/export-to"
+
+const (
+	exportToNamespaceLocal = "."
+	exportToAllNamespaces  = "*"
+)
+
+// destinationRuleExportTo returns the exportTo values recorded on meta, defaulting to
+// namespace-local-only ([".']) to match the exportTo-unset behavior every other Istio config
+// type uses.
+func destinationRuleExportTo(meta ConfigMeta) []string {
+	encoded, ok := meta.Annotations[destinationRuleExportToAnnotation]
+	if !ok || encoded == "" {
+		return []string{exportToNamespaceLocal}
+	}
+	return strings.Split(encoded, ",")
+}
+
+// destinationRuleLocalityLbAnnotation holds a DestinationRule's
+// trafficPolicy.loadBalancer.localityLbSetting, JSON-encoded, on the owning Config.
+//
+// networking.LoadBalancerSettings (istio.io/api, a stable external type) has no
+// localityLbSetting field at this API vintage, so it's threaded through via a reserved
+// annotation on the owning Config instead -- the same out-of-band encoding
+// destinationRuleExportToAnnotation already uses for the analogous problem of extending a
+// fixed external message.
+const destinationRuleLocalityLbAnnotation = "internal.istio.io/locality-lb-setting"
+
+// LocalityLbDistribute assigns the percentage of traffic a proxy in From (a "region",
+// "region/zone", or "region/zone/subzone" spec; an omitted level matches any value there) sends
+// to each destination locality in To, keyed the same way, with weights out of 100.
+type LocalityLbDistribute struct {
+	From string            `json:"from"`
+	To   map[string]uint32 `json:"to"`
+}
+
+// LocalityLbSetting is the decoded form of destinationRuleLocalityLbAnnotation. Distribute
+// weights traffic across destination localities under normal conditions; Failover maps a source
+// region to the region its traffic should move to entirely once its Distribute target(s) have no
+// healthy endpoints left.
+type LocalityLbSetting struct {
+	Distribute []LocalityLbDistribute `json:"distribute,omitempty"`
+	Failover   map[string]string      `json:"failover,omitempty"`
+}
+
+// DestinationRuleLocalityLbSetting returns the LocalityLbSetting recorded on meta, or the zero
+// value (no distribute/failover configured) if none was set or it failed to decode.
+func DestinationRuleLocalityLbSetting(meta ConfigMeta) LocalityLbSetting {
+	encoded, ok := meta.Annotations[destinationRuleLocalityLbAnnotation]
+	if !ok || encoded == "" {
+		return LocalityLbSetting{}
+	}
+	var setting LocalityLbSetting
+	if err := json.Unmarshal([]byte(encoded), &setting); err != nil {
+		return LocalityLbSetting{}
+	}
+	return setting
+}
+
+// destinationRuleMixerPolicyAnnotation holds a DestinationRule's Mixer policy override --
+// whether check/report calls are disabled for traffic to this host and any extra attributes to
+// attach -- JSON-encoded, on the owning Config.
+//
+// mccpb.TcpClientConfig/ServiceConfig (istio.io/api, stable external types) carry no notion of
+// "per-destination-rule override" at this API vintage, so it's threaded through via a reserved
+// annotation on the owning Config instead -- the same out-of-band encoding
+// destinationRuleExportToAnnotation already uses for the analogous problem of extending a fixed
+// external message.
+const destinationRuleMixerPolicyAnnotation = "internal.istio.io/mixer-policy"
+
+// MixerPolicyOverride is the decoded form of destinationRuleMixerPolicyAnnotation.
+// DisableCheckCalls/DisableReportCalls are pointers so "not set on this rule" (fall through to
+// the mesh-wide default) is distinguishable from "explicitly set to false".
+type MixerPolicyOverride struct {
+	DisableCheckCalls  *bool             `json:"disableCheckCalls,omitempty"`
+	DisableReportCalls *bool             `json:"disableReportCalls,omitempty"`
+	MixerAttributes    map[string]string `json:"mixerAttributes,omitempty"`
+}
+
+// DestinationRuleMixerPolicyOverride returns the MixerPolicyOverride recorded on meta, or the
+// zero value (nothing overridden) if none was set or it failed to decode.
+func DestinationRuleMixerPolicyOverride(meta ConfigMeta) MixerPolicyOverride {
+	encoded, ok := meta.Annotations[destinationRuleMixerPolicyAnnotation]
+	if !ok || encoded == "" {
+		return MixerPolicyOverride{}
+	}
+	var override MixerPolicyOverride
+	if err := json.Unmarshal([]byte(encoded), &override); err != nil {
+		return MixerPolicyOverride{}
+	}
+	return override
+}
+
+// destinationRuleRoutePoliciesAnnotation holds a DestinationRule's per-route Mixer policy
+// overrides, JSON-encoded, on the owning Config -- the route-scoped sibling of
+// destinationRuleMixerPolicyAnnotation's per-host override, for an operator who needs to single
+// out e.g. a `/healthz` path rather than the whole host.
+//
+// There's no dedicated MixerRoutePolicy CRD in this API vintage (and mccpb.ServiceConfig has no
+// field for "this applies only to routes matching X" regardless), so it's threaded through the
+// same out-of-band annotation mechanism as the rest of this file, scoped to the DestinationRule
+// that already names the host these route policies apply under.
+const destinationRuleRoutePoliciesAnnotation = "internal.istio.io/mixer-route-policies"
+
+// MixerRouteMatch selects which routes a MixerRoutePolicy applies to. A zero-value field is not
+// matched on (e.g. an empty Method matches every method); PathExact and PathPrefix are mutually
+// exclusive and PathExact wins if both are set.
+type MixerRouteMatch struct {
+	PathExact  string            `json:"pathExact,omitempty"`
+	PathPrefix string            `json:"pathPrefix,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// MixerRoutePolicy is one entry of destinationRuleRoutePoliciesAnnotation: a MixerPolicyOverride
+// that applies only to routes matching Match, layered on top of the DestinationRule's own
+// host-wide MixerPolicyOverride (see DestinationRuleMixerPolicyOverride) in turn layered on top
+// of the mesh-wide default.
+type MixerRoutePolicy struct {
+	Match    MixerRouteMatch     `json:"match"`
+	Override MixerPolicyOverride `json:"override"`
+}
+
+// DestinationRuleMixerRoutePolicies returns the MixerRoutePolicy entries recorded on meta, or nil
+// if none were set or they failed to decode.
+func DestinationRuleMixerRoutePolicies(meta ConfigMeta) []MixerRoutePolicy {
+	encoded, ok := meta.Annotations[destinationRuleRoutePoliciesAnnotation]
+	if !ok || encoded == "" {
+		return nil
+	}
+	var policies []MixerRoutePolicy
+	if err := json.Unmarshal([]byte(encoded), &policies); err != nil {
+		return nil
+	}
+	return policies
+}
+
+// hostIndex is a most-specific-host lookup over a set of DestinationRule Configs, honoring
+// workloadSelector the same way DestinationRuleForWorkload's pre-index implementation did: a
+// selector-bearing rule matching workloadLabels beats a selector-less one at the same host
+// specificity.
+type hostIndex struct {
+	hosts  []Hostname
+	byHost map[Hostname][]*Config
+}
+
+func (idx *hostIndex) add(host Hostname, config *Config) {
+	if idx.byHost == nil {
+		idx.byHost = make(map[Hostname][]*Config)
+	}
+	if _, exists := idx.byHost[host]; !exists {
+		idx.hosts = append(idx.hosts, host)
+	}
+	idx.byHost[host] = append(idx.byHost[host], config)
+}
+
+func (idx *hostIndex) merge(other *hostIndex) {
+	if other == nil {
+		return
+	}
+	for _, host := range other.hosts {
+		for _, config := range other.byHost[host] {
+			idx.add(host, config)
+		}
+	}
+}
+
+// resolve returns the most specific config for hostname in idx, preferring a selector-bearing
+// rule that matches workloadLabels over a selector-less one at the same host specificity.
+func (idx *hostIndex) resolve(hostname Hostname, workloadLabels Labels) (*Config, bool) {
+	if idx == nil {
+		return nil, false
+	}
+
+	var selectorHosts, plainHosts []Hostname
+	selectorByHost := make(map[Hostname]*Config)
+	plainByHost := make(map[Hostname]*Config)
+	for _, host := range idx.hosts {
+		for _, config := range idx.byHost[host] {
+			if selector := destinationRuleWorkloadSelector(config.ConfigMeta); len(selector) > 0 {
+				if !selector.SubsetOf(workloadLabels) {
+					continue
+				}
+				selectorHosts = append(selectorHosts, host)
+				selectorByHost[host] = config
+			} else {
+				plainHosts = append(plainHosts, host)
+				plainByHost[host] = config
+			}
+		}
+	}
+
+	if host, ok := MostSpecificHostMatch(hostname, selectorHosts); ok {
+		return selectorByHost[host], true
+	}
+	if host, ok := MostSpecificHostMatch(hostname, plainHosts); ok {
+		return plainByHost[host], true
+	}
+	return nil, false
+}
+
+// destinationRuleIndex is a precomputed, namespace-scoped view over every DestinationRule in one
+// config snapshot, built once per List rather than re-scanned per lookup.
+type destinationRuleIndex struct {
+	// namespaceLocal holds, per namespace, every DestinationRule defined in that namespace --
+	// visible only to proxies in the same namespace, regardless of exportTo.
+	namespaceLocal map[string]*hostIndex
+	// exportedTo holds, per namespace, every DestinationRule defined elsewhere whose exportTo
+	// explicitly names that namespace.
+	exportedTo map[string]*hostIndex
+	// exportedToAll holds every DestinationRule whose exportTo is "*".
+	exportedToAll *hostIndex
+	// rootNamespace holds every DestinationRule defined in DefaultMeshRootNamespace.
+	rootNamespace *hostIndex
+}
+
+// buildDestinationRuleIndex groups configs (DestinationRule Configs) into namespaceLocal,
+// exportedTo/exportedToAll, and rootNamespace tiers, honoring ClusterLocalHosts by withholding a
+// cluster-local host's rules from every tier but its own namespace's.
+func buildDestinationRuleIndex(configs []Config) *destinationRuleIndex {
+	idx := &destinationRuleIndex{
+		namespaceLocal: make(map[string]*hostIndex),
+		exportedTo:     make(map[string]*hostIndex),
+		exportedToAll:  &hostIndex{},
+		rootNamespace:  &hostIndex{},
+	}
+
+	for i := range configs {
+		config := &configs[i]
+		rule := config.Spec.(*networking.DestinationRule)
+		host := ResolveShortnameToFQDN(rule.Host, config.ConfigMeta)
+
+		if idx.namespaceLocal[config.Namespace] == nil {
+			idx.namespaceLocal[config.Namespace] = &hostIndex{}
+		}
+		idx.namespaceLocal[config.Namespace].add(host, config)
+
+		if ClusterLocalHosts[host] {
+			continue
+		}
+
+		if config.Namespace == DefaultMeshRootNamespace {
+			idx.rootNamespace.add(host, config)
+		}
+
+		for _, exportTo := range destinationRuleExportTo(config.ConfigMeta) {
+			switch exportTo {
+			case exportToNamespaceLocal:
+				// Already indexed above; nothing further to export.
+			case exportToAllNamespaces:
+				idx.exportedToAll.add(host, config)
+			default:
+				if idx.exportedTo[exportTo] == nil {
+					idx.exportedTo[exportTo] = &hostIndex{}
+				}
+				idx.exportedTo[exportTo].add(host, config)
+			}
+		}
+	}
+	return idx
+}
+
+// exported returns the hostIndex of every rule visible to proxyNamespace via exportTo (either
+// exported specifically to it, or to "*"), merging both so a query sees the most specific host
+// across either.
+func (idx *destinationRuleIndex) exported(proxyNamespace string) *hostIndex {
+	merged := &hostIndex{}
+	merged.merge(idx.exportedTo[proxyNamespace])
+	merged.merge(idx.exportedToAll)
+	return merged
+}
+
+// resolve looks up hostname for a proxy in proxyNamespace with workloadLabels, consulting, in
+// order: namespace-local rules, rules exported to proxyNamespace, then DefaultMeshRootNamespace
+// rules.
+func (idx *destinationRuleIndex) resolve(hostname Hostname, proxyNamespace string, workloadLabels Labels) (*Config, bool) {
+	if config, ok := idx.namespaceLocal[proxyNamespace].resolve(hostname, workloadLabels); ok {
+		return config, true
+	}
+	if config, ok := idx.exported(proxyNamespace).resolve(hostname, workloadLabels); ok {
+		return config, true
+	}
+	return idx.rootNamespace.resolve(hostname, workloadLabels)
+}
+
+// merged returns every config visible to proxyNamespace for hostname across all three tiers,
+// ordered least to most specific (root namespace first, namespace-local last), for
+// MergedDestinationRule to union Subsets from with namespace-local shadowing exported shadowing
+// root.
+func (idx *destinationRuleIndex) merged(hostname Hostname, proxyNamespace string) []*Config {
+	var out []*Config
+	if config, ok := idx.rootNamespace.resolve(hostname, nil); ok {
+		out = append(out, config)
+	}
+	if config, ok := idx.exported(proxyNamespace).resolve(hostname, nil); ok {
+		out = append(out, config)
+	}
+	if config, ok := idx.namespaceLocal[proxyNamespace].resolve(hostname, nil); ok {
+		out = append(out, config)
+	}
+	return out
+}
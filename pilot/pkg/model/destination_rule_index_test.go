@@ -0,0 +1,161 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+func destinationRuleConfig(namespace, name, host, exportTo string, subsets ...*networking.Subset) Config {
+	meta := ConfigMeta{
+		Type:      DestinationRule.Type,
+		Namespace: namespace,
+		Name:      name,
+	}
+	if exportTo != "" {
+		meta.Annotations = map[string]string{destinationRuleExportToAnnotation: exportTo}
+	}
+	return Config{
+		ConfigMeta: meta,
+		Spec:       &networking.DestinationRule{Host: host, Subsets: subsets},
+	}
+}
+
+// TestDestinationRuleIndexNamespaceLocalShadowsExported asserts a namespace-local rule for a
+// host wins over a rule exported to that namespace for the same host.
+func TestDestinationRuleIndexNamespaceLocalShadowsExported(t *testing.T) {
+	local := destinationRuleConfig("bookinfo", "local", "reviews.bookinfo.svc.cluster.local", exportToNamespaceLocal)
+	exported := destinationRuleConfig("other", "exported", "reviews.bookinfo.svc.cluster.local", exportToAllNamespaces)
+
+	idx := buildDestinationRuleIndex([]Config{exported, local})
+	got, ok := idx.resolve("reviews.bookinfo.svc.cluster.local", "bookinfo", nil)
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if got.Name != "local" {
+		t.Errorf("expected the namespace-local rule to win, got %q", got.Name)
+	}
+}
+
+// TestDestinationRuleIndexExportedFallsBackToRootNamespace asserts a proxy in a namespace with
+// no local or exported-to-it rule still resolves a rule defined in DefaultMeshRootNamespace.
+func TestDestinationRuleIndexExportedFallsBackToRootNamespace(t *testing.T) {
+	root := destinationRuleConfig(DefaultMeshRootNamespace, "root", "reviews.bookinfo.svc.cluster.local", exportToNamespaceLocal)
+
+	idx := buildDestinationRuleIndex([]Config{root})
+	got, ok := idx.resolve("reviews.bookinfo.svc.cluster.local", "bookinfo", nil)
+	if !ok {
+		t.Fatal("expected the root-namespace rule to be visible as a fallback")
+	}
+	if got.Name != "root" {
+		t.Errorf("expected the root-namespace rule, got %q", got.Name)
+	}
+}
+
+// TestDestinationRuleIndexExportToDot asserts exportTo="." (the default) is never visible
+// outside its own namespace, even from the mesh root namespace.
+func TestDestinationRuleIndexExportToDot(t *testing.T) {
+	rootLocal := destinationRuleConfig(DefaultMeshRootNamespace, "root-local", "reviews.bookinfo.svc.cluster.local", exportToNamespaceLocal)
+
+	idx := buildDestinationRuleIndex([]Config{rootLocal})
+	if _, ok := idx.resolve("reviews.bookinfo.svc.cluster.local", "bookinfo", nil); ok {
+		t.Error("expected a namespace-local root rule not to be visible to another namespace")
+	}
+	if _, ok := idx.resolve("reviews.bookinfo.svc.cluster.local", DefaultMeshRootNamespace, nil); !ok {
+		t.Error("expected the rule to still be visible within its own namespace")
+	}
+}
+
+// TestDestinationRuleIndexExportedToSpecificNamespace asserts exportTo naming a specific
+// namespace is visible there but not to an uninvolved third namespace.
+func TestDestinationRuleIndexExportedToSpecificNamespace(t *testing.T) {
+	rule := destinationRuleConfig("bookinfo", "scoped", "reviews.bookinfo.svc.cluster.local", "other-ns")
+
+	idx := buildDestinationRuleIndex([]Config{rule})
+	if _, ok := idx.resolve("reviews.bookinfo.svc.cluster.local", "other-ns", nil); !ok {
+		t.Error("expected the rule to be visible to the namespace it's exported to")
+	}
+	if _, ok := idx.resolve("reviews.bookinfo.svc.cluster.local", "third-ns", nil); ok {
+		t.Error("expected the rule not to be visible to an uninvolved namespace")
+	}
+}
+
+// TestDestinationRuleIndexWildcardHostPrecedence asserts an exact host match wins over a
+// wildcard host match when both are namespace-local rules of the same namespace.
+func TestDestinationRuleIndexWildcardHostPrecedence(t *testing.T) {
+	wildcard := destinationRuleConfig("bookinfo", "wildcard", "*.bookinfo.svc.cluster.local", exportToNamespaceLocal)
+	exact := destinationRuleConfig("bookinfo", "exact", "reviews.bookinfo.svc.cluster.local", exportToNamespaceLocal)
+
+	idx := buildDestinationRuleIndex([]Config{wildcard, exact})
+	got, ok := idx.resolve("reviews.bookinfo.svc.cluster.local", "bookinfo", nil)
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if got.Name != "exact" {
+		t.Errorf("expected the exact host match to outrank the wildcard, got %q", got.Name)
+	}
+}
+
+// TestDestinationRuleIndexClusterLocalHostNotExported asserts a host listed in ClusterLocalHosts
+// keeps its rule visible only within its own namespace, regardless of exportTo.
+func TestDestinationRuleIndexClusterLocalHostNotExported(t *testing.T) {
+	const host = Hostname("kube-dns.kube-system.svc.cluster.local")
+	ClusterLocalHosts[host] = true
+	defer delete(ClusterLocalHosts, host)
+
+	rule := destinationRuleConfig("kube-system", "kube-dns", string(host), exportToAllNamespaces)
+
+	idx := buildDestinationRuleIndex([]Config{rule})
+	if _, ok := idx.resolve(host, "kube-system", nil); !ok {
+		t.Error("expected the rule to still be visible within its own namespace")
+	}
+	if _, ok := idx.resolve(host, "bookinfo", nil); ok {
+		t.Error("expected a cluster-local host's rule not to be exported despite exportTo=\"*\"")
+	}
+}
+
+// TestMergeDestinationRulesNamespaceLocalSubsetShadowsExported asserts a namespace-local
+// subset of the same name as an exported one wins, while subsets unique to each tier both
+// survive the merge.
+func TestMergeDestinationRulesNamespaceLocalSubsetShadowsExported(t *testing.T) {
+	exportedSubset := &networking.Subset{Name: "v1", Labels: map[string]string{"version": "exported-v1"}}
+	exportedOnly := &networking.Subset{Name: "canary", Labels: map[string]string{"version": "canary"}}
+	exported := destinationRuleConfig("other", "exported", "reviews.bookinfo.svc.cluster.local", exportToAllNamespaces, exportedSubset, exportedOnly)
+
+	localSubset := &networking.Subset{Name: "v1", Labels: map[string]string{"version": "local-v1"}}
+	local := destinationRuleConfig("bookinfo", "local", "reviews.bookinfo.svc.cluster.local", exportToNamespaceLocal, localSubset)
+
+	idx := buildDestinationRuleIndex([]Config{exported, local})
+	merged := mergeDestinationRules(idx.merged("reviews.bookinfo.svc.cluster.local", "bookinfo"))
+	if merged == nil {
+		t.Fatal("expected a merged DestinationRule")
+	}
+	subsets := merged.Spec.(*networking.DestinationRule).Subsets
+	if len(subsets) != 2 {
+		t.Fatalf("expected 2 merged subsets, got %d: %v", len(subsets), subsets)
+	}
+	byName := make(map[string]*networking.Subset, len(subsets))
+	for _, s := range subsets {
+		byName[s.Name] = s
+	}
+	if byName["v1"].Labels["version"] != "local-v1" {
+		t.Errorf("expected the namespace-local v1 subset to shadow the exported one, got %v", byName["v1"].Labels)
+	}
+	if byName["canary"] == nil {
+		t.Error("expected the exported-only canary subset to survive the merge")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// PathMatchType is the kind of match an HTTPPathMatch performs, mirroring the Gateway API
+// (gateway.networking.k8s.io) PathMatchType enum.
+type PathMatchType string
+
+const (
+	PathMatchExact             PathMatchType = "Exact"
+	PathMatchPathPrefix        PathMatchType = "PathPrefix"
+	PathMatchRegularExpression PathMatchType = "RegularExpression"
+)
+
+// HTTPPathMatch matches a request path.
+type HTTPPathMatch struct {
+	Type  PathMatchType
+	Value string
+}
+
+// HTTPHeaderMatch matches one request header by exact name and value.
+type HTTPHeaderMatch struct {
+	Name  string
+	Value string
+}
+
+// HTTPQueryParamMatch matches one request query parameter by exact name and value.
+type HTTPQueryParamMatch struct {
+	Name  string
+	Value string
+}
+
+// HTTPRouteMatch is one set of conditions a request may satisfy to hit the owning
+// HTTPRouteRule. A rule with several Matches in HTTPRouteRule.Matches is an OR of them.
+type HTTPRouteMatch struct {
+	Path        *HTTPPathMatch
+	Headers     []HTTPHeaderMatch
+	QueryParams []HTTPQueryParamMatch
+	// Method is the HTTP method to match, or empty to match any method.
+	Method string
+}
+
+// HTTPBackendRef names a destination a matching request is forwarded to, along with the
+// fraction of matching traffic it receives.
+type HTTPBackendRef struct {
+	// Name is the destination host (a Service name, same form as Destination.Host).
+	Name   string
+	Port   uint32
+	Weight int32
+}
+
+// HTTPRouteRule forwards requests satisfying any of Matches to BackendRefs.
+type HTTPRouteRule struct {
+	Matches     []HTTPRouteMatch
+	BackendRefs []HTTPBackendRef
+}
+
+// HTTPRoute is a locally-defined stand-in for the Gateway API (gateway.networking.k8s.io)
+// HTTPRoute's spec: a set of hostnames and rules attached to one or more parent Gateways.
+//
+// The real sigs.k8s.io/gateway-api client types (and the k8s.io/apimachinery types they embed)
+// aren't available anywhere in this tree -- pilot here has no k8s.io dependency at all, unlike
+// upstream Istio -- so this mirrors just the routing-relevant subset of that spec as a plain
+// struct instead. See pilot/pkg/config/kube/gateway for the translation into VirtualService.
+//
+// It implements proto.Message trivially (there's no wire encoding involved) purely so it can
+// flow through Config.Spec and ProtoSchema.Validate like every other registered config type.
+type HTTPRoute struct {
+	// ParentGateways lists the Gateways (by name, same form as VirtualService.Gateways) this
+	// route attaches to.
+	ParentGateways []string
+	// Hostnames this route applies to; empty means all hostnames of the parent Gateway.
+	Hostnames []string
+	Rules     []HTTPRouteRule
+}
+
+// Reset implements proto.Message.
+func (r *HTTPRoute) Reset() { *r = HTTPRoute{} }
+
+// String implements proto.Message.
+func (r *HTTPRoute) String() string { return fmt.Sprintf("%+v", *r) }
+
+// ProtoMessage implements proto.Message.
+func (*HTTPRoute) ProtoMessage() {}
+
+// ValidateHTTPRoute checks that an HTTPRoute config references at least one parent gateway and
+// that every rule has a backend to forward to and only well-known path match types.
+func ValidateHTTPRoute(config proto.Message) error {
+	route, ok := config.(*HTTPRoute)
+	if !ok {
+		return errors.New("cannot cast to HTTPRoute")
+	}
+	if len(route.ParentGateways) == 0 {
+		return errors.New("HTTPRoute must reference at least one parent gateway")
+	}
+	for ri, rule := range route.Rules {
+		if len(rule.BackendRefs) == 0 {
+			return fmt.Errorf("rule %d: at least one backendRef is required", ri)
+		}
+		for mi, match := range rule.Matches {
+			switch match.Path.pathType() {
+			case PathMatchExact, PathMatchPathPrefix, PathMatchRegularExpression, "":
+			default:
+				return fmt.Errorf("rule %d match %d: invalid path match type %q", ri, mi, match.Path.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// pathType returns p.Type, or "" if p is nil, so callers can switch on it without a nil check.
+func (p *HTTPPathMatch) pathType() PathMatchType {
+	if p == nil {
+		return ""
+	}
+	return p.Type
+}
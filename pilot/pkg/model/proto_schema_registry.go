@@ -0,0 +1,220 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// protoValidators holds validator hooks for dynamically registered (RegisterFromProto) config
+// types, keyed by fully qualified message name, since those types have no generated Go-side
+// Validate function to reference directly the way the istio.io/api-backed ProtoSchema entries
+// above do.
+var protoValidators = struct {
+	mu sync.RWMutex
+	m  map[string]func(proto.Message) error
+}{m: map[string]func(proto.Message) error{}}
+
+// RegisterProtoValidator attaches fn as the Validate hook for messageName, so a ProtoSchema
+// registered later by RegisterFromProto for that message picks it up. Registering again for the
+// same messageName replaces the previous hook.
+func RegisterProtoValidator(messageName string, fn func(proto.Message) error) {
+	protoValidators.mu.Lock()
+	defer protoValidators.mu.Unlock()
+	protoValidators.m[messageName] = fn
+}
+
+func protoValidatorFor(messageName string) func(proto.Message) error {
+	return func(config proto.Message) error {
+		protoValidators.mu.RLock()
+		fn := protoValidators.m[messageName]
+		protoValidators.mu.RUnlock()
+		if fn == nil {
+			return nil
+		}
+		return fn(config)
+	}
+}
+
+var (
+	packageLineRe  = regexp.MustCompile(`(?m)^\s*package\s+([\w.]+)\s*;`)
+	messageStartRe = regexp.MustCompile(`(?m)^\s*message\s+(\w+)\s*\{`)
+	schemaTypeRe   = regexp.MustCompile(`\(istio\.schema\.type\)\s*=\s*"([^"]+)"`)
+	schemaPluralRe = regexp.MustCompile(`\(istio\.schema\.plural\)\s*=\s*"([^"]+)"`)
+	schemaGroupRe  = regexp.MustCompile(`\(istio\.schema\.group\)\s*=\s*"([^"]+)"`)
+	versionSegRe   = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]*)?$`)
+)
+
+// RegisterFromProto parses src as a .proto source file, registers each of its top-level messages
+// as a ProtoSchema on d, and returns the schemas it added.
+//
+// This lets operators plug in new CRD types (custom RBAC extensions, per-tenant policy types)
+// without recompiling Pilot: point it at a directory of .proto descriptors and IstioConfigTypes
+// picks them up.
+//
+// Type comes from a "(istio.schema.type)" message option, Plural from "(istio.schema.plural)"
+// (defaulting to Type + "s"), Group from "(istio.schema.group)", Version from the last
+// vN/vNalphaM/vNbetaM segment of the file's package declaration, and MessageName from
+// "<package>.<message name>". A message missing the type option is skipped rather than rejected,
+// since not every message in a .proto file is meant to be a standalone config root.
+//
+// This isn't a full protobuf grammar parser -- protoc and a protoc-gen-istio-schema plugin, which
+// would normally emit this registration as generated Go code, aren't available in this tree -- so
+// it only understands top-level (non-nested) message blocks with options written one per line,
+// and determines a message's extent with simple brace counting rather than a real tokenizer.
+func (d *ConfigDescriptor) RegisterFromProto(reader io.Reader) ([]ProtoSchema, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("RegisterFromProto: %v", err)
+	}
+	src := string(content)
+
+	pkg := ""
+	if m := packageLineRe.FindStringSubmatch(src); m != nil {
+		pkg = m[1]
+	}
+	version := packageVersion(pkg)
+
+	var registered []ProtoSchema
+	for _, block := range topLevelMessageBlocks(src) {
+		typeOpt := firstSubmatch(schemaTypeRe, block.body)
+		if typeOpt == "" {
+			// Not every message in a shared .proto file is a standalone config root.
+			continue
+		}
+		plural := firstSubmatch(schemaPluralRe, block.body)
+		if plural == "" {
+			plural = typeOpt + "s"
+		}
+		group := firstSubmatch(schemaGroupRe, block.body)
+		messageName := block.name
+		if pkg != "" {
+			messageName = pkg + "." + block.name
+		}
+
+		schema := ProtoSchema{
+			Type:        typeOpt,
+			Plural:      plural,
+			Group:       group,
+			Version:     version,
+			MessageName: messageName,
+			Validate:    protoValidatorFor(messageName),
+		}
+
+		for _, existing := range *d {
+			if existing.Type == schema.Type {
+				return registered, fmt.Errorf("RegisterFromProto: type %q already registered", schema.Type)
+			}
+			if existing.MessageName == schema.MessageName {
+				return registered, fmt.Errorf("RegisterFromProto: message %q already registered", schema.MessageName)
+			}
+		}
+
+		*d = append(*d, schema)
+		registered = append(registered, schema)
+	}
+	return registered, nil
+}
+
+// packageVersion returns the trailing vN/vNalphaM/vNbetaM segment of a dotted proto package name,
+// or "" if it doesn't end in one.
+func packageVersion(pkg string) string {
+	parts := strings.Split(pkg, ".")
+	if len(parts) == 0 {
+		return ""
+	}
+	last := parts[len(parts)-1]
+	if versionSegRe.MatchString(last) {
+		return last
+	}
+	return ""
+}
+
+func firstSubmatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+type protoMessageBlock struct {
+	name string
+	body string
+}
+
+// topLevelMessageBlocks finds every "message Name { ... }" block at brace-depth 0 and returns its
+// name and body (the text between, not including, its outer braces). Nested messages are left
+// inside their parent's body untouched -- this only registers top-level message types.
+func topLevelMessageBlocks(src string) []protoMessageBlock {
+	var blocks []protoMessageBlock
+	depth := 0
+	for _, loc := range messageStartRe.FindAllStringSubmatchIndex(src, -1) {
+		start := loc[1] // just past the opening '{'
+		// Only a top-level match if nothing unbalanced precedes it; approximate that by
+		// recomputing brace depth up to the match's start from scratch -- .proto files are small
+		// enough that this being O(n^2) in match count doesn't matter in practice.
+		depth = braceDepthAt(src, loc[0])
+		if depth != 0 {
+			continue
+		}
+		end := matchingBrace(src, start-1)
+		if end < 0 {
+			continue
+		}
+		blocks = append(blocks, protoMessageBlock{
+			name: src[loc[2]:loc[3]],
+			body: src[start:end],
+		})
+	}
+	return blocks
+}
+
+// braceDepthAt returns the net count of '{' minus '}' in src[:pos].
+func braceDepthAt(src string, pos int) int {
+	depth := 0
+	for _, r := range src[:pos] {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+// matchingBrace returns the index of the '}' that closes the '{' at openIdx, or -1 if unbalanced.
+func matchingBrace(src string, openIdx int) int {
+	depth := 0
+	for i := openIdx; i < len(src); i++ {
+		switch src[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
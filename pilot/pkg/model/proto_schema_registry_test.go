@@ -0,0 +1,172 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const sampleProtoSrc = `
+syntax = "proto3";
+
+package istio.networking.v1alpha3;
+
+message VirtualService {
+  option (istio.schema.type) = "virtual-service";
+  option (istio.schema.plural) = "virtualservices";
+  option (istio.schema.group) = "networking";
+
+  string host = 1;
+}
+
+message Unannotated {
+  string foo = 1;
+}
+`
+
+// TestRegisterFromProtoRegistersOnlyAnnotatedTopLevelMessages asserts a message carrying the
+// istio.schema.type option is registered with every field this parser understands, while a
+// message without one is skipped.
+func TestRegisterFromProtoRegistersOnlyAnnotatedTopLevelMessages(t *testing.T) {
+	d := &ConfigDescriptor{}
+	registered, err := d.RegisterFromProto(strings.NewReader(sampleProtoSrc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(registered) != 1 {
+		t.Fatalf("expected exactly one schema registered (Unannotated has no type option), got %v", registered)
+	}
+
+	got := registered[0]
+	if got.Type != "virtual-service" {
+		t.Errorf("expected Type %q, got %q", "virtual-service", got.Type)
+	}
+	if got.Plural != "virtualservices" {
+		t.Errorf("expected Plural %q, got %q", "virtualservices", got.Plural)
+	}
+	if got.Group != "networking" {
+		t.Errorf("expected Group %q, got %q", "networking", got.Group)
+	}
+	if got.Version != "v1alpha3" {
+		t.Errorf("expected Version %q, got %q", "v1alpha3", got.Version)
+	}
+	if got.MessageName != "istio.networking.v1alpha3.VirtualService" {
+		t.Errorf("expected MessageName %q, got %q", "istio.networking.v1alpha3.VirtualService", got.MessageName)
+	}
+	if len(*d) != 1 {
+		t.Errorf("expected the descriptor itself to grow by one entry, got %d", len(*d))
+	}
+}
+
+// TestRegisterFromProtoDefaultsPluralToTypePlusS asserts a message with no explicit
+// istio.schema.plural option gets Type + "s" instead.
+func TestRegisterFromProtoDefaultsPluralToTypePlusS(t *testing.T) {
+	src := `
+package istio.mesh.v1alpha1;
+
+message MeshPolicy {
+  option (istio.schema.type) = "mesh-policy";
+}
+`
+	d := &ConfigDescriptor{}
+	registered, err := d.RegisterFromProto(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(registered) != 1 || registered[0].Plural != "mesh-policys" {
+		t.Fatalf("expected Plural to default to Type+\"s\" (\"mesh-policys\"), got %v", registered)
+	}
+}
+
+// TestRegisterFromProtoRejectsDuplicateType asserts registering a second message whose
+// istio.schema.type matches one already in d fails, leaving d's prior registration untouched.
+func TestRegisterFromProtoRejectsDuplicateType(t *testing.T) {
+	d := &ConfigDescriptor{}
+	if _, err := d.RegisterFromProto(strings.NewReader(sampleProtoSrc)); err != nil {
+		t.Fatalf("unexpected error on first registration: %v", err)
+	}
+
+	dup := `
+package istio.networking.v1alpha3;
+
+message AnotherMessage {
+  option (istio.schema.type) = "virtual-service";
+}
+`
+	if _, err := d.RegisterFromProto(strings.NewReader(dup)); err == nil {
+		t.Error("expected registering a duplicate Type to fail")
+	}
+	if len(*d) != 1 {
+		t.Errorf("expected the descriptor to still have only the first registration, got %d entries", len(*d))
+	}
+}
+
+// TestRegisterFromProtoSkipsNestedMessages asserts a message block nested inside a top-level
+// message isn't registered on its own, even if it carries the istio.schema.type option itself.
+func TestRegisterFromProtoSkipsNestedMessages(t *testing.T) {
+	src := `
+package istio.networking.v1alpha3;
+
+message Outer {
+  option (istio.schema.type) = "outer";
+
+  message Inner {
+    option (istio.schema.type) = "inner";
+  }
+}
+`
+	d := &ConfigDescriptor{}
+	registered, err := d.RegisterFromProto(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(registered) != 1 || registered[0].Type != "outer" {
+		t.Fatalf("expected only the top-level \"outer\" message registered, got %v", registered)
+	}
+}
+
+// protoMessageStub is a minimal proto.Message, just enough to drive RegisterProtoValidator's hook
+// through Validate without pulling in a real generated message type.
+type protoMessageStub struct{}
+
+func (protoMessageStub) Reset()         {}
+func (protoMessageStub) String() string { return "" }
+func (protoMessageStub) ProtoMessage()  {}
+
+// TestRegisterFromProtoValidateHookUsesRegisteredValidator asserts a schema registered by
+// RegisterFromProto picks up whatever validator RegisterProtoValidator previously attached to its
+// MessageName, rather than always validating as a no-op.
+func TestRegisterFromProtoValidateHookUsesRegisteredValidator(t *testing.T) {
+	messageName := "istio.networking.v1alpha3.VirtualService"
+	wantErr := "synthetic validation failure"
+	RegisterProtoValidator(messageName, func(proto.Message) error { return errors.New(wantErr) })
+
+	d := &ConfigDescriptor{}
+	registered, err := d.RegisterFromProto(strings.NewReader(sampleProtoSrc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(registered) != 1 {
+		t.Fatalf("expected exactly one schema registered, got %v", registered)
+	}
+
+	if err := registered[0].Validate(protoMessageStub{}); err == nil || err.Error() != wantErr {
+		t.Errorf("expected Validate to use the registered validator's error, got %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+
+	mccpb "istio.io/api/mixer/v1/config/client"
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// PushContextMetrics receives anomalies PushContext notices while precomputing a snapshot or
+// resolving config against it, for later surfacing via /debug: an unmatched VirtualService
+// gateway, DestinationRules from different namespaces both exported to the same host, a
+// DestinationPolicy losing a tiebreak, a binding that points at a spec that doesn't exist. name
+// is a short anomaly category, key identifies the specific object affected, proxy is the proxy
+// the anomaly was noticed for (nil for a snapshot-wide anomaly not tied to one proxy's push),
+// and msg is a human-readable detail.
+type PushContextMetrics interface {
+	AddMetric(name, key string, proxy *Proxy, msg string)
+}
+
+// PushContext is a precomputed snapshot of the config store, built once per config-version by
+// NewPushContext and installed on an IstioConfigStore with SetPushContext, so that the many
+// lookups one push does don't each re-run List against the backing store (which this package's
+// per-call methods otherwise do -- see the TODO on VirtualServices).
+type PushContext struct {
+	// Metrics, if set, receives anomalies noticed while building or querying this snapshot.
+	Metrics PushContextMetrics
+
+	virtualServices []Config // every VirtualService, already shortname-resolved and route-sorted
+	gateways        []Config // every Gateway, as listed
+
+	destinationPolicies []Config // every DestinationPolicy, as listed
+	destinationRules    *destinationRuleIndex
+
+	httpAPISpecByHost map[Hostname][]Config
+	quotaSpecByHost   map[Hostname][]Config
+
+	meshPolicy               *Config
+	authnPoliciesByNamespace map[string][]Config
+}
+
+// NewPushContext builds a PushContext from store's current snapshot. metrics may be nil if the
+// caller doesn't want anomalies recorded.
+func NewPushContext(store IstioConfigStore, metrics PushContextMetrics) (*PushContext, error) {
+	push := &PushContext{Metrics: metrics}
+
+	for _, initFn := range []func(IstioConfigStore) error{
+		// initVirtualServices flags gateway references that don't resolve to a configured
+		// Gateway, so it must run after initGateways has populated push.gateways.
+		push.initGateways,
+		push.initVirtualServices,
+		push.initDestinationPolicies,
+		push.initDestinationRules,
+		push.initAPIBindings,
+		push.initAuthenticationPolicies,
+	} {
+		if err := initFn(store); err != nil {
+			return nil, err
+		}
+	}
+	return push, nil
+}
+
+func (push *PushContext) addMetric(name, key string, proxy *Proxy, msg string) {
+	if push.Metrics != nil {
+		push.Metrics.AddMetric(name, key, proxy, msg)
+	}
+}
+
+func (push *PushContext) initVirtualServices(store IstioConfigStore) error {
+	configs, err := store.List(VirtualService.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	resolved := make([]Config, len(configs))
+	copy(resolved, configs)
+	for i := range resolved {
+		resolveVirtualService(&resolved[i])
+	}
+	push.virtualServices = resolved
+
+	gatewayNames := make(map[string]bool, len(push.gateways))
+	for _, gw := range push.gateways {
+		gatewayNames[ResolveShortnameToFQDN(gw.Name, gw.ConfigMeta).String()] = true
+	}
+	for _, r := range resolved {
+		rule := r.Spec.(*networking.VirtualService)
+		for _, g := range rule.Gateways {
+			if g == IstioMeshGateway || gatewayNames[g] {
+				continue
+			}
+			push.addMetric("UnmatchedVirtualServiceGateway", r.Key(), nil,
+				fmt.Sprintf("VirtualService %s references gateway %q, which has no matching Gateway config", r.Key(), g))
+		}
+	}
+	return nil
+}
+
+func (push *PushContext) initGateways(store IstioConfigStore) error {
+	configs, err := store.List(Gateway.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	push.gateways = configs
+	return nil
+}
+
+func (push *PushContext) initDestinationPolicies(store IstioConfigStore) error {
+	configs, err := store.List(DestinationPolicy.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	push.destinationPolicies = configs
+	return nil
+}
+
+func (push *PushContext) initDestinationRules(store IstioConfigStore) error {
+	configs, err := store.List(DestinationRule.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	push.destinationRules = buildDestinationRuleIndex(configs)
+
+	// Flag hosts with more than one rule exported mesh-wide (exportTo "*") from different
+	// namespaces -- whichever MostSpecificHostMatch picks is arbitrary from an operator's point
+	// of view, so it's worth surfacing.
+	byHostNamespaces := make(map[Hostname]map[string]bool)
+	for _, host := range push.destinationRules.exportedToAll.hosts {
+		for _, config := range push.destinationRules.exportedToAll.byHost[host] {
+			if byHostNamespaces[host] == nil {
+				byHostNamespaces[host] = make(map[string]bool)
+			}
+			byHostNamespaces[host][config.Namespace] = true
+		}
+	}
+	for host, namespaces := range byHostNamespaces {
+		if len(namespaces) > 1 {
+			push.addMetric("ConflictingDestinationRule", host.String(), nil,
+				fmt.Sprintf("host %s has DestinationRules exported mesh-wide from %d different namespaces", host, len(namespaces)))
+		}
+	}
+	return nil
+}
+
+// initAPIBindings precomputes HTTPAPISpecByDestination and QuotaSpecByDestination's
+// hostname -> []Config maps, flagging any binding that references a spec that doesn't exist.
+func (push *PushContext) initAPIBindings(store IstioConfigStore) error {
+	httpBindings, err := store.List(HTTPAPISpecBinding.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	httpSpecs, err := store.List(HTTPAPISpec.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	push.httpAPISpecByHost = push.buildHTTPAPISpecByHost(httpBindings, httpSpecs)
+
+	quotaBindings, err := store.List(QuotaSpecBinding.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	quotaSpecs, err := store.List(QuotaSpec.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	push.quotaSpecByHost = push.buildQuotaSpecByHost(quotaBindings, quotaSpecs)
+	return nil
+}
+
+// buildHTTPAPISpecByHost mirrors istioConfigStore.HTTPAPISpecByDestination's matching, grouping
+// every spec by the hostname(s) its binding targets instead of resolving it per call.
+func (push *PushContext) buildHTTPAPISpecByHost(bindings, specs []Config) map[Hostname][]Config {
+	key := func(name, namespace string) string { return name + "/" + namespace }
+
+	specByKey := make(map[string]Config, len(specs))
+	for _, spec := range specs {
+		specByKey[key(spec.Name, spec.Namespace)] = spec
+	}
+
+	out := make(map[Hostname][]Config)
+	added := make(map[Hostname]map[string]bool)
+	for _, binding := range bindings {
+		b := binding.Spec.(*mccpb.HTTPAPISpecBinding)
+		for _, service := range b.Services {
+			hostname := ResolveHostname(binding.ConfigMeta, mixerToProxyIstioService(service))
+			for _, ref := range b.ApiSpecs {
+				refKey := key(ref.Name, ref.Namespace)
+				spec, ok := specByKey[refKey]
+				if !ok {
+					push.addMetric("DroppedHTTPAPISpecBinding", binding.Key(), nil,
+						fmt.Sprintf("%s references HTTPAPISpec %s, which doesn't exist", binding.Key(), refKey))
+					continue
+				}
+				if added[hostname] == nil {
+					added[hostname] = make(map[string]bool)
+				}
+				if added[hostname][refKey] {
+					continue
+				}
+				added[hostname][refKey] = true
+				out[hostname] = append(out[hostname], spec)
+			}
+		}
+	}
+	return out
+}
+
+// buildQuotaSpecByHost mirrors istioConfigStore.QuotaSpecByDestination's matching, grouping
+// every spec by the hostname(s) its binding targets instead of resolving it per call.
+func (push *PushContext) buildQuotaSpecByHost(bindings, specs []Config) map[Hostname][]Config {
+	key := func(name, namespace string) string { return name + "/" + namespace }
+
+	specByKey := make(map[string]Config, len(specs))
+	for _, spec := range specs {
+		specByKey[key(spec.Name, spec.Namespace)] = spec
+	}
+
+	out := make(map[Hostname][]Config)
+	added := make(map[Hostname]map[string]bool)
+	for _, binding := range bindings {
+		b := binding.Spec.(*mccpb.QuotaSpecBinding)
+		for _, service := range b.Services {
+			hostname := ResolveHostname(binding.ConfigMeta, mixerToProxyIstioService(service))
+			for _, ref := range b.QuotaSpecs {
+				refKey := key(ref.Name, ref.Namespace)
+				spec, ok := specByKey[refKey]
+				if !ok {
+					push.addMetric("DroppedQuotaSpecBinding", binding.Key(), nil,
+						fmt.Sprintf("%s references QuotaSpec %s, which doesn't exist", binding.Key(), refKey))
+					continue
+				}
+				if added[hostname] == nil {
+					added[hostname] = make(map[string]bool)
+				}
+				if added[hostname][refKey] {
+					continue
+				}
+				added[hostname][refKey] = true
+				out[hostname] = append(out[hostname], spec)
+			}
+		}
+	}
+	return out
+}
+
+func (push *PushContext) initAuthenticationPolicies(store IstioConfigStore) error {
+	specs, err := store.List(AuthenticationPolicy.Type, NamespaceAll)
+	if err != nil {
+		return err
+	}
+	byNamespace := make(map[string][]Config)
+	for _, spec := range specs {
+		byNamespace[spec.Namespace] = append(byNamespace[spec.Namespace], spec)
+	}
+	push.authnPoliciesByNamespace = byNamespace
+
+	if mesh, exists := store.Get(MeshPolicy.Type, DefaultMeshPolicyName, NamespaceAll); exists {
+		push.meshPolicy = mesh
+	}
+	return nil
+}
+
+// VirtualServices implements the same contract as istioConfigStore.VirtualServices, against the
+// precomputed, already-resolved snapshot.
+func (push *PushContext) VirtualServices(gateways map[string]bool) []Config {
+	return filterVirtualServicesByGateway(push.virtualServices, gateways)
+}
+
+// Gateways implements the same contract as istioConfigStore.Gateways.
+func (push *PushContext) Gateways(workloadLabels LabelsCollection) []Config {
+	return filterGatewaysByWorkload(push.gateways, workloadLabels)
+}
+
+// Policy implements the same contract as istioConfigStore.Policy, additionally flagging
+// destinations with more than one matching DestinationPolicy as a tiebreak anomaly.
+func (push *PushContext) Policy(instances []*ServiceInstance, destination string, labels Labels) *Config {
+	matches := matchingDestinationPolicies(push.destinationPolicies, instances, destination, labels)
+	if len(matches) == 0 {
+		return nil
+	}
+	out := matches[0]
+	for _, config := range matches[1:] {
+		if out.Key() > config.Key() {
+			out = config
+		}
+	}
+	if len(matches) > 1 {
+		push.addMetric("ConflictingDestinationPolicy", destination, nil,
+			fmt.Sprintf("%d DestinationPolicies matched %s; %s won the tiebreak", len(matches), destination, out.Key()))
+	}
+	return &out
+}
+
+// AuthenticationPolicyByDestination implements the same contract as
+// istioConfigStore.AuthenticationPolicyByDestination, against the precomputed snapshot.
+func (push *PushContext) AuthenticationPolicyByDestination(service *Service, port *Port, workloadLabels Labels) *Config {
+	specs := push.authnPoliciesByNamespace[service.Attributes.Namespace]
+	if out, matchLevel := matchAuthenticationPolicy(specs, service.Hostname, port, workloadLabels); matchLevel > 0 {
+		return out
+	}
+	return push.meshPolicy
+}
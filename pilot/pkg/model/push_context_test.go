@@ -0,0 +1,158 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// fakeListStore implements just enough of IstioConfigStore (List and Get) for NewPushContext to
+// build a snapshot -- every other method panics if called, since NewPushContext never calls them.
+type fakeListStore struct {
+	IstioConfigStore
+	byType map[string][]Config
+	byKey  map[string]Config
+}
+
+func newFakeListStore() *fakeListStore {
+	return &fakeListStore{byType: make(map[string][]Config), byKey: make(map[string]Config)}
+}
+
+func (f *fakeListStore) add(cfg Config) {
+	f.byType[cfg.Type] = append(f.byType[cfg.Type], cfg)
+	f.byKey[cfg.Type+"/"+cfg.Namespace+"/"+cfg.Name] = cfg
+}
+
+func (f *fakeListStore) List(typ, namespace string) ([]Config, error) {
+	return f.byType[typ], nil
+}
+
+func (f *fakeListStore) Get(typ, name, namespace string) (*Config, bool) {
+	cfg, ok := f.byKey[typ+"/"+namespace+"/"+name]
+	if !ok {
+		return nil, false
+	}
+	return &cfg, true
+}
+
+// recordingMetrics collects every AddMetric call for assertions.
+type recordingMetrics struct {
+	names []string
+}
+
+func (m *recordingMetrics) AddMetric(name, key string, proxy *Proxy, msg string) {
+	m.names = append(m.names, name)
+}
+
+func gatewayConfig(namespace, name string) Config {
+	return Config{
+		ConfigMeta: ConfigMeta{Type: Gateway.Type, Namespace: namespace, Name: name},
+		Spec:       &networking.Gateway{Servers: []*networking.Server{{Hosts: []string{"*"}}}},
+	}
+}
+
+func virtualServiceConfig(namespace, name string, gateways []string) Config {
+	return Config{
+		ConfigMeta: ConfigMeta{Type: VirtualService.Type, Namespace: namespace, Name: name},
+		Spec:       &networking.VirtualService{Hosts: []string{"reviews"}, Gateways: gateways},
+	}
+}
+
+// TestNewPushContextVirtualServicesFilterByGateway asserts the precomputed snapshot's
+// VirtualServices matches istioConfigStore.VirtualServices' contract: only VirtualServices
+// bound to a requested gateway are returned, with their Gateways field resolved to an FQDN.
+func TestNewPushContextVirtualServicesFilterByGateway(t *testing.T) {
+	store := newFakeListStore()
+	store.add(gatewayConfig("bookinfo", "my-gateway"))
+	store.add(virtualServiceConfig("bookinfo", "reviews-route", []string{"my-gateway"}))
+	store.add(virtualServiceConfig("bookinfo", "unrelated-route", []string{"other-gateway"}))
+
+	push, err := NewPushContext(store, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resolvedName := ResolveShortnameToFQDN("my-gateway", ConfigMeta{Namespace: "bookinfo"}).String()
+	out := push.VirtualServices(map[string]bool{resolvedName: true})
+	if len(out) != 1 || out[0].Name != "reviews-route" {
+		t.Errorf("expected only reviews-route bound to %q, got %v", resolvedName, out)
+	}
+}
+
+// TestNewPushContextFlagsUnmatchedVirtualServiceGateway asserts a VirtualService referencing a
+// gateway with no matching Gateway config is recorded as an anomaly.
+func TestNewPushContextFlagsUnmatchedVirtualServiceGateway(t *testing.T) {
+	store := newFakeListStore()
+	store.add(virtualServiceConfig("bookinfo", "reviews-route", []string{"missing-gateway"}))
+
+	metrics := &recordingMetrics{}
+	if _, err := NewPushContext(store, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !containsString(metrics.names, "UnmatchedVirtualServiceGateway") {
+		t.Errorf("expected an UnmatchedVirtualServiceGateway anomaly, got %v", metrics.names)
+	}
+}
+
+// TestNewPushContextFlagsConflictingDestinationRule asserts two DestinationRules for the same
+// host, each exported mesh-wide from different namespaces, are flagged as conflicting.
+func TestNewPushContextFlagsConflictingDestinationRule(t *testing.T) {
+	store := newFakeListStore()
+	store.add(destinationRuleConfig("team-a", "dr-a", "reviews.bookinfo.svc.cluster.local", exportToAllNamespaces))
+	store.add(destinationRuleConfig("team-b", "dr-b", "reviews.bookinfo.svc.cluster.local", exportToAllNamespaces))
+
+	metrics := &recordingMetrics{}
+	if _, err := NewPushContext(store, metrics); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !containsString(metrics.names, "ConflictingDestinationRule") {
+		t.Errorf("expected a ConflictingDestinationRule anomaly, got %v", metrics.names)
+	}
+}
+
+// TestNewPushContextAuthenticationPolicyFallsBackToMeshPolicy asserts PushContext's
+// AuthenticationPolicyByDestination falls back to the cluster-wide MeshPolicy when no
+// namespace- or service-scoped policy matches, mirroring istioConfigStore's own fallback.
+func TestNewPushContextAuthenticationPolicyFallsBackToMeshPolicy(t *testing.T) {
+	store := newFakeListStore()
+	mesh := Config{
+		ConfigMeta: ConfigMeta{Type: MeshPolicy.Type, Namespace: NamespaceAll, Name: DefaultMeshPolicyName},
+	}
+	store.add(mesh)
+
+	push, err := NewPushContext(store, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	service := &Service{Hostname: "reviews.bookinfo", Attributes: ServiceAttributes{Namespace: "bookinfo"}}
+	out := push.AuthenticationPolicyByDestination(service, nil, nil)
+	if out == nil || out.Name != DefaultMeshPolicyName {
+		t.Errorf("expected the mesh policy as a fallback, got %v", out)
+	}
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
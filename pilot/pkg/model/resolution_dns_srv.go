@@ -0,0 +1,22 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// DNSSRV resolves a host via periodic DNS SRV lookups, discovering both endpoint addresses and
+// ports from the SRV record set instead of a fixed endpoint list -- for headless external
+// clusters (Consul, etcd, a MongoDB replica set) that publish membership as SRV records rather
+// than a static A/AAAA set. Takes the next value after the existing ClientSideLB/DNSLB/Passthrough
+// enum so it doesn't collide with them.
+const DNSSRV Resolution = 3
@@ -0,0 +1,296 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ConfigKey identifies one configuration object for BatchGet, the same triple ConfigStore's
+// Get/Delete take as separate arguments.
+type ConfigKey struct {
+	Type      string
+	Name      string
+	Namespace string
+}
+
+// TxnStore is the restricted view of a ConfigStore passed to a TransactionalConfigStore's
+// Transaction func: Create, Update, and Delete calls made through it are staged and applied (or
+// rolled back) as one optimistic-concurrency-checked unit when fn returns, rather than being
+// visible immediately the way calling the backing ConfigStore directly would be.
+type TxnStore interface {
+	Create(config Config) (revision string, err error)
+	Update(config Config) (newRevision string, err error)
+	Delete(typ, name, namespace string) error
+}
+
+// TransactionalConfigStore is a ConfigStore that can additionally apply a batch of writes
+// atomically, and read several keys back as of one consistent point in time. It's kept separate
+// from ConfigStore instead of adding these methods there directly, since ConfigStore has
+// implementations outside this tree (the Kubernetes CRD-backed store, the in-memory store) that
+// would stop compiling against a widened interface; callers that need the guarantee should type
+// -assert a ConfigStore to TransactionalConfigStore (see NewNoOpTransactionStore for a fallback
+// when the concrete store doesn't support it).
+type TransactionalConfigStore interface {
+	ConfigStore
+
+	// Transaction applies every Create/Update/Delete call made through the TxnStore passed to
+	// fn as a single unit: either all of them succeed, or none of their effects are visible to
+	// later List/Get/BatchGet calls. Implementations are expected to perform one optimistic-
+	// concurrency check across every key fn touches, not one check per call.
+	Transaction(fn func(TxnStore) error) error
+
+	// BatchGet retrieves keys as of one consistent point in time, instead of racing a Get per
+	// key against concurrent writes. Missing keys are simply absent from the result.
+	BatchGet(keys []ConfigKey) []Config
+}
+
+// BatchEventHandlerRegistrar is implemented by a ConfigStoreCache that can deliver every Config
+// touched by one Transaction to a handler in a single call, instead of RegisterEventHandler's
+// one callback per object. Kept separate from ConfigStoreCache for the same reason
+// TransactionalConfigStore is kept separate from ConfigStore.
+type BatchEventHandlerRegistrar interface {
+	RegisterBatchEventHandler(typ string, handler func(batch []Config, event Event))
+}
+
+// noOpTransactionStore adapts a plain ConfigStore to TransactionalConfigStore by running fn's
+// writes sequentially against the backing store. It provides none of Transaction's atomicity
+// guarantee -- a failure partway through fn still leaves earlier writes in place -- it exists
+// only so callers can treat every ConfigStore uniformly as a TransactionalConfigStore. A backend
+// that wants real atomicity (Kubernetes server-side apply with a shared field-manager and a
+// rollback log; an in-memory copy-on-write snapshot swap) needs to implement
+// TransactionalConfigStore itself: memoryConfigStore below is the in-memory one, and no
+// Kubernetes-backed one exists in this tree.
+type noOpTransactionStore struct {
+	ConfigStore
+}
+
+// NewNoOpTransactionStore wraps store so it satisfies TransactionalConfigStore without changing
+// its write semantics. Use it for any ConfigStore backend that hasn't implemented real batch
+// atomicity yet.
+func NewNoOpTransactionStore(store ConfigStore) TransactionalConfigStore {
+	return noOpTransactionStore{ConfigStore: store}
+}
+
+func (s noOpTransactionStore) Transaction(fn func(TxnStore) error) error {
+	return fn(s.ConfigStore)
+}
+
+func (s noOpTransactionStore) BatchGet(keys []ConfigKey) []Config {
+	out := make([]Config, 0, len(keys))
+	for _, key := range keys {
+		if config, exists := s.ConfigStore.Get(key.Type, key.Name, key.Namespace); exists {
+			out = append(out, *config)
+		}
+	}
+	return out
+}
+
+// Every ConfigStore already satisfies TxnStore (Create/Update/Delete), which is what makes
+// noOpTransactionStore.Transaction's fn(s.ConfigStore) above type-check.
+var _ TxnStore = ConfigStore(nil)
+
+// memoryConfigStore is a self-contained in-memory ConfigStore that implements
+// TransactionalConfigStore with real copy-on-write semantics: Transaction stages every
+// Create/Update/Delete made through fn against a private copy of the snapshot, and only swaps
+// it in for Get/List/BatchGet if fn returns nil. A failure partway through fn leaves the
+// snapshot readers see completely untouched -- the rollback-on-failure guarantee
+// noOpTransactionStore lacks. If any write reaches the store directly while fn runs, the
+// transaction fails with a conflict error and none of fn's writes land.
+type memoryConfigStore struct {
+	descriptor ConfigDescriptor
+
+	mu      sync.RWMutex
+	data    map[string]Config
+	nextRev int64
+}
+
+// NewMemoryTransactionStore returns an empty in-memory TransactionalConfigStore for descriptor's
+// types. Unlike NewNoOpTransactionStore, its Transaction is atomic: either every write fn makes
+// lands, or (on a non-nil error, or a panic recovered by the caller) none of them do.
+func NewMemoryTransactionStore(descriptor ConfigDescriptor) TransactionalConfigStore {
+	return &memoryConfigStore{descriptor: descriptor, data: map[string]Config{}}
+}
+
+func (s *memoryConfigStore) ConfigDescriptor() ConfigDescriptor {
+	return s.descriptor
+}
+
+func (s *memoryConfigStore) Get(typ, name, namespace string) (*Config, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	config, ok := s.data[Key(typ, name, namespace)]
+	if !ok {
+		return nil, false
+	}
+	out := config
+	return &out, true
+}
+
+func (s *memoryConfigStore) List(typ, namespace string) ([]Config, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listFromSnapshot(s.data, typ, namespace), nil
+}
+
+func (s *memoryConfigStore) Create(config Config) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rev, err := createInSnapshot(s.data, config, s.allocRev)
+	return rev, err
+}
+
+func (s *memoryConfigStore) Update(config Config) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateInSnapshot(s.data, config, s.allocRev)
+}
+
+func (s *memoryConfigStore) Delete(typ, name, namespace string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return deleteFromSnapshot(s.data, typ, name, namespace)
+}
+
+func (s *memoryConfigStore) BatchGet(keys []ConfigKey) []Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Config, 0, len(keys))
+	for _, key := range keys {
+		if config, ok := s.data[Key(key.Type, key.Name, key.Namespace)]; ok {
+			out = append(out, config)
+		}
+	}
+	return out
+}
+
+// Transaction copies s.data, runs fn against a memoryTxnStore backed by the copy, and only
+// replaces s.data with it if fn succeeds -- so readers never observe a partially-applied fn.
+// Before the swap it checks, in one pass under the lock, that every key still has the
+// ResourceVersion it had when the copy was taken and that no key was added or removed.
+func (s *memoryConfigStore) Transaction(fn func(TxnStore) error) error {
+	s.mu.Lock()
+	snapshot := make(map[string]Config, len(s.data))
+	base := make(map[string]string, len(s.data))
+	for k, v := range s.data {
+		snapshot[k] = v
+		base[k] = v.ResourceVersion
+	}
+	rev := s.nextRev
+	s.mu.Unlock()
+
+	txn := &memoryTxnStore{data: snapshot, nextRev: rev}
+	if err := fn(txn); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.data) != len(base) {
+		return fmt.Errorf("conflict: store was modified during the transaction")
+	}
+	for k, v := range s.data {
+		if baseRev, ok := base[k]; !ok || baseRev != v.ResourceVersion {
+			return fmt.Errorf("conflict: %s was modified during the transaction", k)
+		}
+	}
+	s.data = txn.data
+	s.nextRev = txn.nextRev
+	return nil
+}
+
+func (s *memoryConfigStore) allocRev() string {
+	s.nextRev++
+	return strconv.FormatInt(s.nextRev, 10)
+}
+
+// memoryTxnStore is the TxnStore a memoryConfigStore.Transaction hands to fn: every write lands
+// in data (a private copy fn owns exclusively for the duration of the transaction), never in the
+// backing store's own map, until Transaction itself swaps it in after fn returns nil.
+type memoryTxnStore struct {
+	data    map[string]Config
+	nextRev int64
+}
+
+func (t *memoryTxnStore) allocRev() string {
+	t.nextRev++
+	return strconv.FormatInt(t.nextRev, 10)
+}
+
+func (t *memoryTxnStore) Create(config Config) (string, error) {
+	return createInSnapshot(t.data, config, t.allocRev)
+}
+
+func (t *memoryTxnStore) Update(config Config) (string, error) {
+	return updateInSnapshot(t.data, config, t.allocRev)
+}
+
+func (t *memoryTxnStore) Delete(typ, name, namespace string) error {
+	return deleteFromSnapshot(t.data, typ, name, namespace)
+}
+
+// listFromSnapshot, createInSnapshot, updateInSnapshot, and deleteFromSnapshot implement
+// ConfigStore's List/Create/Update/Delete semantics directly against a plain map, so
+// memoryConfigStore and memoryTxnStore (one mutating the live map under a lock, the other a
+// private copy with no lock needed) can share one implementation of the actual store logic.
+
+func listFromSnapshot(data map[string]Config, typ, namespace string) []Config {
+	var out []Config
+	for _, config := range data {
+		if config.Type != typ {
+			continue
+		}
+		if namespace != NamespaceAll && config.Namespace != namespace {
+			continue
+		}
+		out = append(out, config)
+	}
+	return out
+}
+
+func createInSnapshot(data map[string]Config, config Config, allocRev func() string) (string, error) {
+	key := config.Key()
+	if _, exists := data[key]; exists {
+		return "", fmt.Errorf("item %s already exists", key)
+	}
+	config.ResourceVersion = allocRev()
+	data[key] = config
+	return config.ResourceVersion, nil
+}
+
+func updateInSnapshot(data map[string]Config, config Config, allocRev func() string) (string, error) {
+	key := config.Key()
+	existing, exists := data[key]
+	if !exists {
+		return "", fmt.Errorf("item %s not found", key)
+	}
+	if config.ResourceVersion != "" && config.ResourceVersion != existing.ResourceVersion {
+		return "", fmt.Errorf("conflict: %s was modified since ResourceVersion %s", key, config.ResourceVersion)
+	}
+	config.ResourceVersion = allocRev()
+	data[key] = config
+	return config.ResourceVersion, nil
+}
+
+func deleteFromSnapshot(data map[string]Config, typ, name, namespace string) error {
+	key := Key(typ, name, namespace)
+	if _, exists := data[key]; !exists {
+		return fmt.Errorf("item %s not found", key)
+	}
+	delete(data, key)
+	return nil
+}
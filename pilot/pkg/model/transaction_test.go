@@ -0,0 +1,149 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func txnConfig(name string) Config {
+	return Config{ConfigMeta: ConfigMeta{Type: "mock", Namespace: "default", Name: name}}
+}
+
+// TestMemoryConfigStoreTransactionCommitsOnSuccess asserts every write fn makes is visible
+// through Get/List/BatchGet once Transaction returns nil.
+func TestMemoryConfigStoreTransactionCommitsOnSuccess(t *testing.T) {
+	store := NewMemoryTransactionStore(ConfigDescriptor{})
+
+	err := store.Transaction(func(txn TxnStore) error {
+		if _, err := txn.Create(txnConfig("a")); err != nil {
+			return err
+		}
+		if _, err := txn.Create(txnConfig("b")); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, exists := store.Get("mock", "a", "default"); !exists {
+		t.Error("expected \"a\" to be visible after a successful Transaction")
+	}
+	if _, exists := store.Get("mock", "b", "default"); !exists {
+		t.Error("expected \"b\" to be visible after a successful Transaction")
+	}
+}
+
+// TestMemoryConfigStoreTransactionRollsBackOnFailure asserts that when fn returns an error
+// partway through, NONE of its writes -- including ones made before the failing call --
+// become visible. This is the rollback-on-failure guarantee noOpTransactionStore doesn't
+// provide.
+func TestMemoryConfigStoreTransactionRollsBackOnFailure(t *testing.T) {
+	store := NewMemoryTransactionStore(ConfigDescriptor{})
+	wantErr := errors.New("boom")
+
+	err := store.Transaction(func(txn TxnStore) error {
+		if _, err := txn.Create(txnConfig("a")); err != nil {
+			return err
+		}
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected the transaction to surface fn's error, got %v", err)
+	}
+
+	if _, exists := store.Get("mock", "a", "default"); exists {
+		t.Error("expected \"a\" to NOT be visible after a failed Transaction, even though its Create ran before the failure")
+	}
+}
+
+// TestMemoryConfigStoreTransactionIsolatedUntilCommit asserts a Transaction still in progress
+// (fn hasn't returned yet) doesn't let its writes leak out to concurrent Get/List calls against
+// the store -- the copy-on-write snapshot is private until Transaction swaps it in.
+func TestMemoryConfigStoreTransactionIsolatedUntilCommit(t *testing.T) {
+	store := NewMemoryTransactionStore(ConfigDescriptor{})
+
+	err := store.Transaction(func(txn TxnStore) error {
+		if _, err := txn.Create(txnConfig("a")); err != nil {
+			return err
+		}
+		if _, exists := store.Get("mock", "a", "default"); exists {
+			t.Error("expected the in-progress transaction's write to be invisible to the outer store")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// TestMemoryConfigStoreUpdateRejectsStaleResourceVersion asserts Update fails the optimistic
+// concurrency check when the caller's ResourceVersion doesn't match what's stored.
+func TestMemoryConfigStoreUpdateRejectsStaleResourceVersion(t *testing.T) {
+	store := NewMemoryTransactionStore(ConfigDescriptor{})
+	if _, err := store.Create(txnConfig("a")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stale := txnConfig("a")
+	stale.ResourceVersion = "not-the-current-version"
+	if _, err := store.Update(stale); err == nil {
+		t.Error("expected Update with a stale ResourceVersion to fail")
+	}
+}
+
+// TestMemoryConfigStoreBatchGetSkipsMissingKeys asserts BatchGet returns only the keys that
+// exist, silently omitting the rest rather than erroring.
+func TestMemoryConfigStoreBatchGetSkipsMissingKeys(t *testing.T) {
+	store := NewMemoryTransactionStore(ConfigDescriptor{})
+	if _, err := store.Create(txnConfig("a")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := store.BatchGet([]ConfigKey{
+		{Type: "mock", Name: "a", Namespace: "default"},
+		{Type: "mock", Name: "missing", Namespace: "default"},
+	})
+	if len(got) != 1 || got[0].Name != "a" {
+		t.Errorf("expected only the existing key back, got %v", got)
+	}
+}
+
+// TestNoOpTransactionStoreDoesNotRollBack documents noOpTransactionStore's known gap (see its
+// doc comment): unlike memoryConfigStore, a failure partway through fn leaves earlier writes in
+// place. This pins the behavior so a future change to noOpTransactionStore's semantics is a
+// visible, deliberate test change rather than a silent regression either way.
+func TestNoOpTransactionStoreDoesNotRollBack(t *testing.T) {
+	backing := NewMemoryTransactionStore(ConfigDescriptor{})
+	store := NewNoOpTransactionStore(backing)
+	wantErr := errors.New("boom")
+
+	err := store.Transaction(func(txn TxnStore) error {
+		if _, err := txn.Create(txnConfig("a")); err != nil {
+			return err
+		}
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected the transaction to surface fn's error, got %v", err)
+	}
+
+	if _, exists := store.Get("mock", "a", "default"); !exists {
+		t.Error("expected \"a\" to remain visible -- noOpTransactionStore provides no rollback")
+	}
+}
@@ -16,6 +16,8 @@ package v1alpha3
 
 import (
 	"fmt"
+	"net"
+	"strings"
 
 	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
@@ -45,6 +47,23 @@ var (
 	}
 )
 
+// envoyTLSInspectorFilter is the listener filter that sniffs the TLS ClientHello for its SNI
+// (and ALPN) without terminating the connection, needed whenever a listener picks a filter
+// chain by FilterChainMatch.ServerNames -- e.g. TLS passthrough routing below.
+const envoyTLSInspectorFilter = "envoy.listener.tls_inspector"
+
+// hasTLSPassthroughChain reports whether any of opts came from
+// createGatewayTLSPassthroughFilterChainOpts, i.e. has SNI hosts to match on but no
+// tlsContext, meaning the listener needs the TLS inspector to read the SNI in the first place.
+func hasTLSPassthroughChain(opts []*filterChainOpts) bool {
+	for _, o := range opts {
+		if o.tlsContext == nil && len(o.sniHosts) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // TODO: create gateway struct to hold state that we shuttle around in all of the _GatewayFoo(...) methods (the names, the environment, etc)
 // then refactor all the methods to hang off that type, remove the redundant "Gateway" in all the method names
 
@@ -73,10 +92,34 @@ func (configgen *ConfigGeneratorImpl) buildGatewayListeners(env model.Environmen
 	errs := &multierror.Error{}
 	listeners := make([]*xdsapi.Listener, 0, len(merged.Servers))
 	for portNumber, servers := range merged.Servers {
-		// TODO: this works because all Servers on the same port use the same protocol due to model.MergeGateways's implementation.
-		// When Envoy supports filter chain matching, we'll have to group the ports by number and protocol, so this logic will
-		// no longer work.
+		// model.MergeGateways groups Servers by port number only, not protocol -- e.g. an
+		// HTTPS server and a TLS-passthrough TCP server can legitimately share one port and
+		// be told apart by SNI. Partition by protocol family instead of assuming servers[0]'s
+		// protocol applies to the whole group, and build (and concatenate) filter chain opts
+		// for whichever families are actually present.
+		//
+		// TODO: Envoy picks among these filter chains via FilterChainMatch, which today we
+		// only populate for SNI (see buildGatewayListeners' hasTLSPassthroughChain/tlsContext
+		// handling) and, on TCP chains, for the CIDR ranges applyL4MatchRanges copies on. Mixing families that don't carry their own SNI or ALPN discriminator
+		// (e.g. plain HTTP and raw TCP on the same port) still isn't disambiguated at the
+		// Envoy level.
+		var httpServers, tcpServers []*networking.Server
+		for _, server := range servers {
+			switch model.ParseProtocol(server.Port.Protocol) {
+			case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC, model.ProtocolHTTPS:
+				httpServers = append(httpServers, server)
+			case model.ProtocolTCP, model.ProtocolMongo:
+				tcpServers = append(tcpServers, server)
+			}
+		}
+
 		protocol := model.ParseProtocol(servers[0].Port.Protocol)
+		if len(httpServers) > 0 {
+			// Prefer the HTTP family for the listener-level protocol/listenerType, since
+			// plugins mostly key their behavior off of that; a TCP chain sharing the port
+			// still gets its own filterChainOpts below regardless of this choice.
+			protocol = model.ParseProtocol(httpServers[0].Port.Protocol)
+		}
 		opts := buildListenerOpts{
 			env:        env,
 			proxy:      node,
@@ -85,11 +128,18 @@ func (configgen *ConfigGeneratorImpl) buildGatewayListeners(env model.Environmen
 			bindToPort: true,
 			protocol:   protocol,
 		}
-		switch protocol {
-		case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC, model.ProtocolHTTPS:
-			opts.filterChainOpts = createGatewayHTTPFilterChainOpts(env, servers, merged.Names)
-		case model.ProtocolTCP, model.ProtocolMongo:
-			opts.filterChainOpts = createGatewayTCPFilterChainOpts(env, servers, merged.Names)
+		// tcpChainRanges[i] holds the CIDR ranges (if any) l4Match found for the L4 route that
+		// produced opts.filterChainOpts[tcpChainOffset+i], so we can apply them to the real
+		// FilterChain Envoy actually enforces them with once buildListener constructs it.
+		var tcpChainRanges []*l4MatchRanges
+		if len(httpServers) > 0 {
+			opts.filterChainOpts = append(opts.filterChainOpts, createGatewayHTTPFilterChainOpts(env, httpServers, merged.Names)...)
+		}
+		tcpChainOffset := len(opts.filterChainOpts)
+		if len(tcpServers) > 0 {
+			tcpOpts, ranges := createGatewayTCPFilterChainOpts(env, tcpServers, merged.Names, workloadLabels)
+			opts.filterChainOpts = append(opts.filterChainOpts, tcpOpts...)
+			tcpChainRanges = ranges
 		}
 
 		// one filter chain => 0 or 1 certs => SNI not required
@@ -99,6 +149,10 @@ func (configgen *ConfigGeneratorImpl) buildGatewayListeners(env model.Environmen
 
 		listenerType := plugin.ModelProtocolToListenerType(protocol)
 		l := buildListener(opts)
+		if hasTLSPassthroughChain(opts.filterChainOpts) {
+			l.ListenerFilters = append(l.ListenerFilters, listener.ListenerFilter{Name: envoyTLSInspectorFilter})
+		}
+		applyL4MatchRanges(l, tcpChainOffset, tcpChainRanges)
 		mutable := &plugin.MutableObjects{
 			Listener: l,
 			// Note: buildListener creates filter chains but does not populate the filters in the chain; that's what
@@ -172,7 +226,7 @@ func createGatewayHTTPFilterChainOpts(
 		}
 		o := &filterChainOpts{
 			sniHosts:   server.Hosts,
-			tlsContext: buildGatewayListenerTLSContext(server),
+			tlsContext: buildGatewayListenerTLSContext(env, server),
 			httpOpts: &httpListenerOpts{
 				routeConfig:      routeCfg,
 				rds:              "",
@@ -185,11 +239,31 @@ func createGatewayHTTPFilterChainOpts(
 	return httpListeners
 }
 
-func buildGatewayListenerTLSContext(server *networking.Server) *auth.DownstreamTlsContext {
-	if server.Tls == nil {
+// sdsCertPrefix marks a Server_TLSOptions.ServerCertificate as naming an SDS secret rather
+// than a file on the gateway's filesystem, e.g. "sds://default" fetches the secret named
+// "default" from the SDS server instead of mounting a cert into the pod.
+const sdsCertPrefix = "sds://"
+
+// sdsSecretName reports whether certPath uses the sds:// scheme, and if so, the secret name.
+func sdsSecretName(certPath string) (string, bool) {
+	if !strings.HasPrefix(certPath, sdsCertPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(certPath, sdsCertPrefix), true
+}
+
+func buildGatewayListenerTLSContext(env model.Environment, server *networking.Server) *auth.DownstreamTlsContext {
+	// PASSTHROUGH servers never terminate TLS -- the gateway just routes the encrypted
+	// bytes on to an upstream based on the ClientHello's SNI, so there's no
+	// DownstreamTlsContext to build.
+	if server.Tls == nil || server.Tls.Mode == networking.Server_TLSOptions_PASSTHROUGH {
 		return nil
 	}
 
+	if name, ok := sdsSecretName(server.Tls.ServerCertificate); ok {
+		return buildGatewaySdsTLSContext(env, server, name)
+	}
+
 	return &auth.DownstreamTlsContext{
 		CommonTlsContext: &auth.CommonTlsContext{
 			TlsCertificates: []*auth.TlsCertificate{
@@ -220,6 +294,71 @@ func buildGatewayListenerTLSContext(server *networking.Server) *auth.DownstreamT
 	}
 }
 
+// buildGatewaySdsTLSContext builds the SDS equivalent of buildGatewayListenerTLSContext's
+// file-based DownstreamTlsContext: the cert/key come from a TlsCertificateSdsSecretConfig
+// named name, and the CA (if any) from a CombinedValidationContext's
+// ValidationContextSdsSecretConfig, both fetched from the SDS server at env.Mesh.SdsUdsPath.
+// This lets an operator rotate gateway certs by updating the SDS server instead of restarting
+// the gateway pod to remount files.
+func buildGatewaySdsTLSContext(env model.Environment, server *networking.Server, name string) *auth.DownstreamTlsContext {
+	sdsConfig := gatewaySdsConfigSource(env)
+
+	return &auth.DownstreamTlsContext{
+		CommonTlsContext: &auth.CommonTlsContext{
+			TlsCertificateSdsSecretConfigs: []*auth.SdsSecretConfig{
+				{
+					Name:      name,
+					SdsConfig: sdsConfig,
+				},
+			},
+			ValidationContextType: &auth.CommonTlsContext_CombinedValidationContext{
+				CombinedValidationContext: &auth.CommonTlsContext_CombinedCertificateValidationContext{
+					DefaultValidationContext: &auth.CertificateValidationContext{
+						VerifySubjectAltName: server.Tls.SubjectAltNames,
+					},
+					ValidationContextSdsSecretConfig: &auth.SdsSecretConfig{
+						Name:      name + "-cacert",
+						SdsConfig: sdsConfig,
+					},
+				},
+			},
+			AlpnProtocols: ListenersALPNProtocols,
+		},
+		RequireSni: boolTrue,
+	}
+}
+
+// defaultSdsUdsPath is used when the mesh config doesn't set SdsUdsPath, matching the path
+// the per-node SDS agent listens on by default.
+const defaultSdsUdsPath = "unix:/var/run/sds/uds_path"
+
+// gatewaySdsConfigSource builds the ConfigSource SdsSecretConfig entries reference: a gRPC
+// service pointed at the per-node SDS agent, configurable via mesh config so operators can
+// point it at a different socket or address.
+func gatewaySdsConfigSource(env model.Environment) *core.ConfigSource {
+	udsPath := env.Mesh.SdsUdsPath
+	if udsPath == "" {
+		udsPath = defaultSdsUdsPath
+	}
+	return &core.ConfigSource{
+		ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
+			ApiConfigSource: &core.ApiConfigSource{
+				ApiType: core.ApiConfigSource_GRPC,
+				GrpcServices: []*core.GrpcService{
+					{
+						TargetSpecifier: &core.GrpcService_GoogleGrpc_{
+							GoogleGrpc: &core.GrpcService_GoogleGrpc{
+								TargetUri:  udsPath,
+								StatPrefix: "sdsstat",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func buildGatewayInboundHTTPRouteConfig(
 	env model.Environment, serviceIndex map[string]*model.Service,
 	gatewayNames map[string]bool,
@@ -232,7 +371,7 @@ func buildGatewayInboundHTTPRouteConfig(
 	for _, v := range virtualServices {
 		// TODO: I think this is the wrong port to use: we feed in the server's port (i.e. the gateway port), then use it
 		// to construct downstreams; I think we need to look up the service itself, and use the service's port.
-		routes, err := istio_route.TranslateRoutes(v, serviceIndex, port, nil, gatewayNames)
+		routes, _, err := istio_route.TranslateRoutes(v, serviceIndex, port, nil, gatewayNames)
 		if err != nil {
 			log.Debugf("omitting routes for service %v due to error: %v", v, err)
 			continue
@@ -258,30 +397,147 @@ func buildGatewayInboundHTTPRouteConfig(
 	}
 }
 
-func createGatewayTCPFilterChainOpts(
-	env model.Environment, servers []*networking.Server, gatewayNames map[string]bool) []*filterChainOpts {
+// createGatewayTCPFilterChainOpts returns one filterChainOpts per server (PASSTHROUGH servers
+// may contribute several, one per SNI host -- see createGatewayTLSPassthroughFilterChainOpts),
+// plus a parallel slice of the CIDR ranges (if any) each non-passthrough chain's matched L4
+// route wants Envoy to enforce via FilterChainMatch.
+func createGatewayTCPFilterChainOpts(env model.Environment, servers []*networking.Server, gatewayNames map[string]bool,
+	workloadLabels model.LabelsCollection) ([]*filterChainOpts, []*l4MatchRanges) {
 
 	opts := make([]*filterChainOpts, 0, len(servers))
+	ranges := make([]*l4MatchRanges, 0, len(servers))
 	for _, server := range servers {
+		if server.Tls != nil && server.Tls.Mode == networking.Server_TLSOptions_PASSTHROUGH {
+			passthroughOpts := createGatewayTLSPassthroughFilterChainOpts(env, server, gatewayNames)
+			opts = append(opts, passthroughOpts...)
+			// SNI already disambiguates these at the Envoy level; no CIDR ranges to add.
+			for range passthroughOpts {
+				ranges = append(ranges, nil)
+			}
+			continue
+		}
+		filters, r := buildGatewayNetworkFilters(env, server, gatewayNames, workloadLabels)
 		opts = append(opts, &filterChainOpts{
 			sniHosts:       server.Hosts,
-			tlsContext:     buildGatewayListenerTLSContext(server),
-			networkFilters: buildGatewayNetworkFilters(env, server, gatewayNames),
+			tlsContext:     buildGatewayListenerTLSContext(env, server),
+			networkFilters: filters,
+		})
+		ranges = append(ranges, r)
+	}
+	return opts, ranges
+}
+
+// l4MatchRanges carries the CIDR ranges an L4MatchAttributes predicate wants Envoy to enforce
+// via FilterChainMatch -- whether a connection's actual source address falls in SourceSubnet,
+// or the listener's bind address falls in DestinationSubnets, can only be evaluated at the
+// dataplane, not at xDS generation time.
+type l4MatchRanges struct {
+	sourceRanges      []*core.CidrRange
+	destinationRanges []*core.CidrRange
+}
+
+// applyL4MatchRanges copies each non-nil l4MatchRanges entry onto the FilterChainMatch of the
+// corresponding FilterChain buildListener produced -- offset is where the TCP chains start
+// within l.FilterChains (HTTP chains, which have no CIDR ranges to apply, come first).
+func applyL4MatchRanges(l *xdsapi.Listener, offset int, ranges []*l4MatchRanges) {
+	for i, r := range ranges {
+		if r == nil || (len(r.sourceRanges) == 0 && len(r.destinationRanges) == 0) {
+			continue
+		}
+		idx := offset + i
+		if idx >= len(l.FilterChains) {
+			continue
+		}
+		if l.FilterChains[idx].FilterChainMatch == nil {
+			l.FilterChains[idx].FilterChainMatch = &listener.FilterChainMatch{}
+		}
+		l.FilterChains[idx].FilterChainMatch.SourcePrefixRanges = r.sourceRanges
+		l.FilterChains[idx].FilterChainMatch.PrefixRanges = r.destinationRanges
+	}
+}
+
+// createGatewayTLSPassthroughFilterChainOpts builds one filter chain per SNI host matched by
+// a TLSRoute for server, each a bare TCP proxy to that route's destination cluster -- the
+// gateway never terminates TLS for a PASSTHROUGH server, so tlsContext stays nil and Envoy
+// needs the envoy.listener.tls_inspector listener filter (added by buildGatewayListeners) to
+// read the ClientHello's SNI and pick one of these chains via FilterChainMatch.ServerNames.
+func createGatewayTLSPassthroughFilterChainOpts(
+	env model.Environment, server *networking.Server, gatewayNames map[string]bool) []*filterChainOpts {
+	port := &model.Port{
+		Name:     server.Port.Name,
+		Port:     int(server.Port.Number),
+		Protocol: model.ParseProtocol(server.Port.Protocol),
+	}
+
+	dests := filterTLSDownstreams(env, server, gatewayNames)
+	opts := make([]*filterChainOpts, 0, len(dests))
+	for sniHost, dest := range dests {
+		upstream, err := env.GetService(dest.Host)
+		if err != nil || upstream == nil {
+			log.Debugf("failed to retrieve service for TLS passthrough destination %q: %v", dest.Host, err)
+			continue
+		}
+		opts = append(opts, &filterChainOpts{
+			sniHosts:       []string{sniHost},
+			networkFilters: buildOutboundNetworkFilters(destToClusterName(dest), []string{upstream.Address}, port),
 		})
 	}
 	return opts
 }
 
+// filterTLSDownstreams is filterTCPDownstreams for TLS passthrough servers: it matches
+// VirtualService Tls routes (rather than Tcp routes) against server's hosts, keyed by the
+// SNI host each match matched on, so each SNI host can become its own filter chain.
+func filterTLSDownstreams(env model.Environment, server *networking.Server, gateways map[string]bool) map[string]*networking.Destination {
+	hosts := make(map[string]bool, len(server.Hosts))
+	for _, host := range server.Hosts {
+		hosts[host] = true
+	}
+
+	virtualServices := env.VirtualServices(gateways)
+	downstreams := make(map[string]*networking.Destination)
+	for _, spec := range virtualServices {
+		vsvc := spec.Spec.(*networking.VirtualService)
+		match := false
+		for _, host := range vsvc.Hosts {
+			match = match || hosts[host]
+		}
+		if !match {
+			// the VirtualService's hosts don't include hosts advertised by server
+			continue
+		}
+
+		for _, tls := range vsvc.Tls {
+			for _, m := range tls.Match {
+				// TODO: honor DestinationSubnets/SourceLabels/Gateways too, as l4Match does for Tcp routes.
+				if m.Port != 0 && uint32(server.Port.Number) != m.Port {
+					continue
+				}
+				dests := gatherDestinations(tls.Route)
+				if len(dests) == 0 {
+					continue
+				}
+				for _, sniHost := range m.SniHosts {
+					downstreams[sniHost] = dests[0]
+				}
+			}
+		}
+	}
+	return downstreams
+}
+
 // buildGatewayNetworkFilters retrieves all VirtualServices bound to the set of Gateways for this workload, filters
-// them by this server's port and hostnames, and produces network filters for each destination from the filtered services
-func buildGatewayNetworkFilters(env model.Environment, server *networking.Server, gatewayNames map[string]bool) []listener.Filter {
+// them by this server's port and hostnames, and produces network filters for each destination from the filtered services.
+// It also returns the CIDR ranges (if any) the matched L4 route wants Envoy to enforce on this chain.
+func buildGatewayNetworkFilters(env model.Environment, server *networking.Server, gatewayNames map[string]bool,
+	workloadLabels model.LabelsCollection) ([]listener.Filter, *l4MatchRanges) {
 	port := &model.Port{
 		Name:     server.Port.Name,
 		Port:     int(server.Port.Number),
 		Protocol: model.ParseProtocol(server.Port.Protocol),
 	}
 
-	dests := filterTCPDownstreams(env, server, gatewayNames)
+	dests, ranges := filterTCPDownstreams(env, server, gatewayNames, workloadLabels)
 	// de-dupe destinations by hostname; we'll take a random destination if multiple claim the same host
 	byHost := make(map[string]*networking.Destination, len(dests))
 	for _, dest := range dests {
@@ -297,25 +553,26 @@ func buildGatewayNetworkFilters(env model.Environment, server *networking.Server
 		}
 		filters = append(filters, buildOutboundNetworkFilters(destToClusterName(dest), []string{upstream.Address}, port)...)
 	}
-	return filters
+	return filters, ranges
 }
 
-// filterTCPDownstreams filters virtual services by gateway names, then determines if any match the (TCP) server
+// filterTCPDownstreams filters virtual services by gateway names, then determines if any match the (TCP) server.
+// Besides the matched destinations, it returns the CIDR ranges (if any) the first matching L4 route's predicate
+// asked Envoy to enforce -- in the common case of one Tcp block per server this is exactly the chain's own
+// restriction; when multiple Tcp blocks with differing CIDRs share a server they still collapse into one filter
+// chain today (buildGatewayNetworkFilters merges all matched destinations' filters together), so only the first
+// match's ranges can be applied -- splitting into one chain per match is a bigger change than this covers.
 // TODO: move up to more general location so this can be re-used in sidecars
-func filterTCPDownstreams(env model.Environment, server *networking.Server, gateways map[string]bool) []*networking.Destination {
-	hosts := make(map[string]bool, len(server.Hosts))
-	for _, host := range server.Hosts {
-		hosts[host] = true
-	}
-
+func filterTCPDownstreams(env model.Environment, server *networking.Server, gateways map[string]bool,
+	workloadLabels model.LabelsCollection) ([]*networking.Destination, *l4MatchRanges) {
 	virtualServices := env.VirtualServices(gateways)
 	downstreams := make([]*networking.Destination, 0, len(virtualServices))
+	var ranges *l4MatchRanges
 	for _, spec := range virtualServices {
 		vsvc := spec.Spec.(*networking.VirtualService)
-		// TODO: real wildcard based matching; does code to do that not exist already?
 		match := false
 		for _, host := range vsvc.Hosts {
-			match = match || hosts[host]
+			match = match || gatewayHostMatches(server.Hosts, host)
 		}
 		if !match {
 			// the VirtualService's hosts don't include hosts advertised by server
@@ -324,21 +581,35 @@ func filterTCPDownstreams(env model.Environment, server *networking.Server, gate
 
 		// hosts match, now we ensure we satisfy the rule's l4 match conditions, if any exist
 		for _, tcp := range vsvc.Tcp {
-			if l4Match(tcp.Match, server, gateways) {
+			if ok, r := l4Match(tcp.Match, server, gateways, workloadLabels); ok {
 				downstreams = append(downstreams, gatherDestinations(tcp.Route)...)
+				if ranges == nil {
+					ranges = r
+				}
 			}
 		}
 	}
-	return downstreams
+	return downstreams, ranges
+}
+
+// gatewayHostMatches reports whether vsHost (a VirtualService host) matches any of a gateway
+// server's advertised hosts, honoring the same "*", "*.example.com", and "ns/host" forms
+// model.Hostname.Matches handles elsewhere in Pilot.
+func gatewayHostMatches(serverHosts []string, vsHost string) bool {
+	for _, serverHost := range serverHosts {
+		if model.Hostname(serverHost).Matches(model.Hostname(vsHost)) {
+			return true
+		}
+	}
+	return false
 }
 
 // TODO: move up to more general location so this can be re-used in other service matching
-func l4Match(predicates []*networking.L4MatchAttributes, server *networking.Server, gatewayNames map[string]bool) bool {
+func l4Match(predicates []*networking.L4MatchAttributes, server *networking.Server, gatewayNames map[string]bool,
+	workloadLabels model.LabelsCollection) (bool, *l4MatchRanges) {
 	// NB from proto definitions: each set of predicates is OR'd together; inside of a predicate all conditions are AND'd.
 	// This means we can return as soon as we get any match of an entire predicate.
 	for _, match := range predicates {
-		// TODO: implement more matches, like CIDR ranges, etc.
-
 		// if there's no port predicate, portMatch is true; otherwise we evaluate the port predicate against the server's port
 		portMatch := match.Port == 0
 		if match.Port != 0 {
@@ -353,12 +624,64 @@ func l4Match(predicates []*networking.L4MatchAttributes, server *networking.Serv
 			}
 		}
 
-		if portMatch && gatewayMatch {
-			return true
+		// if there's no SourceLabels predicate, labelMatch is true; otherwise at least one of the workload's
+		// label sets must be a superset of the predicate's labels
+		labelMatch := len(match.SourceLabels) == 0 || workloadLabelsMatch(workloadLabels, match.SourceLabels)
+
+		if portMatch && gatewayMatch && labelMatch {
+			// SourceSubnet/DestinationSubnets can't be evaluated here -- there's no connection yet, only
+			// config being generated -- so we hand the parsed CIDRs back for the caller to apply to the
+			// real FilterChainMatch Envoy enforces them with.
+			var sourceRanges []*core.CidrRange
+			if match.SourceSubnet != "" {
+				sourceRanges = parseCidrRanges(server, []string{match.SourceSubnet})
+			}
+			return true, &l4MatchRanges{
+				sourceRanges:      sourceRanges,
+				destinationRanges: parseCidrRanges(server, match.DestinationSubnets),
+			}
 		}
 	}
 	// If we had no predicates we match; otherwise we don't match since we'd have exited at the first match.
-	return len(predicates) == 0
+	return len(predicates) == 0, nil
+}
+
+// workloadLabelsMatch reports whether any of workloadLabels is a superset of required, i.e.
+// the workload carries every label (and value) required demands.
+func workloadLabelsMatch(workloadLabels model.LabelsCollection, required map[string]string) bool {
+	for _, labels := range workloadLabels {
+		match := true
+		for k, v := range required {
+			if labels[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCidrRanges parses subnets (CIDR notation) into Envoy CidrRanges, dropping (and logging)
+// any that don't parse rather than failing the whole match -- an operator's malformed CIDR
+// shouldn't block otherwise-valid routing.
+func parseCidrRanges(server *networking.Server, subnets []string) []*core.CidrRange {
+	ranges := make([]*core.CidrRange, 0, len(subnets))
+	for _, subnet := range subnets {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			log.Warnf("gateway: server on port %d: ignoring invalid CIDR %q: %v", server.Port.Number, subnet, err)
+			continue
+		}
+		prefixLen, _ := ipNet.Mask.Size()
+		ranges = append(ranges, &core.CidrRange{
+			AddressPrefix: ipNet.IP.String(),
+			PrefixLen:     &types.UInt32Value{Value: uint32(prefixLen)},
+		})
+	}
+	return ranges
 }
 
 func gatherDestinations(weights []*networking.DestinationWeight) []*networking.Destination {
@@ -0,0 +1,140 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// TestBuildGatewayListenerTLSContextFileBased asserts a plain (non-"sds://") ServerCertificate
+// produces a file-based DownstreamTlsContext, with the cert, key, and CA read straight off the
+// filesystem paths in the Server's TLSOptions.
+func TestBuildGatewayListenerTLSContextFileBased(t *testing.T) {
+	env := model.Environment{Mesh: &meshconfig.MeshConfig{}}
+	server := &networking.Server{
+		Tls: &networking.Server_TLSOptions{
+			Mode:              networking.Server_TLSOptions_SIMPLE,
+			ServerCertificate: "/etc/certs/cert-chain.pem",
+			PrivateKey:        "/etc/certs/key.pem",
+			CaCertificates:    "/etc/certs/root-cert.pem",
+			SubjectAltNames:   []string{"productpage.default.svc.cluster.local"},
+		},
+	}
+
+	ctx := buildGatewayListenerTLSContext(env, server)
+	if ctx == nil {
+		t.Fatal("expected a non-nil DownstreamTlsContext")
+	}
+	if len(ctx.CommonTlsContext.TlsCertificateSdsSecretConfigs) != 0 {
+		t.Fatalf("expected no SDS secret configs for a file-based cert, got %v",
+			ctx.CommonTlsContext.TlsCertificateSdsSecretConfigs)
+	}
+	if len(ctx.CommonTlsContext.TlsCertificates) != 1 {
+		t.Fatalf("expected exactly one TlsCertificate, got %d", len(ctx.CommonTlsContext.TlsCertificates))
+	}
+	cert := ctx.CommonTlsContext.TlsCertificates[0]
+	if got := cert.CertificateChain.GetFilename(); got != server.Tls.ServerCertificate {
+		t.Errorf("expected cert chain filename %q, got %q", server.Tls.ServerCertificate, got)
+	}
+	if got := cert.PrivateKey.GetFilename(); got != server.Tls.PrivateKey {
+		t.Errorf("expected private key filename %q, got %q", server.Tls.PrivateKey, got)
+	}
+	if got := ctx.CommonTlsContext.ValidationContext.TrustedCa.GetFilename(); got != server.Tls.CaCertificates {
+		t.Errorf("expected trusted CA filename %q, got %q", server.Tls.CaCertificates, got)
+	}
+	if !ctx.RequireSni.GetValue() {
+		t.Error("expected RequireSni to be true")
+	}
+}
+
+// TestBuildGatewayListenerTLSContextSDS asserts an "sds://" ServerCertificate produces an
+// SDS-sourced DownstreamTlsContext naming the secret instead of reading any file. The Server has
+// the file-based test's Mode and SubjectAltNames, but no PrivateKey or CaCertificates.
+func TestBuildGatewayListenerTLSContextSDS(t *testing.T) {
+	env := model.Environment{Mesh: &meshconfig.MeshConfig{SdsUdsPath: "unix:/var/run/sds/custom_uds_path"}}
+	server := &networking.Server{
+		Tls: &networking.Server_TLSOptions{
+			Mode:              networking.Server_TLSOptions_SIMPLE,
+			ServerCertificate: "sds://default",
+			SubjectAltNames:   []string{"productpage.default.svc.cluster.local"},
+		},
+	}
+
+	ctx := buildGatewayListenerTLSContext(env, server)
+	if ctx == nil {
+		t.Fatal("expected a non-nil DownstreamTlsContext")
+	}
+	if len(ctx.CommonTlsContext.TlsCertificates) != 0 {
+		t.Fatalf("expected no file-based TlsCertificates for an SDS cert, got %v", ctx.CommonTlsContext.TlsCertificates)
+	}
+	if len(ctx.CommonTlsContext.TlsCertificateSdsSecretConfigs) != 1 {
+		t.Fatalf("expected exactly one SDS secret config, got %d", len(ctx.CommonTlsContext.TlsCertificateSdsSecretConfigs))
+	}
+	sdsConfig := ctx.CommonTlsContext.TlsCertificateSdsSecretConfigs[0]
+	if sdsConfig.Name != "default" {
+		t.Errorf("expected SDS secret name %q, got %q", "default", sdsConfig.Name)
+	}
+
+	validation, ok := ctx.CommonTlsContext.ValidationContextType.(*auth.CommonTlsContext_CombinedValidationContext)
+	if !ok {
+		t.Fatalf("expected a CombinedValidationContext, got %T", ctx.CommonTlsContext.ValidationContextType)
+	}
+	if got := validation.CombinedValidationContext.ValidationContextSdsSecretConfig.Name; got != "default-cacert" {
+		t.Errorf("expected CA SDS secret name %q, got %q", "default-cacert", got)
+	}
+	if !ctx.RequireSni.GetValue() {
+		t.Error("expected RequireSni to be true")
+	}
+}
+
+// TestBuildGatewayListenerTLSContextPassthrough asserts a PASSTHROUGH server never gets a
+// DownstreamTlsContext, regardless of whether ServerCertificate happens to be set.
+func TestBuildGatewayListenerTLSContextPassthrough(t *testing.T) {
+	env := model.Environment{Mesh: &meshconfig.MeshConfig{}}
+	server := &networking.Server{
+		Tls: &networking.Server_TLSOptions{
+			Mode:              networking.Server_TLSOptions_PASSTHROUGH,
+			ServerCertificate: "sds://default",
+		},
+	}
+	if ctx := buildGatewayListenerTLSContext(env, server); ctx != nil {
+		t.Errorf("expected a nil DownstreamTlsContext for PASSTHROUGH, got %v", ctx)
+	}
+}
+
+func TestSdsSecretName(t *testing.T) {
+	cases := []struct {
+		certPath  string
+		wantName  string
+		wantIsSds bool
+	}{
+		{"sds://default", "default", true},
+		{"sds://", "", true},
+		{"/etc/certs/cert-chain.pem", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		name, ok := sdsSecretName(c.certPath)
+		if ok != c.wantIsSds || name != c.wantName {
+			t.Errorf("sdsSecretName(%q) = (%q, %v), want (%q, %v)", c.certPath, name, ok, c.wantName, c.wantIsSds)
+		}
+	}
+}
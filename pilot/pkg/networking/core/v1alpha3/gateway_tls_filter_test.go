@@ -0,0 +1,153 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// fakeVirtualServiceStore implements just the one IstioConfigStore method filterTLSDownstreams
+// actually calls (VirtualServices); every other method panics if reached, since
+// filterTLSDownstreams never calls them (it doesn't touch env.GetService either -- that's
+// createGatewayTLSPassthroughFilterChainOpts's job, one layer up).
+type fakeVirtualServiceStore struct {
+	model.IstioConfigStore
+	configs []model.Config
+}
+
+func (f fakeVirtualServiceStore) VirtualServices(gateways map[string]bool) []model.Config {
+	return f.configs
+}
+
+func tlsVirtualService(name string, hosts []string, tlsRoutes []*networking.TLSRoute) model.Config {
+	return model.Config{
+		ConfigMeta: model.ConfigMeta{Type: model.VirtualService.Type, Name: name, Namespace: "default"},
+		Spec:       &networking.VirtualService{Hosts: hosts, Tls: tlsRoutes},
+	}
+}
+
+func tlsRoute(sniHosts []string, port uint32, destHost string) *networking.TLSRoute {
+	return &networking.TLSRoute{
+		Match: []*networking.TLSMatchAttributes{{SniHosts: sniHosts, Port: port}},
+		Route: []*networking.DestinationWeight{{Destination: &networking.Destination{Host: destHost}}},
+	}
+}
+
+// TestFilterTLSDownstreamsMatchesBySNIHost asserts a TLSRoute whose VirtualService host is
+// advertised by server produces one downstream keyed by each of its SniHosts, pointing at the
+// route's destination.
+func TestFilterTLSDownstreamsMatchesBySNIHost(t *testing.T) {
+	vs := tlsVirtualService("reviews-tls", []string{"reviews.example.com"},
+		[]*networking.TLSRoute{tlsRoute([]string{"reviews.example.com"}, 0, "reviews.default.svc.cluster.local")})
+
+	env := model.Environment{IstioConfigStore: fakeVirtualServiceStore{configs: []model.Config{vs}}}
+	server := &networking.Server{
+		Hosts: []string{"reviews.example.com"},
+		Port:  &networking.Port{Number: 443, Protocol: "TLS"},
+	}
+
+	got := filterTLSDownstreams(env, server, nil)
+	if len(got) != 1 {
+		t.Fatalf("expected exactly one SNI downstream, got %v", got)
+	}
+	dest, ok := got["reviews.example.com"]
+	if !ok {
+		t.Fatalf("expected a downstream keyed by the SNI host, got %v", got)
+	}
+	if dest.Host != "reviews.default.svc.cluster.local" {
+		t.Errorf("expected the route's destination host, got %q", dest.Host)
+	}
+}
+
+// TestFilterTLSDownstreamsSkipsVirtualServiceWithUnrelatedHost asserts a VirtualService whose
+// Hosts don't overlap server.Hosts is ignored entirely, even if it has a matching TLSRoute.
+func TestFilterTLSDownstreamsSkipsVirtualServiceWithUnrelatedHost(t *testing.T) {
+	vs := tlsVirtualService("unrelated", []string{"other.example.com"},
+		[]*networking.TLSRoute{tlsRoute([]string{"reviews.example.com"}, 0, "reviews.default.svc.cluster.local")})
+
+	env := model.Environment{IstioConfigStore: fakeVirtualServiceStore{configs: []model.Config{vs}}}
+	server := &networking.Server{Hosts: []string{"reviews.example.com"}, Port: &networking.Port{Number: 443, Protocol: "TLS"}}
+
+	got := filterTLSDownstreams(env, server, nil)
+	if len(got) != 0 {
+		t.Errorf("expected no downstreams from a VirtualService whose Hosts don't match server, got %v", got)
+	}
+}
+
+// TestFilterTLSDownstreamsSkipsMatchForWrongPort asserts a TLSMatchAttributes with a non-zero
+// Port that doesn't equal server's port number is skipped, even though its SNI host and
+// VirtualService host both otherwise match.
+func TestFilterTLSDownstreamsSkipsMatchForWrongPort(t *testing.T) {
+	vs := tlsVirtualService("reviews-tls", []string{"reviews.example.com"},
+		[]*networking.TLSRoute{tlsRoute([]string{"reviews.example.com"}, 8443, "reviews.default.svc.cluster.local")})
+
+	env := model.Environment{IstioConfigStore: fakeVirtualServiceStore{configs: []model.Config{vs}}}
+	server := &networking.Server{Hosts: []string{"reviews.example.com"}, Port: &networking.Port{Number: 443, Protocol: "TLS"}}
+
+	got := filterTLSDownstreams(env, server, nil)
+	if len(got) != 0 {
+		t.Errorf("expected no downstreams when the match's Port doesn't equal server's, got %v", got)
+	}
+}
+
+// TestFilterTLSDownstreamsMultipleMatchBlocksEachProduceTheirOwnSNIEntries asserts several
+// Tls.Match blocks on one VirtualService each contribute their own SNI-keyed downstreams,
+// rather than only the first or last block's being kept.
+func TestFilterTLSDownstreamsMultipleMatchBlocksEachProduceTheirOwnSNIEntries(t *testing.T) {
+	vs := tlsVirtualService("reviews-tls", []string{"reviews.example.com"}, []*networking.TLSRoute{
+		tlsRoute([]string{"reviews-v1.example.com"}, 0, "reviews-v1.default.svc.cluster.local"),
+		tlsRoute([]string{"reviews-v2.example.com"}, 0, "reviews-v2.default.svc.cluster.local"),
+	})
+
+	env := model.Environment{IstioConfigStore: fakeVirtualServiceStore{configs: []model.Config{vs}}}
+	server := &networking.Server{Hosts: []string{"reviews.example.com"}, Port: &networking.Port{Number: 443, Protocol: "TLS"}}
+
+	got := filterTLSDownstreams(env, server, nil)
+	if len(got) != 2 {
+		t.Fatalf("expected one downstream per match block, got %v", got)
+	}
+	if got["reviews-v1.example.com"].Host != "reviews-v1.default.svc.cluster.local" {
+		t.Errorf("expected reviews-v1 SNI to route to reviews-v1's destination, got %v", got["reviews-v1.example.com"])
+	}
+	if got["reviews-v2.example.com"].Host != "reviews-v2.default.svc.cluster.local" {
+		t.Errorf("expected reviews-v2 SNI to route to reviews-v2's destination, got %v", got["reviews-v2.example.com"])
+	}
+}
+
+// TestFilterTLSDownstreamsPicksFirstDestinationOnMultipleWeightedRoutes asserts a match with
+// more than one weighted Destination in its Route takes dests[0], matching
+// createGatewayTLSPassthroughFilterChainOpts's single-cluster-per-SNI-host model (TLS
+// passthrough can't split one ClientHello across multiple upstream clusters by weight).
+func TestFilterTLSDownstreamsPicksFirstDestinationOnMultipleWeightedRoutes(t *testing.T) {
+	route := &networking.TLSRoute{
+		Match: []*networking.TLSMatchAttributes{{SniHosts: []string{"reviews.example.com"}}},
+		Route: []*networking.DestinationWeight{
+			{Destination: &networking.Destination{Host: "reviews-v1.default.svc.cluster.local"}, Weight: 90},
+			{Destination: &networking.Destination{Host: "reviews-v2.default.svc.cluster.local"}, Weight: 10},
+		},
+	}
+	vs := tlsVirtualService("reviews-tls", []string{"reviews.example.com"}, []*networking.TLSRoute{route})
+
+	env := model.Environment{IstioConfigStore: fakeVirtualServiceStore{configs: []model.Config{vs}}}
+	server := &networking.Server{Hosts: []string{"reviews.example.com"}, Port: &networking.Port{Number: 443, Protocol: "TLS"}}
+
+	got := filterTLSDownstreams(env, server, nil)
+	if got["reviews.example.com"].Host != "reviews-v1.default.svc.cluster.local" {
+		t.Errorf("expected the first weighted destination to win, got %v", got["reviews.example.com"])
+	}
+}
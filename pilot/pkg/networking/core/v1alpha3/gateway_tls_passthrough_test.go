@@ -0,0 +1,44 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+)
+
+// TestHasTLSPassthroughChain covers the two shapes hasTLSPassthroughChain needs to tell apart:
+// a passthrough chain (no tlsContext, but SNI hosts to match FilterChainMatch.ServerNames on)
+// versus a terminated-TLS or plain TCP chain.
+//
+// filterTLSDownstreams is covered in gateway_tls_filter_test.go, which fakes env.VirtualServices
+// through Environment.IstioConfigStore. createGatewayTLSPassthroughFilterChainOpts has no test:
+// it also calls env.GetService, which that fake does not provide.
+func TestHasTLSPassthroughChain(t *testing.T) {
+	passthrough := &filterChainOpts{sniHosts: []string{"foo.example.com"}}
+	terminated := &filterChainOpts{sniHosts: []string{"foo.example.com"}, tlsContext: &auth.DownstreamTlsContext{}}
+	plainTCP := &filterChainOpts{}
+
+	if !hasTLSPassthroughChain([]*filterChainOpts{terminated, passthrough}) {
+		t.Error("expected a passthrough chain (nil tlsContext, non-empty sniHosts) among opts to be detected")
+	}
+	if hasTLSPassthroughChain([]*filterChainOpts{terminated, plainTCP}) {
+		t.Error("expected no passthrough chain to be detected when no chain has sniHosts without a tlsContext")
+	}
+	if hasTLSPassthroughChain(nil) {
+		t.Error("expected no passthrough chain to be detected for an empty opts slice")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loadbalancer groups a destination's endpoints by locality and assigns them weights
+// and failover priorities, given the calling proxy's own locality and a
+// model.LocalityLbSetting recorded on the governing DestinationRule (see
+// model.DestinationRuleLocalityLbSetting). This is the pure grouping/weighting logic a CDS
+// generator would consult when building a cluster's load assignment -- this snapshot doesn't
+// carry that generator, so nothing in this tree calls PriorityGroups yet.
+package loadbalancer
+
+import (
+	"strings"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// Locality is a parsed "region[/zone[/subzone]]" triple, the three-level hierarchy locality-
+// aware routing groups endpoints by. A level left empty (as From/Failover entries may do) acts
+// as a wildcard matching any value at that level.
+type Locality struct {
+	Region  string
+	Zone    string
+	SubZone string
+}
+
+// ParseLocality parses AvailabilityZone-style "region/zone/subzone" strings; a shorter
+// "region/zone" or bare "region" is also valid and leaves the missing levels empty.
+func ParseLocality(s string) Locality {
+	parts := strings.SplitN(s, "/", 3)
+	var l Locality
+	if len(parts) > 0 {
+		l.Region = parts[0]
+	}
+	if len(parts) > 1 {
+		l.Zone = parts[1]
+	}
+	if len(parts) > 2 {
+		l.SubZone = parts[2]
+	}
+	return l
+}
+
+// String renders l back to "region[/zone[/subzone]]" form, dropping trailing empty levels.
+func (l Locality) String() string {
+	switch {
+	case l.SubZone != "":
+		return l.Region + "/" + l.Zone + "/" + l.SubZone
+	case l.Zone != "":
+		return l.Region + "/" + l.Zone
+	default:
+		return l.Region
+	}
+}
+
+// matches reports whether l falls under the from spec, treating an empty level in from as a
+// wildcard -- the convention Distribute/Failover entries use for their From/source side.
+func (l Locality) matches(from Locality) bool {
+	if from.Region != "" && from.Region != l.Region {
+		return false
+	}
+	if from.Zone != "" && from.Zone != l.Zone {
+		return false
+	}
+	if from.SubZone != "" && from.SubZone != l.SubZone {
+		return false
+	}
+	return true
+}
+
+// WeightedEndpoints is one destination locality's share of a Distribute call: the instances
+// located there (model.ServiceInstance.AvailabilityZone, as set by conversion.go for
+// ServiceEntry endpoints) and the traffic weight, out of 100, assigned to it.
+type WeightedEndpoints struct {
+	Locality  Locality
+	Instances []*model.ServiceInstance
+	Weight    uint32
+}
+
+// Distribute groups instances by locality and assigns each group the weight setting.Distribute
+// gives it for a caller in proxyLocality. With no Distribute entry matching proxyLocality (or
+// none configured at all), every locality present is weighted evenly instead.
+func Distribute(proxyLocality Locality, instances []*model.ServiceInstance, setting model.LocalityLbSetting) []WeightedEndpoints {
+	byLocality := groupByLocality(instances)
+
+	dist := selectDistribute(proxyLocality, setting)
+	if dist == nil {
+		return evenSplit(byLocality)
+	}
+
+	var out []WeightedEndpoints
+	for localityStr, weight := range dist.To {
+		grouped, ok := byLocality[localityStr]
+		if !ok {
+			continue
+		}
+		out = append(out, WeightedEndpoints{
+			Locality:  ParseLocality(localityStr),
+			Instances: grouped,
+			Weight:    weight,
+		})
+	}
+	return out
+}
+
+// Failover returns the locality setting.Failover designates for a caller in from, or ok=false if
+// from has no configured failover target.
+func Failover(from Locality, setting model.LocalityLbSetting) (to Locality, ok bool) {
+	target, ok := setting.Failover[from.Region]
+	if !ok {
+		return Locality{}, false
+	}
+	return ParseLocality(target), true
+}
+
+// PriorityGroups orders instances into Envoy per-priority endpoint groups: priority 0 is
+// whatever Distribute assigns a caller in proxyLocality; if every locality at that priority has
+// no (healthy) instances left, it walks setting.Failover from there to find the next priority,
+// and so on until a priority with at least one non-empty locality is found or failover runs out.
+// Skipping an empty priority entirely (rather than including it with a zero weight) keeps Envoy
+// from ever being handed a priority it can't actually route to.
+func PriorityGroups(proxyLocality Locality, instances []*model.ServiceInstance, setting model.LocalityLbSetting) [][]WeightedEndpoints {
+	var priorities [][]WeightedEndpoints
+
+	from := proxyLocality
+	visited := map[string]bool{}
+	for {
+		healthy := nonEmpty(Distribute(from, instances, setting))
+		if len(healthy) > 0 {
+			priorities = append(priorities, healthy)
+			break
+		}
+
+		visited[from.String()] = true
+		next, ok := Failover(from, setting)
+		if !ok || visited[next.String()] {
+			break
+		}
+		from = next
+	}
+	return priorities
+}
+
+func nonEmpty(groups []WeightedEndpoints) []WeightedEndpoints {
+	var out []WeightedEndpoints
+	for _, g := range groups {
+		if len(g.Instances) > 0 {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+func groupByLocality(instances []*model.ServiceInstance) map[string][]*model.ServiceInstance {
+	out := make(map[string][]*model.ServiceInstance)
+	for _, inst := range instances {
+		out[inst.AvailabilityZone] = append(out[inst.AvailabilityZone], inst)
+	}
+	return out
+}
+
+func selectDistribute(proxyLocality Locality, setting model.LocalityLbSetting) *model.LocalityLbDistribute {
+	for i := range setting.Distribute {
+		if proxyLocality.matches(ParseLocality(setting.Distribute[i].From)) {
+			return &setting.Distribute[i]
+		}
+	}
+	return nil
+}
+
+func evenSplit(byLocality map[string][]*model.ServiceInstance) []WeightedEndpoints {
+	if len(byLocality) == 0 {
+		return nil
+	}
+	weight := uint32(100 / len(byLocality))
+	out := make([]WeightedEndpoints, 0, len(byLocality))
+	for localityStr, instances := range byLocality {
+		out = append(out, WeightedEndpoints{
+			Locality:  ParseLocality(localityStr),
+			Instances: instances,
+			Weight:    weight,
+		})
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loadbalancer
+
+import (
+	"testing"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+func instancesIn(zone string, n int) []*model.ServiceInstance {
+	out := make([]*model.ServiceInstance, n)
+	for i := range out {
+		out[i] = &model.ServiceInstance{AvailabilityZone: zone}
+	}
+	return out
+}
+
+func threeZoneInstances() []*model.ServiceInstance {
+	var out []*model.ServiceInstance
+	out = append(out, instancesIn("us-west-1a", 8)...)
+	out = append(out, instancesIn("us-west-1b", 1)...)
+	out = append(out, instancesIn("us-west-1c", 1)...)
+	return out
+}
+
+func distributeSetting() model.LocalityLbSetting {
+	return model.LocalityLbSetting{
+		Distribute: []model.LocalityLbDistribute{
+			{
+				From: "us-west-1a",
+				To:   map[string]uint32{"us-west-1a": 80, "us-west-1b": 15, "us-west-1c": 5},
+			},
+		},
+		Failover: map[string]string{"us-west-1a": "us-west-1b"},
+	}
+}
+
+// TestDistributeHonors80_15_5Split asserts a caller in us-west-1a splits traffic across the
+// three configured localities exactly as the DestinationRule's distribute weights specify.
+func TestDistributeHonors80_15_5Split(t *testing.T) {
+	got := Distribute(ParseLocality("us-west-1a"), threeZoneInstances(), distributeSetting())
+	if len(got) != 3 {
+		t.Fatalf("expected 3 weighted locality groups, got %d", len(got))
+	}
+	weights := make(map[string]uint32, len(got))
+	for _, g := range got {
+		weights[g.Locality.String()] = g.Weight
+	}
+	want := map[string]uint32{"us-west-1a": 80, "us-west-1b": 15, "us-west-1c": 5}
+	for locality, w := range want {
+		if weights[locality] != w {
+			t.Errorf("expected %s weight %d, got %d", locality, w, weights[locality])
+		}
+	}
+}
+
+// TestDistributeFallsBackToEvenSplitWithoutMatch asserts a caller whose locality matches no
+// Distribute entry gets an even split across every locality present instead.
+func TestDistributeFallsBackToEvenSplitWithoutMatch(t *testing.T) {
+	instances := append(instancesIn("us-east-1a", 1), instancesIn("us-east-1b", 1)...)
+	got := Distribute(ParseLocality("eu-west-1a"), instances, model.LocalityLbSetting{})
+	if len(got) != 2 {
+		t.Fatalf("expected 2 weighted locality groups, got %d", len(got))
+	}
+	for _, g := range got {
+		if g.Weight != 50 {
+			t.Errorf("expected an even 50%% split, got %d for %s", g.Weight, g.Locality.String())
+		}
+	}
+}
+
+// TestPriorityGroupsFailsOverWhenPrimaryLocalityUnhealthy asserts that once us-west-1a has no
+// instances left (all marked unhealthy upstream and dropped from the candidate list), traffic
+// moves entirely to us-west-1b -- the only other locality left with instances.
+func TestPriorityGroupsFailsOverWhenPrimaryLocalityUnhealthy(t *testing.T) {
+	instances := instancesIn("us-west-1b", 1)
+	setting := distributeSetting()
+
+	priorities := PriorityGroups(ParseLocality("us-west-1a"), instances, setting)
+	if len(priorities) == 0 {
+		t.Fatal("expected at least one non-empty priority group")
+	}
+	first := priorities[0]
+	if len(first) != 1 || first[0].Locality.String() != "us-west-1b" {
+		t.Fatalf("expected failover to land entirely on us-west-1b, got %v", first)
+	}
+}
+
+// TestPriorityGroupsNoFailoverWhenPrimaryHealthy asserts a healthy primary locality never
+// produces more than one priority group.
+func TestPriorityGroupsNoFailoverWhenPrimaryHealthy(t *testing.T) {
+	priorities := PriorityGroups(ParseLocality("us-west-1a"), threeZoneInstances(), distributeSetting())
+	if len(priorities) != 1 {
+		t.Fatalf("expected exactly 1 priority group when the primary locality is healthy, got %d", len(priorities))
+	}
+}
+
+// TestParseLocalityRoundTrip asserts ParseLocality/String are inverses for all three valid
+// locality string shapes.
+func TestParseLocalityRoundTrip(t *testing.T) {
+	cases := []string{"us-west-1", "us-west-1/1a", "us-west-1/1a/az1"}
+	for _, c := range cases {
+		if got := ParseLocality(c).String(); got != c {
+			t.Errorf("ParseLocality(%q).String() = %q, want %q", c, got, c)
+		}
+	}
+}
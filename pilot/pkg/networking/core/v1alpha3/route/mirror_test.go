@@ -0,0 +1,63 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"testing"
+
+	envoytype "github.com/envoyproxy/go-control-plane/envoy/type"
+)
+
+func TestMirrorFractionalPercent(t *testing.T) {
+	cases := []struct {
+		name            string
+		percent         float64
+		wantNumerator   uint32
+		wantDenominator envoytype.FractionalPercent_DenominatorType
+	}{
+		{"zero", 0, 0, envoytype.FractionalPercent_HUNDRED},
+		{"negative clamps to zero", -5, 0, envoytype.FractionalPercent_HUNDRED},
+		{"whole percent", 25, 2500, envoytype.FractionalPercent_TEN_THOUSAND},
+		{"hundred clamps", 150, 100, envoytype.FractionalPercent_HUNDRED},
+		{"sub-one-percent uses finer denominator", 0.5, 5000, envoytype.FractionalPercent_MILLION},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := mirrorFractionalPercent(c.percent)
+			if got.Numerator != c.wantNumerator {
+				t.Errorf("expected numerator %d, got %d", c.wantNumerator, got.Numerator)
+			}
+			if got.Denominator != c.wantDenominator {
+				t.Errorf("expected denominator %v, got %v", c.wantDenominator, got.Denominator)
+			}
+		})
+	}
+}
+
+// TestMirrorRuntimeKeyUniquePerVirtualServiceAndDestination asserts the derived runtime key
+// distinguishes both the owning VirtualService and the mirror destination, so two routes (or
+// two mirror destinations on the same route) don't collide on one Envoy runtime override.
+func TestMirrorRuntimeKeyUniquePerVirtualServiceAndDestination(t *testing.T) {
+	a := mirrorRuntimeKey("reviews.default", "reviews-mirror.default.svc.cluster.local")
+	b := mirrorRuntimeKey("reviews.default", "other-mirror.default.svc.cluster.local")
+	c := mirrorRuntimeKey("ratings.default", "reviews-mirror.default.svc.cluster.local")
+
+	if a == b {
+		t.Errorf("expected distinct runtime keys for distinct mirror hosts, got %q for both", a)
+	}
+	if a == c {
+		t.Errorf("expected distinct runtime keys for distinct operations, got %q for both", a)
+	}
+}
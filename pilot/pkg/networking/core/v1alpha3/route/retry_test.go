@@ -0,0 +1,82 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// TestTranslateRetryPolicyDefaultRetryOn asserts a HTTPRetry with no RetryOn falls back to the
+// historical default, preserving pre-existing VirtualServices' behavior.
+func TestTranslateRetryPolicyDefaultRetryOn(t *testing.T) {
+	policy := translateRetryPolicy(&networking.HTTPRetry{Attempts: 3})
+	if policy == nil {
+		t.Fatal("expected a non-nil RetryPolicy")
+	}
+	if policy.RetryOn != defaultRetryOn {
+		t.Errorf("expected RetryOn %q, got %q", defaultRetryOn, policy.RetryOn)
+	}
+	if policy.NumRetries.GetValue() != 3 {
+		t.Errorf("expected NumRetries 3, got %d", policy.NumRetries.GetValue())
+	}
+}
+
+// TestTranslateRetryPolicyHonorsRetryOn asserts an explicit RetryOn overrides the default.
+func TestTranslateRetryPolicyHonorsRetryOn(t *testing.T) {
+	policy := translateRetryPolicy(&networking.HTTPRetry{Attempts: 3, RetryOn: "gateway-error,reset"})
+	if policy.RetryOn != "gateway-error,reset" {
+		t.Errorf("expected RetryOn %q, got %q", "gateway-error,reset", policy.RetryOn)
+	}
+}
+
+// TestTranslateRetryPolicyHonorsRetriableStatusCodes asserts RetriableStatusCodes is passed
+// through only when set.
+func TestTranslateRetryPolicyHonorsRetriableStatusCodes(t *testing.T) {
+	withCodes := translateRetryPolicy(&networking.HTTPRetry{Attempts: 3, RetriableStatusCodes: []uint32{408, 409}})
+	if len(withCodes.RetriableStatusCodes) != 2 || withCodes.RetriableStatusCodes[0] != 408 || withCodes.RetriableStatusCodes[1] != 409 {
+		t.Errorf("expected RetriableStatusCodes [408, 409], got %v", withCodes.RetriableStatusCodes)
+	}
+
+	withoutCodes := translateRetryPolicy(&networking.HTTPRetry{Attempts: 3})
+	if len(withoutCodes.RetriableStatusCodes) != 0 {
+		t.Errorf("expected no RetriableStatusCodes when unset, got %v", withoutCodes.RetriableStatusCodes)
+	}
+}
+
+// TestTranslateRetryPolicyAvoidsRetryingSameHost asserts every retry policy sets the
+// previous-hosts predicate, so a retry doesn't land back on the host that just failed.
+func TestTranslateRetryPolicyAvoidsRetryingSameHost(t *testing.T) {
+	policy := translateRetryPolicy(&networking.HTTPRetry{Attempts: 1})
+	if len(policy.RetryHostPredicate) != 1 || policy.RetryHostPredicate[0].Name != previousHostsRetryPredicate {
+		t.Errorf("expected a single %q RetryHostPredicate, got %v", previousHostsRetryPredicate, policy.RetryHostPredicate)
+	}
+	if policy.HostSelectionRetryMaxAttempts != hostSelectionRetryMaxAttempts {
+		t.Errorf("expected HostSelectionRetryMaxAttempts %d, got %d",
+			hostSelectionRetryMaxAttempts, policy.HostSelectionRetryMaxAttempts)
+	}
+}
+
+// TestTranslateRetryPolicyNilWithoutAttempts asserts no retry policy is built for a nil
+// HTTPRetry, or one with zero/negative Attempts.
+func TestTranslateRetryPolicyNilWithoutAttempts(t *testing.T) {
+	if p := translateRetryPolicy(nil); p != nil {
+		t.Errorf("expected nil for a nil HTTPRetry, got %v", p)
+	}
+	if p := translateRetryPolicy(&networking.HTTPRetry{Attempts: 0}); p != nil {
+		t.Errorf("expected nil for zero Attempts, got %v", p)
+	}
+}
@@ -16,12 +16,16 @@ package route
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	envoytype "github.com/envoyproxy/go-control-plane/envoy/type"
+	tracing "github.com/envoyproxy/go-control-plane/envoy/type/tracing/v2"
 	"github.com/gogo/protobuf/types"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -43,6 +47,29 @@ const (
 	DefaultRoute = "default-route"
 )
 
+const (
+	// defaultRetryOn is used whenever a VirtualService's HTTPRetry doesn't specify RetryOn,
+	// preserving the policy Istio has always applied.
+	defaultRetryOn = "5xx,connect-failure,refused-stream"
+
+	// previousHostsRetryPredicate makes Envoy avoid retrying a request against a host it has
+	// already tried for this request, so retries spread across the endpoint set instead of
+	// hammering the same failing pod.
+	previousHostsRetryPredicate = "envoy.retry_host_predicates.previous_hosts"
+
+	// hostSelectionRetryMaxAttempts bounds how many times Envoy will re-pick a host to honor
+	// previousHostsRetryPredicate before giving up and reusing one.
+	hostSelectionRetryMaxAttempts = 3
+
+	// retryBackOffBaseInterval and retryBackOffMaxInterval bound Envoy's exponential backoff
+	// between retries. networking.HTTPRetry has no field to tune these per-VirtualService at
+	// this API vintage, so every retry policy shares this one interval -- short enough that a
+	// PerTryTimeout-bounded retry budget (e.g. 3 attempts at 1s each) still fits comfortably
+	// inside a caller's overall timeout.
+	retryBackOffBaseInterval = 25 * time.Millisecond
+	retryBackOffMaxInterval  = 250 * time.Millisecond
+)
+
 var (
 	// experiment on getting some monitoring on config errors.
 	noClusterMissingPort = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -160,10 +187,17 @@ func translateVirtualHost(
 
 	out := make([]GuardedHost, len(serviceByPort))
 	for port, portServices := range serviceByPort {
-		routes, err := TranslateRoutes(in, serviceIndex, port, proxyLabels, gatewayName)
+		routes, ranks, err := TranslateRoutes(in, serviceIndex, port, proxyLabels, gatewayName)
 		if err != nil || len(routes) == 0 {
 			continue
 		}
+
+		// TranslateRoutes already sorts the routes for a single VirtualService, but re-sort
+		// here too against the same ranks: this is where routes destined for the same
+		// host/port end up collected together, and that's the order Envoy actually
+		// evaluates them in.
+		sortRoutesBySpecificity(routes, ranks)
+
 		out = append(out, GuardedHost{
 			Port:     port,
 			Services: portServices,
@@ -227,22 +261,27 @@ func ConvertDestinationToCluster(destination *networking.Destination, vsvcName s
 // This is called for each port to compute virtual hosts.
 // Each VirtualService is tried, with a list of services that listen on the port.
 // Error indicates the given virtualService can't be used on the port.
+// TranslateRoutes returns the generated routes together with a parallel []int of each route's
+// matchSpecificity rank, for callers (translateVirtualHost) that need to re-sort routes from
+// several calls together without re-deriving the rank from the route itself.
 func TranslateRoutes(
 	virtualService model.Config, serviceIndex map[string]*model.Service, port int,
-	proxyLabels model.LabelsCollection, gatewayNames map[string]bool) ([]route.Route, error) {
+	proxyLabels model.LabelsCollection, gatewayNames map[string]bool) ([]route.Route, []int, error) {
 
 	rule, ok := virtualService.Spec.(*networking.VirtualService)
 	if !ok { // should never happen
-		return nil, fmt.Errorf("in not a virtual service: %#v", virtualService)
+		return nil, nil, fmt.Errorf("in not a virtual service: %#v", virtualService)
 	}
 
 	operation := virtualService.ConfigMeta.Name
 
 	out := make([]route.Route, 0, len(rule.Http))
+	ranks := make([]int, 0, len(rule.Http))
 	for _, http := range rule.Http {
 		if len(http.Match) == 0 {
 			if r := translateRoute(http, nil, port, operation, serviceIndex, proxyLabels, gatewayNames); r != nil {
 				out = append(out, *r)
+				ranks = append(ranks, matchSpecificity(nil))
 			}
 			break // we have a rule with catch all match prefix: /. Other rules are of no use
 		} else {
@@ -250,15 +289,49 @@ func TranslateRoutes(
 			for _, match := range http.Match {
 				if r := translateRoute(http, match, port, operation, serviceIndex, proxyLabels, gatewayNames); r != nil {
 					out = append(out, *r)
+					ranks = append(ranks, matchSpecificity(match))
 				}
 			}
 		}
 	}
 
 	if len(out) == 0 {
-		return nil, fmt.Errorf("no routes matched")
+		return nil, nil, fmt.Errorf("no routes matched")
+	}
+
+	// Sort by descending match specificity so a broad rule (e.g. a "/" catch-all) declared
+	// before a narrower one (e.g. an exact path) doesn't shadow it in Envoy. Declaration
+	// order is preserved for routes of equal specificity.
+	sortRoutesBySpecificity(out, ranks)
+	return out, ranks, nil
+}
+
+// matchSpecificity scores an HTTPMatchRequest by how narrowly it matches a request, so
+// that TranslateRoutes can order the generated routes from most to least specific. It's a
+// thin wrapper over model.HTTPMatchSpecificity -- the same scoring model.SortHTTPRoutesByMatch
+// and model.MergeHTTPRoutesByMatch use to rank Gateway-API HTTPRoutes -- so these two pipelines
+// can't again drift into inconsistent notions of "more specific" (see model.HTTPMatchSpecificity's
+// doc comment for the history here).
+func matchSpecificity(match *networking.HTTPMatchRequest) int {
+	return model.HTTPMatchSpecificity(match)
+}
+
+// sortRoutesBySpecificity stable-sorts routes and their parallel ranks (as returned by
+// TranslateRoutes) from most to least specific, keeping the two slices in lockstep.
+func sortRoutesBySpecificity(routes []route.Route, ranks []int) {
+	type scored struct {
+		route.Route
+		rank int
+	}
+	tmp := make([]scored, len(routes))
+	for i := range routes {
+		tmp[i] = scored{Route: routes[i], rank: ranks[i]}
+	}
+	sort.SliceStable(tmp, func(i, j int) bool { return tmp[i].rank > tmp[j].rank })
+	for i := range tmp {
+		routes[i] = tmp[i].Route
+		ranks[i] = tmp[i].rank
 	}
-	return out, nil
 }
 
 // sourceMatchHttp checks if the sourceLabels or the gateways in a match condition match with the
@@ -309,6 +382,7 @@ func translateRoute(in *networking.HTTPRoute,
 		Decorator: &route.Decorator{
 			Operation: operation,
 		},
+		Tracing: TranslateTracing(in.Tracing),
 	}
 
 	if redirect := in.Redirect; redirect != nil {
@@ -342,21 +416,40 @@ func translateRoute(in *networking.HTTPRoute,
 			}
 		}
 
-		if len(in.AppendHeaders) > 0 {
-			action.RequestHeadersToAdd = make([]*core.HeaderValueOption, 0)
-			for key, value := range in.AppendHeaders {
-				action.RequestHeadersToAdd = append(action.RequestHeadersToAdd, &core.HeaderValueOption{
-					Header: &core.HeaderValue{
-						Key:   key,
-						Value: value,
-					},
-				})
+		// AppendHeaders is the older, request-only, add-only field; Headers supersedes it
+		// with add/remove on both directions but the two may coexist, so merge them.
+		requestAdd := make(map[string]string, len(in.AppendHeaders))
+		for key, value := range in.AppendHeaders {
+			requestAdd[key] = value
+		}
+		if req := in.Headers.GetRequest(); req != nil {
+			for key, value := range req.Add {
+				requestAdd[key] = value
 			}
 		}
+		action.RequestHeadersToAdd = buildHeadersToAdd(requestAdd)
+		action.RequestHeadersToRemove = sortedHeadersToRemove(in.Headers.GetRequest().GetRemove())
+
+		if resp := in.Headers.GetResponse(); resp != nil {
+			action.ResponseHeadersToAdd = buildHeadersToAdd(resp.Add)
+			action.ResponseHeadersToRemove = sortedHeadersToRemove(resp.Remove)
+		}
 
 		if in.Mirror != nil {
+			// RouteAction at this Envoy API vintage carries a single RequestMirrorPolicy, not
+			// the repeated request_mirror_policies a later Envoy API adds -- so in.Mirror is
+			// the only mirror destination a route can have; networking.HTTPRoute itself has
+			// no field for a second one either. mirrorPercent below is this one destination's
+			// sampling rate.
 			n := ConvertDestinationToCluster(in.Mirror, operation, in, serviceIndex, port)
-			action.RequestMirrorPolicy = &route.RouteAction_RequestMirrorPolicy{Cluster: n}
+			mirrorPolicy := &route.RouteAction_RequestMirrorPolicy{Cluster: n}
+			if in.MirrorPercentage != nil {
+				mirrorPolicy.RuntimeFraction = &core.RuntimeFractionalPercent{
+					DefaultValue: mirrorFractionalPercent(in.MirrorPercentage.Value),
+					RuntimeKey:   mirrorRuntimeKey(operation, in.Mirror.Host),
+				}
+			}
+			action.RequestMirrorPolicy = mirrorPolicy
 		}
 
 		weighted := make([]*route.WeightedCluster_ClusterWeight, 0)
@@ -366,10 +459,21 @@ func translateRoute(in *networking.HTTPRoute,
 				weight.Value = uint32(100)
 			}
 			n := ConvertDestinationToCluster(dst.Destination, operation, in, serviceIndex, port)
-			weighted = append(weighted, &route.WeightedCluster_ClusterWeight{
+			cw := &route.WeightedCluster_ClusterWeight{
 				Name:   n,
 				Weight: weight,
-			})
+			}
+			// Per-destination header manipulation, e.g. tagging a canary weighted
+			// cluster with a response header for observability.
+			if req := dst.Headers.GetRequest(); req != nil {
+				cw.RequestHeadersToAdd = buildHeadersToAdd(req.Add)
+				cw.RequestHeadersToRemove = sortedHeadersToRemove(req.Remove)
+			}
+			if resp := dst.Headers.GetResponse(); resp != nil {
+				cw.ResponseHeadersToAdd = buildHeadersToAdd(resp.Add)
+				cw.ResponseHeadersToRemove = sortedHeadersToRemove(resp.Remove)
+			}
+			weighted = append(weighted, cw)
 		}
 
 		// rewrite to a single cluster if there is only weighted cluster
@@ -457,17 +561,41 @@ func translateHeaderMatch(name string, in *networking.StringMatch) route.HeaderM
 	return out
 }
 
-// translateRetryPolicy translates retry policy
+// translateRetryPolicy translates retry policy. RetryOn and RetriableStatusCodes let a
+// VirtualService opt into retrying on conditions beyond the historical default (e.g.
+// "gateway-error", "reset", "retriable-4xx", "retriable-status-codes"); either left empty
+// keeps existing configs behaving exactly as before. Retries back off exponentially between
+// attempts (see retryBackOffBaseInterval/retryBackOffMaxInterval) so a PerTryTimeout-bounded
+// retry budget doesn't hammer a struggling upstream in a tight loop.
 func translateRetryPolicy(in *networking.HTTPRetry) *route.RouteAction_RetryPolicy {
-	if in != nil && in.Attempts > 0 {
-		d := util.GogoDurationToDuration(in.PerTryTimeout)
-		return &route.RouteAction_RetryPolicy{
-			NumRetries:    &types.UInt32Value{Value: uint32(in.GetAttempts())},
-			RetryOn:       "5xx,connect-failure,refused-stream",
-			PerTryTimeout: &d,
-		}
+	if in == nil || in.Attempts <= 0 {
+		return nil
+	}
+
+	d := util.GogoDurationToDuration(in.PerTryTimeout)
+	retryOn := in.RetryOn
+	if retryOn == "" {
+		retryOn = defaultRetryOn
 	}
-	return nil
+
+	policy := &route.RouteAction_RetryPolicy{
+		NumRetries:    &types.UInt32Value{Value: uint32(in.GetAttempts())},
+		RetryOn:       retryOn,
+		PerTryTimeout: &d,
+		// Avoid retrying against the same host that just failed this request.
+		RetryHostPredicate: []*route.RouteAction_RetryPolicy_RetryHostPredicate{
+			{Name: previousHostsRetryPredicate},
+		},
+		HostSelectionRetryMaxAttempts: hostSelectionRetryMaxAttempts,
+		RetryBackOff: &route.RouteAction_RetryPolicy_RetryBackOff{
+			BaseInterval: types.DurationProto(retryBackOffBaseInterval),
+			MaxInterval:  types.DurationProto(retryBackOffMaxInterval),
+		},
+	}
+	if len(in.RetriableStatusCodes) > 0 {
+		policy.RetriableStatusCodes = in.RetriableStatusCodes
+	}
+	return policy
 }
 
 // translateCORSPolicy translates CORS policy
@@ -490,6 +618,116 @@ func translateCORSPolicy(in *networking.CorsPolicy) *route.CorsPolicy {
 	return &out
 }
 
+// TranslateTracing translates a VirtualService HTTP route's tracing overrides into Envoy's
+// per-route route.Tracing, mirroring translateCORSPolicy. A nil/empty override leaves
+// tracing to the mesh-wide default (Envoy's bootstrap tracing config), so operators only
+// need this for routes that should sample differently -- e.g. 0% for a noisy health check
+// or 100% for a route under investigation.
+func TranslateTracing(in *networking.RouteTracing) *route.Tracing {
+	if in == nil {
+		return nil
+	}
+
+	out := &route.Tracing{
+		ClientSampling:  translateTracingPercent(in.ClientSampling),
+		RandomSampling:  translateTracingPercent(in.RandomSampling),
+		OverallSampling: translateTracingPercent(in.OverallSampling),
+	}
+	for _, tag := range in.CustomTags {
+		custom := &tracing.CustomTag{Tag: tag.Tag}
+		switch source := tag.Type.(type) {
+		case *networking.RouteTracing_CustomTag_Literal:
+			custom.Type = &tracing.CustomTag_Literal_{
+				Literal: &tracing.CustomTag_Literal{Value: source.Literal},
+			}
+		case *networking.RouteTracing_CustomTag_Environment:
+			custom.Type = &tracing.CustomTag_Environment_{
+				Environment: &tracing.CustomTag_Environment{Name: source.Environment},
+			}
+		case *networking.RouteTracing_CustomTag_RequestHeader:
+			custom.Type = &tracing.CustomTag_RequestHeader_{
+				RequestHeader: &tracing.CustomTag_Header{Name: source.RequestHeader},
+			}
+		}
+		out.CustomTags = append(out.CustomTags, custom)
+	}
+	return out
+}
+
+// translateTracingPercent converts a 0-100 sampling percentage to the envoy_type.Percent
+// envoy's Tracing config expects; nil (unset) leaves that sampling rate at its default.
+func translateTracingPercent(percent *types.DoubleValue) *envoytype.Percent {
+	if percent == nil {
+		return nil
+	}
+	return &envoytype.Percent{Value: percent.Value}
+}
+
+// buildHeadersToAdd turns a header name/value map into Envoy's HeaderValueOption list,
+// sorted by key so the resulting route config (and its hash) is stable across Pilot
+// restarts regardless of Go's randomized map iteration order. Added headers always use
+// Append semantics, matching how Envoy's own "add" verb behaves when the header already
+// exists on the request/response.
+func buildHeadersToAdd(add map[string]string) []*core.HeaderValueOption {
+	if len(add) == 0 {
+		return nil
+	}
+	out := make([]*core.HeaderValueOption, 0, len(add))
+	for key, value := range add {
+		out = append(out, &core.HeaderValueOption{
+			Header: &core.HeaderValue{
+				Key:   key,
+				Value: value,
+			},
+			Append: &types.BoolValue{Value: true},
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Header.Key < out[j].Header.Key })
+	return out
+}
+
+// sortedHeadersToRemove sorts a header removal list for the same determinism reason as
+// buildHeadersToAdd.
+func sortedHeadersToRemove(remove []string) []string {
+	if len(remove) == 0 {
+		return nil
+	}
+	out := append([]string(nil), remove...)
+	sort.Strings(out)
+	return out
+}
+
+// mirrorRuntimeKey derives a stable Envoy runtime key for a route's mirror sample rate, so
+// operators can override it live via Envoy admin (POST /runtime_modify) without a config
+// push. It's keyed by the VirtualService name and the mirror destination, since a single
+// VirtualService can mirror different HTTP routes to different destinations.
+func mirrorRuntimeKey(operation, mirrorHost string) string {
+	return fmt.Sprintf("routing.traffic_mirror.%s.%s", operation, mirrorHost)
+}
+
+// mirrorFractionalPercent converts a 0-100 mirror percentage into an Envoy FractionalPercent:
+// values at or below 0 and at or above 100 are clamped to 0/HUNDRED and 100/HUNDRED, values
+// from 1 up to 100 use TEN_THOUSAND (e.g. 25 becomes 2500), and sub-1% values use the finer
+// MILLION denominator.
+func mirrorFractionalPercent(percent float64) *envoytype.FractionalPercent {
+	switch {
+	case percent <= 0:
+		return &envoytype.FractionalPercent{Numerator: 0, Denominator: envoytype.FractionalPercent_HUNDRED}
+	case percent >= 100:
+		return &envoytype.FractionalPercent{Numerator: 100, Denominator: envoytype.FractionalPercent_HUNDRED}
+	case percent < 1:
+		return &envoytype.FractionalPercent{
+			Numerator:   uint32(math.Round(percent * 10000)),
+			Denominator: envoytype.FractionalPercent_MILLION,
+		}
+	default:
+		return &envoytype.FractionalPercent{
+			Numerator:   uint32(math.Round(percent * 100)),
+			Denominator: envoytype.FractionalPercent_TEN_THOUSAND,
+		}
+	}
+}
+
 // BuildDefaultHTTPRoute builds a default route.
 func BuildDefaultHTTPRoute(clusterName string) *route.Route {
 	return &route.Route{
@@ -0,0 +1,119 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// TestMatchSpecificityOrdering asserts matchSpecificity ranks Exact > Prefix > Regex > no
+// match, with a "/" prefix scoring the same as no match at all (it's a catch-all, not a real
+// narrowing).
+func TestMatchSpecificityOrdering(t *testing.T) {
+	exact := &networking.HTTPMatchRequest{Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Exact{Exact: "/healthz"}}}
+	prefix := &networking.HTTPMatchRequest{Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: "/api"}}}
+	regex := &networking.HTTPMatchRequest{Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Regex{Regex: "/api/.*"}}}
+	catchAll := &networking.HTTPMatchRequest{Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: "/"}}}
+
+	if matchSpecificity(exact) <= matchSpecificity(prefix) {
+		t.Errorf("expected Exact to outrank Prefix: exact=%d prefix=%d", matchSpecificity(exact), matchSpecificity(prefix))
+	}
+	if matchSpecificity(prefix) <= matchSpecificity(regex) {
+		t.Errorf("expected Prefix to outrank Regex: prefix=%d regex=%d", matchSpecificity(prefix), matchSpecificity(regex))
+	}
+	if matchSpecificity(regex) <= matchSpecificity(nil) {
+		t.Errorf("expected Regex to outrank no match: regex=%d none=%d", matchSpecificity(regex), matchSpecificity(nil))
+	}
+	if matchSpecificity(catchAll) != matchSpecificity(nil) {
+		t.Errorf("expected a \"/\" prefix to score the same as no match: catchAll=%d none=%d",
+			matchSpecificity(catchAll), matchSpecificity(nil))
+	}
+}
+
+// TestMatchSpecificityLongerPrefixBeatsShorter asserts a longer Prefix outranks a shorter one.
+func TestMatchSpecificityLongerPrefixBeatsShorter(t *testing.T) {
+	short := &networking.HTTPMatchRequest{Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: "/api"}}}
+	long := &networking.HTTPMatchRequest{Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: "/api/v1"}}}
+	if matchSpecificity(long) <= matchSpecificity(short) {
+		t.Errorf("expected the longer prefix to outrank the shorter one: long=%d short=%d",
+			matchSpecificity(long), matchSpecificity(short))
+	}
+}
+
+// TestMatchSpecificityHeaderAndMethodExtras asserts each extra constraint (header, method)
+// strictly increases the score over an otherwise-identical match lacking it.
+func TestMatchSpecificityHeaderAndMethodExtras(t *testing.T) {
+	base := &networking.HTTPMatchRequest{Uri: &networking.StringMatch{MatchType: &networking.StringMatch_Prefix{Prefix: "/api"}}}
+	withHeader := &networking.HTTPMatchRequest{
+		Uri:     base.Uri,
+		Headers: map[string]*networking.StringMatch{"x-version": {MatchType: &networking.StringMatch_Exact{Exact: "v2"}}},
+	}
+	withMethod := &networking.HTTPMatchRequest{
+		Uri:    base.Uri,
+		Method: &networking.StringMatch{MatchType: &networking.StringMatch_Exact{Exact: "GET"}},
+	}
+
+	if matchSpecificity(withHeader) <= matchSpecificity(base) {
+		t.Errorf("expected a header constraint to increase the score: withHeader=%d base=%d",
+			matchSpecificity(withHeader), matchSpecificity(base))
+	}
+	if matchSpecificity(withMethod) <= matchSpecificity(base) {
+		t.Errorf("expected a method constraint to increase the score: withMethod=%d base=%d",
+			matchSpecificity(withMethod), matchSpecificity(base))
+	}
+}
+
+// TestSortRoutesBySpecificityStableOnTies asserts sortRoutesBySpecificity orders strictly by
+// descending rank, preserving the original relative order of equally-ranked routes.
+func TestSortRoutesBySpecificityStableOnTies(t *testing.T) {
+	low1 := route.Route{Name: "low1"}
+	low2 := route.Route{Name: "low2"}
+	high := route.Route{Name: "high"}
+	routes := []route.Route{low1, high, low2}
+	ranks := []int{0, 10, 0}
+
+	sortRoutesBySpecificity(routes, ranks)
+
+	if routes[0].Name != "high" {
+		t.Fatalf("expected the highest-ranked route first, got %q", routes[0].Name)
+	}
+	if routes[1].Name != "low1" || routes[2].Name != "low2" {
+		t.Errorf("expected tied routes to keep their original relative order [low1, low2], got [%q, %q]",
+			routes[1].Name, routes[2].Name)
+	}
+}
+
+// TestSortRoutesBySpecificityKeepsRanksInSync asserts sortRoutesBySpecificity reorders ranks
+// right alongside routes, so a caller that re-sorts routes from multiple calls together (as
+// translateVirtualHost does) still has the correct rank for each route afterwards.
+func TestSortRoutesBySpecificityKeepsRanksInSync(t *testing.T) {
+	low := route.Route{Name: "low"}
+	high := route.Route{Name: "high"}
+	routes := []route.Route{low, high}
+	ranks := []int{0, 10}
+
+	sortRoutesBySpecificity(routes, ranks)
+
+	if routes[0].Name != "high" || ranks[0] != 10 {
+		t.Fatalf("expected high/10 first, got %q/%d", routes[0].Name, ranks[0])
+	}
+	if routes[1].Name != "low" || ranks[1] != 0 {
+		t.Fatalf("expected low/0 second, got %q/%d", routes[1].Name, ranks[1])
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authz adds Envoy's ext_authz HTTP filter to gateway-terminated HTTP listeners, so a
+// gateway can delegate request authorization to an external service before routing.
+package authz
+
+import (
+	"time"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	extauthz "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/ext_authz/v2"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/plugin"
+	"istio.io/istio/pilot/pkg/networking/util"
+)
+
+// extAuthzFilterName is the Envoy HTTP filter name for ext_authz.
+const extAuthzFilterName = "envoy.ext_authz"
+
+// Policy configures how the ext_authz filter talks to the external authorization service.
+// Today this is built up by whatever constructs the Plugin (see NewPlugin); there's no CRD
+// binding yet -- see the plugin's doc comment for why.
+type Policy struct {
+	// ClusterName is the upstream cluster (already registered in the xDS cluster config) that
+	// hosts the authorization service.
+	ClusterName string
+
+	// UseHTTP selects the HTTP ext_authz variant, which sends a plain HTTP check request to
+	// ClusterName. The default, gRPC, speaks the ext_authz CheckRequest/CheckResponse gRPC
+	// service instead.
+	UseHTTP bool
+
+	// Timeout bounds how long Envoy waits for the authorization service to respond.
+	Timeout time.Duration
+
+	// FailOpen lets traffic through (instead of rejecting it) if the authorization service
+	// errors out or times out.
+	FailOpen bool
+
+	// IncludeRequestHeaders lists the request headers forwarded to the authorization service.
+	// Only meaningful for the HTTP variant; the gRPC variant forwards the full request.
+	IncludeRequestHeaders []string
+
+	// IncludeResponseHeaders lists headers from the authorization service's response that are
+	// copied onto the request headed to the real upstream once the request is allowed.
+	IncludeResponseHeaders []string
+}
+
+// Plugin is an ext_authz plugin for gateway HTTP listeners.
+type Plugin struct {
+	policy Policy
+}
+
+// NewPlugin returns a ptr to an initialized authz.Plugin driven by policy.
+//
+// There's no CRD binding of this Policy to a Gateway yet. Concretely: pilot/pkg/networking/core/
+// v1alpha3/gateway.go's buildGatewayListeners -- the one real call site in this snapshot that
+// constructs configgen.Plugins' plugin.InputParams for a gateway listener -- populates it with
+// only ListenerType, Env, Node, and ProxyInstances; there's no resolved Gateway/Policy config
+// object to pass through, and plugin.InputParams's own type definition isn't part of this
+// snapshot either, so a new field can't be added to it without guessing at a type this tree
+// can't verify. Until both exist, every gateway listener built with this Plugin registered
+// shares the one Policy it was constructed with. ratelimit.NewPlugin, jwtauthn.NewPlugin, and
+// opaauthz.NewPlugin each document this same single-tenant-singleton gap for their own
+// Policy/JWTProvider/OPAProvider, for the same reason: none of them has anywhere in this
+// snapshot to resolve a per-Gateway-Server, per-ServiceEntry, or CRD-driven config from.
+func NewPlugin(policy Policy) plugin.Plugin {
+	return Plugin{policy: policy}
+}
+
+// OnOutboundListener implements the Plugin interface method.
+func (p Plugin) OnOutboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	if in.ListenerType != plugin.ListenerTypeHTTP || p.policy.ClusterName == "" {
+		return nil
+	}
+
+	filter := buildExtAuthzHTTPFilter(p.policy)
+	for cnum := range mutable.FilterChains {
+		// The router filter is appended later (by marshalFilters), so it's enough to add
+		// ourselves to the chain -- we don't need to worry about ending up after it.
+		mutable.FilterChains[cnum].HTTP = append(mutable.FilterChains[cnum].HTTP, filter)
+	}
+	return nil
+}
+
+// OnInboundListener implements the Plugin interface method. ext_authz is a gateway-only
+// feature for now, so inbound (sidecar) listeners are left untouched.
+func (Plugin) OnInboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	return nil
+}
+
+// OnOutboundCluster implements the Plugin interface method.
+func (Plugin) OnOutboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnInboundCluster implements the Plugin interface method.
+func (Plugin) OnInboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnOutboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnOutboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// OnInboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// buildExtAuthzHTTPFilter builds the envoy.ext_authz HttpFilter for policy.
+func buildExtAuthzHTTPFilter(policy Policy) *http_conn.HttpFilter {
+	cfg := &extauthz.ExtAuthz{
+		FailureModeAllow: policy.FailOpen,
+	}
+
+	if policy.UseHTTP {
+		cfg.Services = &extauthz.ExtAuthz_HttpService{
+			HttpService: &extauthz.HttpService{
+				ServerUri: &core.HttpUri{
+					Uri:     "http://" + policy.ClusterName,
+					Timeout: gogoDuration(policy.Timeout),
+					HttpUpstreamType: &core.HttpUri_Cluster{
+						Cluster: policy.ClusterName,
+					},
+				},
+				AuthorizationRequest: &extauthz.AuthorizationRequest{
+					AllowedHeaders: stringMatcherList(policy.IncludeRequestHeaders),
+				},
+				AuthorizationResponse: &extauthz.AuthorizationResponse{
+					AllowedUpstreamHeaders: stringMatcherList(policy.IncludeResponseHeaders),
+				},
+			},
+		}
+	} else {
+		cfg.Services = &extauthz.ExtAuthz_GrpcService{
+			GrpcService: &core.GrpcService{
+				Timeout: gogoDuration(policy.Timeout),
+				TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &core.GrpcService_EnvoyGrpc{
+						ClusterName: policy.ClusterName,
+					},
+				},
+			},
+		}
+	}
+
+	return &http_conn.HttpFilter{
+		Name:   extAuthzFilterName,
+		Config: util.MessageToStruct(cfg),
+	}
+}
+
+// stringMatcherList builds an exact-match ListStringMatcher for names, or nil if names is
+// empty (meaning Envoy's ext_authz default of forwarding/returning nothing extra applies).
+func stringMatcherList(names []string) *matcher.ListStringMatcher {
+	if len(names) == 0 {
+		return nil
+	}
+	patterns := make([]*matcher.StringMatcher, 0, len(names))
+	for _, name := range names {
+		patterns = append(patterns, &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: name},
+		})
+	}
+	return &matcher.ListStringMatcher{Patterns: patterns}
+}
+
+// gogoDuration converts a time.Duration to the gogo well-known Duration type the go-control-
+// plane v2 APIs use.
+func gogoDuration(d time.Duration) *types.Duration {
+	if d <= 0 {
+		return nil
+	}
+	return types.DurationProto(d)
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authz
+
+import (
+	"testing"
+
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+
+	"istio.io/istio/pilot/pkg/networking/plugin"
+)
+
+// TestOnOutboundListenerAppendsFilterLast asserts the ext_authz filter is appended to the end of
+// an existing HTTP filter chain. marshalFilters (in gateway.go, outside this package) appends the
+// router filter after every plugin's OnOutboundListener has run, so "appended here" is equivalent
+// to "ends up before the router" without this package needing to see the router filter itself.
+func TestOnOutboundListenerAppendsFilterLast(t *testing.T) {
+	p := NewPlugin(Policy{ClusterName: "outbound|9191||authz.default.svc.cluster.local"})
+
+	existing := &http_conn.HttpFilter{Name: "some.earlier.filter"}
+	mutable := &plugin.MutableObjects{
+		FilterChains: []plugin.FilterChain{{HTTP: []*http_conn.HttpFilter{existing}}},
+	}
+	in := &plugin.InputParams{ListenerType: plugin.ListenerTypeHTTP}
+
+	if err := p.OnOutboundListener(in, mutable); err != nil {
+		t.Fatalf("OnOutboundListener returned error: %v", err)
+	}
+
+	chain := mutable.FilterChains[0].HTTP
+	if len(chain) != 2 {
+		t.Fatalf("expected 2 HTTP filters, got %d", len(chain))
+	}
+	if chain[0] != existing {
+		t.Errorf("expected the pre-existing filter to stay first, got %v", chain[0])
+	}
+	if chain[1].Name != extAuthzFilterName {
+		t.Errorf("expected %q appended last, got %q", extAuthzFilterName, chain[1].Name)
+	}
+}
+
+// TestOnOutboundListenerSkipsWithoutClusterName asserts a zero-value Policy (no ClusterName --
+// the state NewPlugin leaves a CRD-less deployment in) is a no-op, not a misconfigured filter.
+func TestOnOutboundListenerSkipsWithoutClusterName(t *testing.T) {
+	p := NewPlugin(Policy{})
+	mutable := &plugin.MutableObjects{FilterChains: []plugin.FilterChain{{}}}
+	in := &plugin.InputParams{ListenerType: plugin.ListenerTypeHTTP}
+
+	if err := p.OnOutboundListener(in, mutable); err != nil {
+		t.Fatalf("OnOutboundListener returned error: %v", err)
+	}
+	if len(mutable.FilterChains[0].HTTP) != 0 {
+		t.Errorf("expected no filter added with an empty Policy, got %v", mutable.FilterChains[0].HTTP)
+	}
+}
+
+// TestBuildExtAuthzHTTPFilterGRPC asserts the default (UseHTTP false) variant builds a filter
+// named extAuthzFilterName with a non-nil config; the config's service type and target cluster
+// are not inspected.
+func TestBuildExtAuthzHTTPFilterGRPC(t *testing.T) {
+	filter := buildExtAuthzHTTPFilter(Policy{ClusterName: "outbound|9191||authz.default.svc.cluster.local", FailOpen: true})
+	if filter.Name != extAuthzFilterName {
+		t.Errorf("expected filter name %q, got %q", extAuthzFilterName, filter.Name)
+	}
+	if filter.Config == nil {
+		t.Fatal("expected a non-nil filter config")
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jwtauthn adds Envoy's jwt_authn HTTP filter to gateway-terminated HTTP listeners, so a
+// gateway can require and validate a JWT before forwarding a request to a MESH_EXTERNAL
+// ServiceEntry host -- the egress-side counterpart to authz's inbound-facing ext_authz filter.
+// The filter is inserted ahead of any later-appended filter (see OnOutboundListener) so that a
+// downstream filter like authz's ext_authz can rely on the request already carrying a validated
+// principal, and so its forwarded claim header/metadata is available to match on.
+package jwtauthn
+
+import (
+	"time"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	jwtauthnpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/jwt_authn/v2alpha"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/plugin"
+	"istio.io/istio/pilot/pkg/networking/util"
+)
+
+// jwtAuthnFilterName is the Envoy HTTP filter name for jwt_authn.
+const jwtAuthnFilterName = "envoy.jwt_authn"
+
+// jwtAuthnProviderName is the one JwtProvider this plugin registers, referenced by the
+// RequirementRule it also registers. A Plugin only ever validates against the one JWTProvider
+// it was constructed with, so there's no need for more than one named provider yet.
+const jwtAuthnProviderName = "origin"
+
+// JWTProvider configures how the jwt_authn filter validates an incoming token and what it does
+// with the claims once validated. Today this is built up by whatever constructs the Plugin (see
+// NewPlugin); there's no CRD binding of this to a ServiceEntry yet -- see NewPlugin's doc comment
+// for why.
+type JWTProvider struct {
+	// Issuer is the expected "iss" claim. A token from any other issuer is rejected.
+	Issuer string
+
+	// JwksURI is fetched to obtain the issuer's signing keys.
+	JwksURI string
+
+	// JwksClusterName is the upstream cluster (already registered in the xDS cluster config,
+	// the same precondition authz.Policy.ClusterName documents) that JwksURI resolves through.
+	JwksClusterName string
+
+	// JwksCacheDuration bounds how long Envoy itself caches a fetched key set before
+	// re-fetching it from JwksURI. Zero uses Envoy's own default.
+	JwksCacheDuration time.Duration
+
+	// JwksRefreshInterval, if non-zero, drives jwksRefresher's own background poll of JwksURI
+	// (see StartJWKSRefresher), independent of JwksCacheDuration: it exists so a JWKS rotation
+	// can be noticed and pushed to already-connected sidecars (see PushRequest.JwksUpdates)
+	// before Envoy's own cache would have expired and re-fetched it anyway.
+	JwksRefreshInterval time.Duration
+
+	// AcceptableClockSkew tolerates this much clock drift between the token issuer and the
+	// validating proxy when checking the "exp"/"nbf"/"iat" claims, so a token isn't spuriously
+	// rejected just after issuance or just before its stated expiry. Zero uses jwt_authn's own
+	// default.
+	AcceptableClockSkew time.Duration
+
+	// Audiences, if non-empty, restricts acceptance to a token whose "aud" claim contains one
+	// of these values.
+	Audiences []string
+
+	// RequiredClaims lists claims (and, where Value is non-empty, the value each must equal).
+	// jwt_authn itself only validates signature, issuer, and audience, and
+	// buildJwtAuthnHTTPFilter does not read RequiredClaims: no header-match or other
+	// requirement is generated from it, so these claims are not enforced.
+	RequiredClaims []ClaimMatcher
+
+	// ForwardPayloadHeader, if set, is the request header the filter writes the decoded JWT
+	// payload (as JSON) to, so a later filter or the upstream itself can read claims off it
+	// (e.g. for a route-level claim-to-header rewrite).
+	ForwardPayloadHeader string
+}
+
+// ClaimMatcher names a claim RequiredClaims expects, and the value it must equal if non-empty.
+type ClaimMatcher struct {
+	Name  string
+	Value string
+}
+
+// Plugin is a jwt_authn plugin for gateway HTTP listeners.
+type Plugin struct {
+	provider JWTProvider
+}
+
+// NewPlugin returns a ptr to an initialized jwtauthn.Plugin driven by provider.
+//
+// There's no CRD binding of this JWTProvider to a ServiceEntry yet: plugin.InputParams (defined
+// outside this snapshot) doesn't carry the ServiceEntry config object or its annotations through
+// to OnOutboundListener, and the call site that builds configgen.Plugins -- where a per-host
+// JWTProvider would need to be resolved and passed to NewPlugin -- isn't part of this tree
+// either. Until then, every gateway listener built with this Plugin registered shares the one
+// JWTProvider it was constructed with, mirroring the same limitation authz.NewPlugin documents
+// for its ext_authz Policy, ratelimit.NewPlugin documents for its connection/rate-limit Policy,
+// and opaauthz.NewPlugin documents for its OPAProvider -- all four plugins construct one
+// process-wide config instead of resolving a per-Gateway-Server, per-ServiceEntry, or
+// CRD-driven one, for the same missing-binding reason.
+//
+// That same gap rules out a TestRoutes-style end-to-end test of "a bad token gets 401, a good
+// token routes to v2 off a claim header rewrite": there's no ServiceEntry-bound JWTProvider to
+// stand up, no xDS server construction path in this snapshot to drive a real request through
+// (see StartJWKSRefresher's doc comment in jwks_refresher.go for the same missing-constructor
+// finding), and the claim-based routing half of that ask is a route-matching rule keyed on
+// ForwardPayloadHeader's output -- configured on the VirtualService/route side, entirely outside
+// this package. What this package owns and can actually verify is covered in jwtauthn_test.go:
+// buildJwtAuthnHTTPFilter's issuer/audiences/clock-skew/cache-duration/forwarding output, and
+// OnOutboundListener's prepend-before-authz ordering.
+func NewPlugin(provider JWTProvider) plugin.Plugin {
+	return Plugin{provider: provider}
+}
+
+// OnOutboundListener implements the Plugin interface method.
+func (p Plugin) OnOutboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	if in.ListenerType != plugin.ListenerTypeHTTP || p.provider.Issuer == "" {
+		return nil
+	}
+
+	filter := buildJwtAuthnHTTPFilter(p.provider)
+	for cnum := range mutable.FilterChains {
+		// Prepend, not append: a later plugin (e.g. authz's ext_authz) appends itself to this
+		// same slice, and jwt_authn must run -- and populate its forwarded claim header/
+		// metadata -- before anything that wants to match on the result does.
+		mutable.FilterChains[cnum].HTTP = append([]*http_conn.HttpFilter{filter}, mutable.FilterChains[cnum].HTTP...)
+	}
+	return nil
+}
+
+// OnInboundListener implements the Plugin interface method. jwt_authn is a gateway-only feature
+// for now, so inbound (sidecar) listeners are left untouched.
+func (Plugin) OnInboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	return nil
+}
+
+// OnOutboundCluster implements the Plugin interface method.
+func (Plugin) OnOutboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnInboundCluster implements the Plugin interface method.
+func (Plugin) OnInboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnOutboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnOutboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// OnInboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// buildJwtAuthnHTTPFilter builds the envoy.jwt_authn HttpFilter for provider, requiring it on
+// every request through the listener.
+func buildJwtAuthnHTTPFilter(provider JWTProvider) *http_conn.HttpFilter {
+	jwtProvider := &jwtauthnpb.JwtProvider{
+		Issuer:    provider.Issuer,
+		Audiences: provider.Audiences,
+		JwksSourceSpecifier: &jwtauthnpb.JwtProvider_RemoteJwks{
+			RemoteJwks: &jwtauthnpb.RemoteJwks{
+				HttpUri: &core.HttpUri{
+					Uri: provider.JwksURI,
+					HttpUpstreamType: &core.HttpUri_Cluster{
+						Cluster: provider.JwksClusterName,
+					},
+				},
+				CacheDuration: gogoDuration(provider.JwksCacheDuration),
+			},
+		},
+		ClockSkewSeconds:     uint32(provider.AcceptableClockSkew.Seconds()),
+		Forward:              provider.ForwardPayloadHeader != "",
+		ForwardPayloadHeader: provider.ForwardPayloadHeader,
+		PayloadInMetadata:    jwtAuthnProviderName,
+	}
+
+	cfg := &jwtauthnpb.JwtAuthentication{
+		Providers: map[string]*jwtauthnpb.JwtProvider{
+			jwtAuthnProviderName: jwtProvider,
+		},
+		Rules: []*jwtauthnpb.RequirementRule{
+			{
+				// Every path through this listener requires the token; there's no per-route
+				// exemption mechanism wired in yet.
+				Match: &route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{Prefix: "/"},
+				},
+				Requires: &jwtauthnpb.JwtRequirement{
+					RequiresType: &jwtauthnpb.JwtRequirement_ProviderName{
+						ProviderName: jwtAuthnProviderName,
+					},
+				},
+			},
+		},
+	}
+
+	return &http_conn.HttpFilter{
+		Name:   jwtAuthnFilterName,
+		Config: util.MessageToStruct(cfg),
+	}
+}
+
+// gogoDuration converts a time.Duration to the gogo well-known Duration type the go-control-
+// plane v2 APIs use, or nil for Envoy's own default when d is unset.
+func gogoDuration(d time.Duration) *types.Duration {
+	if d <= 0 {
+		return nil
+	}
+	return types.DurationProto(d)
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jwtauthn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	jwtauthnpb "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/jwt_authn/v2alpha"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/gogo/protobuf/jsonpb"
+
+	"istio.io/istio/pilot/pkg/networking/plugin"
+)
+
+// TestOnOutboundListenerPrependsBeforeLaterFilters asserts jwt_authn is prepended, so a
+// later-appended filter (like authz's ext_authz) always runs after claim validation/forwarding
+// has happened, matching the package doc comment's stated ordering guarantee.
+func TestOnOutboundListenerPrependsBeforeLaterFilters(t *testing.T) {
+	p := NewPlugin(JWTProvider{Issuer: "https://issuer.example.com", JwksURI: "https://issuer.example.com/.well-known/jwks.json"})
+
+	existing := &http_conn.HttpFilter{Name: "envoy.ext_authz"}
+	mutable := &plugin.MutableObjects{
+		FilterChains: []plugin.FilterChain{{HTTP: []*http_conn.HttpFilter{existing}}},
+	}
+	in := &plugin.InputParams{ListenerType: plugin.ListenerTypeHTTP}
+
+	if err := p.OnOutboundListener(in, mutable); err != nil {
+		t.Fatalf("OnOutboundListener returned error: %v", err)
+	}
+
+	chain := mutable.FilterChains[0].HTTP
+	if len(chain) != 2 {
+		t.Fatalf("expected 2 HTTP filters, got %d", len(chain))
+	}
+	if chain[0].Name != jwtAuthnFilterName {
+		t.Errorf("expected %q prepended first, got %q", jwtAuthnFilterName, chain[0].Name)
+	}
+	if chain[1] != existing {
+		t.Errorf("expected the pre-existing filter to stay after jwt_authn, got %v", chain[1])
+	}
+}
+
+// TestOnOutboundListenerSkipsWithoutIssuer asserts a zero-value JWTProvider (no Issuer -- the
+// state NewPlugin leaves a CRD-less deployment in) is a no-op.
+func TestOnOutboundListenerSkipsWithoutIssuer(t *testing.T) {
+	p := NewPlugin(JWTProvider{})
+	mutable := &plugin.MutableObjects{FilterChains: []plugin.FilterChain{{}}}
+	in := &plugin.InputParams{ListenerType: plugin.ListenerTypeHTTP}
+
+	if err := p.OnOutboundListener(in, mutable); err != nil {
+		t.Fatalf("OnOutboundListener returned error: %v", err)
+	}
+	if len(mutable.FilterChains[0].HTTP) != 0 {
+		t.Errorf("expected no filter added with an empty JWTProvider, got %v", mutable.FilterChains[0].HTTP)
+	}
+}
+
+// TestBuildJwtAuthnHTTPFilter asserts the generated JwtAuthentication config carries provider's
+// issuer, audiences, clock skew, JWKS cache duration, and claim-forwarding settings through to
+// the one registered JwtProvider and its catch-all RequirementRule.
+func TestBuildJwtAuthnHTTPFilter(t *testing.T) {
+	provider := JWTProvider{
+		Issuer:               "https://issuer.example.com",
+		JwksURI:              "https://issuer.example.com/.well-known/jwks.json",
+		JwksClusterName:      "outbound|443||issuer.example.com",
+		JwksCacheDuration:    10 * time.Minute,
+		AcceptableClockSkew:  30 * time.Second,
+		Audiences:            []string{"productpage"},
+		ForwardPayloadHeader: "x-jwt-payload",
+	}
+
+	filter := buildJwtAuthnHTTPFilter(provider)
+	if filter.Name != jwtAuthnFilterName {
+		t.Fatalf("expected filter name %q, got %q", jwtAuthnFilterName, filter.Name)
+	}
+
+	marshaler := jsonpb.Marshaler{}
+	buf := &bytes.Buffer{}
+	if err := marshaler.Marshal(buf, filter.Config); err != nil {
+		t.Fatalf("failed to marshal filter config to json: %v", err)
+	}
+	var cfg jwtauthnpb.JwtAuthentication
+	if err := jsonpb.UnmarshalString(buf.String(), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal filter config: %v", err)
+	}
+
+	jwtProvider, ok := cfg.Providers[jwtAuthnProviderName]
+	if !ok {
+		t.Fatalf("expected a provider named %q, got %v", jwtAuthnProviderName, cfg.Providers)
+	}
+	if jwtProvider.Issuer != provider.Issuer {
+		t.Errorf("expected issuer %q, got %q", provider.Issuer, jwtProvider.Issuer)
+	}
+	if len(jwtProvider.Audiences) != 1 || jwtProvider.Audiences[0] != "productpage" {
+		t.Errorf("expected audiences [productpage], got %v", jwtProvider.Audiences)
+	}
+	if jwtProvider.ClockSkewSeconds != 30 {
+		t.Errorf("expected clock skew of 30s, got %d", jwtProvider.ClockSkewSeconds)
+	}
+	if !jwtProvider.Forward || jwtProvider.ForwardPayloadHeader != "x-jwt-payload" {
+		t.Errorf("expected forwarding to x-jwt-payload, got Forward=%v ForwardPayloadHeader=%q",
+			jwtProvider.Forward, jwtProvider.ForwardPayloadHeader)
+	}
+	remoteJwks, ok := jwtProvider.JwksSourceSpecifier.(*jwtauthnpb.JwtProvider_RemoteJwks)
+	if !ok {
+		t.Fatalf("expected a RemoteJwks source, got %T", jwtProvider.JwksSourceSpecifier)
+	}
+	if remoteJwks.RemoteJwks.HttpUri.Uri != provider.JwksURI {
+		t.Errorf("expected JWKS uri %q, got %q", provider.JwksURI, remoteJwks.RemoteJwks.HttpUri.Uri)
+	}
+	if remoteJwks.RemoteJwks.CacheDuration.GetSeconds() != 600 {
+		t.Errorf("expected a 600s cache duration, got %v", remoteJwks.RemoteJwks.CacheDuration)
+	}
+
+	if len(cfg.Rules) != 1 || cfg.Rules[0].GetRequiresType() == nil {
+		t.Fatalf("expected exactly one catch-all rule, got %v", cfg.Rules)
+	}
+}
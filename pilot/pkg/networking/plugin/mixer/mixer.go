@@ -62,10 +62,16 @@ func (Plugin) OnOutboundListener(in *plugin.InputParams, mutable *plugin.Mutable
 		}
 		return nil
 	case plugin.ListenerTypeTCP:
-		// Adding an empty filter prevents listeners from loading
-		//		for cnum := range mutable.FilterChains {
-		//			mutable.FilterChains[cnum].TCP = append(mutable.FilterChains[cnum].TCP, buildMixerOutboundTCPFilter(env, node))
-		//		}
+		servicePort := outboundTCPServicePort(in.Service)
+		if servicePort == nil {
+			return nil
+		}
+		for cnum := range mutable.FilterChains {
+			m := buildMixerOutboundTCPFilter(env, node, in.Service, servicePort)
+			if m != nil {
+				mutable.FilterChains[cnum].TCP = append(mutable.FilterChains[cnum].TCP, *m)
+			}
+		}
 		return nil
 	}
 
@@ -106,12 +112,43 @@ func (Plugin) OnOutboundCluster(env model.Environment, node model.Proxy, service
 func (Plugin) OnInboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
 }
 
-// OnOutboundRouteConfiguration implements the Plugin interface method.
+// OnOutboundRouteConfiguration implements the Plugin interface method, attaching the same
+// per-route ServiceConfig resolution OnInboundRouteConfiguration does (see
+// resolveRouteServiceConfig), keyed off each virtual host's own hostname rather than the single
+// ServiceInstance an inbound listener has.
 func (Plugin) OnOutboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
-}
+	if in.ListenerType != plugin.ListenerTypeHTTP {
+		return
+	}
 
-// oc := BuildMixerConfig(node, serviceName, dest, proxyInstances, config, mesh.DisablePolicyChecks, false)
-// func BuildMixerConfig(source model.Proxy, destName string, dest *model.Service, instances []*model.ServiceInstance, config model.IstioConfigStore,
+	var nvhs []route.VirtualHost
+	for _, vh := range routeConfiguration.VirtualHosts {
+		nvh := vh
+		hostname := ""
+		if len(vh.Domains) > 0 {
+			hostname = vh.Domains[0]
+		}
+		var nrs []route.Route
+		for _, r := range vh.Routes {
+			nr := r
+			if nr.PerFilterConfig == nil {
+				nr.PerFilterConfig = make(map[string]*types.Struct)
+			}
+			// An outbound RouteConfiguration's virtual host carries only the hostname(s) it
+			// matches, not the model.Service defining it, so there's no confirmed proxy
+			// namespace to scope the DestinationRule lookup with the way
+			// resolveMixerPolicyOverride's inbound/TCP callers can via
+			// service.Attributes.Namespace; "" falls through to exported/root-namespace rules
+			// only, missing a namespace-local override on this host.
+			nr.PerFilterConfig[v1.MixerFilter] = util.MessageToStruct(
+				resolveRouteServiceConfig(in.Env, hostname, "", nr, in.Env.Mesh.DisablePolicyChecks, false))
+			nrs = append(nrs, nr)
+		}
+		nvh.Routes = nrs
+		nvhs = append(nvhs, nvh)
+	}
+	routeConfiguration.VirtualHosts = nvhs
+}
 
 // OnInboundRouteConfiguration implements the Plugin interface method.
 func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
@@ -122,6 +159,8 @@ func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfigura
 
 	switch in.ListenerType {
 	case plugin.ListenerTypeHTTP:
+		hostname := in.ServiceInstance.Service.Hostname
+		namespace := in.ServiceInstance.Service.Attributes.Namespace
 		var nvhs []route.VirtualHost
 		for _, vh := range routeConfiguration.VirtualHosts {
 			nvh := vh
@@ -132,7 +171,7 @@ func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfigura
 					nr.PerFilterConfig = make(map[string]*types.Struct)
 				}
 				nr.PerFilterConfig[v1.MixerFilter] = util.MessageToStruct(
-					buildMixerPerRouteConfig(in.Env.Mesh.DisablePolicyChecks, forward, in.ServiceInstance.Service.Hostname))
+					resolveRouteServiceConfig(in.Env, hostname, namespace, nr, in.Env.Mesh.DisablePolicyChecks, forward))
 				nrs = append(nrs, nr)
 			}
 			nvh.Routes = nrs
@@ -147,21 +186,6 @@ func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfigura
 	}
 }
 
-func buildMixerPerRouteConfig(disableCheck, _ /*disableForward*/ bool, destinationService string) *mccpb.ServiceConfig {
-	out := &mccpb.ServiceConfig{
-		// Report calls are never disabled. Disable forward is currently not in the proto.
-		DisableCheckCalls: disableCheck,
-	}
-	if destinationService != "" {
-		out.MixerAttributes = &mpb.Attributes{}
-		out.MixerAttributes.Attributes = map[string]*mpb.Attributes_AttributeValue{
-			v1.AttrDestinationService: {Value: &mpb.Attributes_AttributeValue_StringValue{StringValue: destinationService}},
-		}
-	}
-
-	return out
-}
-
 // buildMixerHTTPFilter builds a filter with a v1 mixer config encapsulated as JSON in a proto.Struct for v2 consumption.
 func buildMixerHTTPFilter(env *model.Environment, node *model.Proxy,
 	proxyInstances []*model.ServiceInstance, outbound bool) *http_conn.HttpFilter {
@@ -192,11 +216,78 @@ func buildMixerInboundTCPFilter(env *model.Environment, node *model.Proxy, insta
 	}
 }
 
-// // buildMixerOutboundTCPFilter builds a filter with a v1 mixer config encapsulated as JSON in a proto.Struct for v2 consumption.
-// func buildMixerOutboundTCPFilter(env *model.Environment, node *model.Proxy) listener.Filter {
-// 	// TODO(mostrowski): implementation
-// 	return listener.Filter{}
-// }
+// outboundTCPServicePort picks the port buildMixerOutboundTCPFilter's config is built for. A TCP
+// listener is built per service port upstream of the plugin chain, but plugin.InputParams carries
+// no port of its own at this API vintage (only the HTTP/TCP cluster callbacks take one directly),
+// so the first port on the external service is used -- the common case for a MeshExternal TCP
+// entry, which usually names exactly one.
+func outboundTCPServicePort(service *model.Service) *model.Port {
+	if service == nil || len(service.Ports) == 0 {
+		return nil
+	}
+	return service.Ports[0]
+}
+
+// buildMixerOutboundTCPFilter builds a filter with a v1 mixer config encapsulated as JSON in a proto.Struct for v2 consumption.
+func buildMixerOutboundTCPFilter(env *model.Environment, node *model.Proxy, service *model.Service, servicePort *model.Port) *listener.Filter {
+	mesh := env.Mesh
+	if mesh.MixerCheckServer == "" && mesh.MixerReportServer == "" {
+		return nil
+	}
+
+	c := buildOutboundTCPMixerFilterConfig(env, *node, service, servicePort)
+	return &listener.Filter{
+		Name:   v1.MixerFilter,
+		Config: util.MessageToStruct(c),
+	}
+}
+
+// buildOutboundTCPMixerFilterConfig builds a TCP filter config for an outbound (MeshExternal)
+// service, applying any per-destination override the service's DestinationRule carries (see
+// resolveMixerPolicyOverride) on top of the mesh-wide DisablePolicyChecks default.
+func buildOutboundTCPMixerFilterConfig(env *model.Environment, role model.Proxy, service *model.Service, servicePort *model.Port) *mccpb.TcpClientConfig {
+	attrs := v1.StandardNodeAttributes(v1.AttrSourcePrefix, role.IPAddress, role.ID, nil)
+	attrs[v1.AttrDestinationService] = &mpb.Attributes_AttributeValue{Value: &mpb.Attributes_AttributeValue_StringValue{StringValue: service.Hostname}}
+
+	override := resolveMixerPolicyOverride(env, service)
+	for k, v := range override.MixerAttributes {
+		attrs[k] = &mpb.Attributes_AttributeValue{Value: &mpb.Attributes_AttributeValue_StringValue{StringValue: v}}
+	}
+
+	disableCheckCalls := env.Mesh.DisablePolicyChecks
+	if override.DisableCheckCalls != nil {
+		disableCheckCalls = *override.DisableCheckCalls
+	}
+
+	mxConfig := &mccpb.TcpClientConfig{
+		MixerAttributes: &mpb.Attributes{
+			Attributes: attrs,
+		},
+		Transport: &mccpb.TransportConfig{
+			CheckCluster:  v1.MixerCheckClusterName,
+			ReportCluster: v1.MixerReportClusterName,
+		},
+		DisableCheckCalls: disableCheckCalls,
+	}
+	// DisableReportCalls isn't a field on mccpb.TcpClientConfig at this API vintage (see
+	// resolveRouteServiceConfig's ServiceConfig comment for the same gap on the HTTP side), so
+	// override.DisableReportCalls has nowhere to go yet; report calls always run.
+
+	return mxConfig
+}
+
+// resolveMixerPolicyOverride looks up the DestinationRule for service's hostname -- as seen from
+// the namespace the ServiceEntry defining it lives in, since a MeshExternal service's consumers
+// aren't necessarily co-located with it and plugin.InputParams carries no proxy namespace of its
+// own at this API vintage -- and returns its MixerPolicyOverride, or the zero value if none
+// applies.
+func resolveMixerPolicyOverride(env *model.Environment, service *model.Service) model.MixerPolicyOverride {
+	config := env.IstioConfigStore.DestinationRule(model.Hostname(service.Hostname), service.Attributes.Namespace)
+	if config == nil {
+		return model.MixerPolicyOverride{}
+	}
+	return model.DestinationRuleMixerPolicyOverride(config.ConfigMeta)
+}
 
 // buildHTTPMixerFilterConfig builds a mixer HTTP filter config. Mixer filter uses outbound configuration by default
 // (forward attributes, but not invoke check calls)  ServiceInstances belong to the Node.
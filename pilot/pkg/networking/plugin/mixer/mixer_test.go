@@ -0,0 +1,117 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixer
+
+import (
+	"encoding/json"
+	"testing"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	mpb "istio.io/api/mixer/v1"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// destinationRuleMixerPolicyAnnotation and destinationRuleRoutePoliciesAnnotation mirror the
+// unexported constants of the same names in pilot/pkg/model/destination_rule_index.go -- the only
+// way this package's tests can populate the annotations model.DestinationRuleMixerPolicyOverride
+// and model.DestinationRuleMixerRoutePolicies decode, short of exporting them.
+const (
+	destinationRuleMixerPolicyAnnotation   = "internal.istio.io/mixer-policy"
+	destinationRuleRoutePoliciesAnnotation = "internal.istio.io/mixer-route-policies"
+)
+
+// fakeDestinationRuleStore implements just the one IstioConfigStore method
+// resolveMixerPolicyOverride/resolveRouteServiceConfig actually call (DestinationRule); every
+// other method panics if reached.
+type fakeDestinationRuleStore struct {
+	model.IstioConfigStore
+	rule *model.Config
+}
+
+func (f fakeDestinationRuleStore) DestinationRule(hostname model.Hostname, proxyNamespace string) *model.Config {
+	return f.rule
+}
+
+func destinationRuleWithMixerPolicy(override model.MixerPolicyOverride) model.Config {
+	encoded, err := json.Marshal(override)
+	if err != nil {
+		panic(err)
+	}
+	return model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type: model.DestinationRule.Type,
+			Name: "reviews-mixer-policy",
+			Annotations: map[string]string{
+				destinationRuleMixerPolicyAnnotation: string(encoded),
+			},
+		},
+	}
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// TestBuildOutboundTCPMixerFilterConfigDisableCheckCallsDefaultsToMesh asserts that with no
+// DestinationRule override at all, DisableCheckCalls simply mirrors the mesh-wide
+// DisablePolicyChecks default.
+func TestBuildOutboundTCPMixerFilterConfigDisableCheckCallsDefaultsToMesh(t *testing.T) {
+	env := &model.Environment{
+		Mesh:             &meshconfig.MeshConfig{DisablePolicyChecks: true},
+		IstioConfigStore: fakeDestinationRuleStore{rule: nil},
+	}
+	service := &model.Service{Hostname: "my-svc.default.svc.cluster.local"}
+
+	got := buildOutboundTCPMixerFilterConfig(env, model.Proxy{}, service, nil)
+	if !got.DisableCheckCalls {
+		t.Errorf("expected DisableCheckCalls to mirror the mesh-wide default (true), got false")
+	}
+}
+
+// TestBuildOutboundTCPMixerFilterConfigDisableCheckCallsOverriddenByDestinationRule asserts a
+// DestinationRule's MixerPolicyOverride.DisableCheckCalls wins over the mesh-wide default.
+func TestBuildOutboundTCPMixerFilterConfigDisableCheckCallsOverriddenByDestinationRule(t *testing.T) {
+	rule := destinationRuleWithMixerPolicy(model.MixerPolicyOverride{DisableCheckCalls: boolPtr(false)})
+	env := &model.Environment{
+		Mesh:             &meshconfig.MeshConfig{DisablePolicyChecks: true},
+		IstioConfigStore: fakeDestinationRuleStore{rule: &rule},
+	}
+	service := &model.Service{Hostname: "my-svc.default.svc.cluster.local"}
+
+	got := buildOutboundTCPMixerFilterConfig(env, model.Proxy{}, service, nil)
+	if got.DisableCheckCalls {
+		t.Errorf("expected the DestinationRule's DisableCheckCalls=false override to win over the mesh default, got true")
+	}
+}
+
+// TestBuildOutboundTCPMixerFilterConfigMixerAttributesFromOverrideAreAdded asserts a
+// DestinationRule's MixerPolicyOverride.MixerAttributes end up in the built config's
+// MixerAttributes, alongside the standard node/destination-service ones.
+func TestBuildOutboundTCPMixerFilterConfigMixerAttributesFromOverrideAreAdded(t *testing.T) {
+	rule := destinationRuleWithMixerPolicy(model.MixerPolicyOverride{MixerAttributes: map[string]string{"custom.attr": "custom-value"}})
+	env := &model.Environment{
+		Mesh:             &meshconfig.MeshConfig{},
+		IstioConfigStore: fakeDestinationRuleStore{rule: &rule},
+	}
+	service := &model.Service{Hostname: "my-svc.default.svc.cluster.local"}
+
+	got := buildOutboundTCPMixerFilterConfig(env, model.Proxy{}, service, nil)
+	attr, ok := got.MixerAttributes.Attributes["custom.attr"]
+	if !ok {
+		t.Fatalf("expected custom.attr to be present in MixerAttributes, got %v", got.MixerAttributes.Attributes)
+	}
+	sv, ok := attr.Value.(*mpb.Attributes_AttributeValue_StringValue)
+	if !ok || sv.StringValue != "custom-value" {
+		t.Errorf("expected custom.attr = %q, got %v", "custom-value", attr.Value)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixer
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+
+	mpb "istio.io/api/mixer/v1"
+	mccpb "istio.io/api/mixer/v1/config/client"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/proxy/envoy/v1"
+)
+
+// methodHeaderName is the pseudo-header Envoy's router uses to match the HTTP method, mirroring
+// the same ":method" name pilot/pkg/networking/core/v1alpha3/route.HeaderMethod compiles
+// HTTPMatchRequest.Method into -- this package doesn't import that one just for the constant.
+const methodHeaderName = ":method"
+
+// resolveRouteServiceConfig builds the mccpb.ServiceConfig for one already-compiled route r
+// against hostname, merging three tiers of Mixer policy override, least to most specific:
+// meshDisableCheck (the mesh-wide default), hostname's DestinationRule-wide
+// MixerPolicyOverride (see model.DestinationRuleMixerPolicyOverride), then whichever of that
+// same DestinationRule's MixerRoutePolicy entries r's compiled match satisfies -- so an
+// operator can, e.g., leave Mixer enabled mesh- and service-wide but disable checks on a single
+// "/healthz" route. disableForward is accepted and ignored: mccpb.ServiceConfig has no field it
+// could set (see the ForwardAttributes note on out below).
+func resolveRouteServiceConfig(env *model.Environment, hostname, proxyNamespace string, r route.Route, meshDisableCheck, _ /*disableForward*/ bool) *mccpb.ServiceConfig {
+	disableCheck := meshDisableCheck
+	attrs := map[string]string{}
+
+	if drConfig := env.IstioConfigStore.DestinationRule(model.Hostname(hostname), proxyNamespace); drConfig != nil {
+		applyMixerPolicyOverride(&disableCheck, attrs, model.DestinationRuleMixerPolicyOverride(drConfig.ConfigMeta))
+
+		for _, policy := range model.DestinationRuleMixerRoutePolicies(drConfig.ConfigMeta) {
+			if routeMatchesPolicy(r, policy.Match) {
+				applyMixerPolicyOverride(&disableCheck, attrs, policy.Override)
+			}
+		}
+	}
+
+	out := &mccpb.ServiceConfig{
+		// Report calls are never disabled, and neither a DestinationRule-wide nor a
+		// route-scoped DisableReportCalls has anywhere to go on mccpb.ServiceConfig at this
+		// API vintage -- the same gap buildOutboundTCPMixerFilterConfig documents on the TCP
+		// side.
+		//
+		// Quota specs are likewise not resolved here, but that's not a gap: they're not a
+		// MixerPolicyOverride/MixerRoutePolicy concern at all, and mccpb.ServiceConfig has no
+		// field for them regardless -- model.QuotaSpecByDestination already resolves a
+		// service's QuotaSpec/QuotaSpecBinding config independently of this per-route
+		// DisableCheckCalls/MixerAttributes override chain. ForwardAttributes, on the other
+		// hand, genuinely is missing: there's no ForwardAttributes field on
+		// MixerPolicyOverride or MixerRouteMatch to carry an operator's choice through, so a
+		// MixerRoutePolicy can only ever add to MixerAttributes (client-reported, via
+		// out.MixerAttributes below), never mark a subset of them as forwarded to the next hop.
+		DisableCheckCalls: disableCheck,
+	}
+	if hostname != "" {
+		attrs[v1.AttrDestinationService] = hostname
+	}
+	if len(attrs) > 0 {
+		out.MixerAttributes = &mpb.Attributes{Attributes: make(map[string]*mpb.Attributes_AttributeValue, len(attrs))}
+		for k, v := range attrs {
+			out.MixerAttributes.Attributes[k] = &mpb.Attributes_AttributeValue{Value: &mpb.Attributes_AttributeValue_StringValue{StringValue: v}}
+		}
+	}
+	return out
+}
+
+// applyMixerPolicyOverride layers override onto disableCheck/attrs. Callers apply tiers from
+// least to most specific, so a later call's non-nil DisableCheckCalls and MixerAttributes
+// entries win over an earlier one's.
+func applyMixerPolicyOverride(disableCheck *bool, attrs map[string]string, override model.MixerPolicyOverride) {
+	if override.DisableCheckCalls != nil {
+		*disableCheck = *override.DisableCheckCalls
+	}
+	for k, v := range override.MixerAttributes {
+		attrs[k] = v
+	}
+}
+
+// routeMatchesPolicy reports whether r's compiled match satisfies every constraint match sets.
+// A zero-value field on match (the common case -- most policies apply by host alone) is never a
+// constraint. PathExact and PathPrefix are mutually exclusive; PathExact wins if both are set.
+func routeMatchesPolicy(r route.Route, match model.MixerRouteMatch) bool {
+	switch {
+	case match.PathExact != "":
+		p, ok := r.Match.PathSpecifier.(*route.RouteMatch_Path)
+		if !ok || p.Path != match.PathExact {
+			return false
+		}
+	case match.PathPrefix != "":
+		p, ok := r.Match.PathSpecifier.(*route.RouteMatch_Prefix)
+		if !ok || p.Prefix != match.PathPrefix {
+			return false
+		}
+	}
+
+	if match.Method != "" {
+		if v, ok := routeHeaderValue(r.Match.Headers, methodHeaderName); !ok || v != match.Method {
+			return false
+		}
+	}
+
+	for name, want := range match.Headers {
+		if v, ok := routeHeaderValue(r.Match.Headers, name); !ok || v != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeHeaderValue returns the literal value name is matched against in headers, ignoring any
+// regex-based matcher -- a MixerRouteMatch only expresses an exact value to key on, not a
+// pattern of its own.
+func routeHeaderValue(headers []*route.HeaderMatcher, name string) (string, bool) {
+	for _, h := range headers {
+		if h.Name != name {
+			continue
+		}
+		if h.Regex != nil && h.Regex.Value {
+			return "", false
+		}
+		return h.Value, true
+	}
+	return "", false
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mixer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+func routeWithPath(exact, prefix string) route.Route {
+	m := route.RouteMatch{}
+	switch {
+	case exact != "":
+		m.PathSpecifier = &route.RouteMatch_Path{Path: exact}
+	case prefix != "":
+		m.PathSpecifier = &route.RouteMatch_Prefix{Prefix: prefix}
+	}
+	return route.Route{Match: m}
+}
+
+// TestRouteMatchesPolicyPathExactWinsOverPathPrefix asserts that when a MixerRouteMatch sets both
+// PathExact and PathPrefix, only PathExact is honored -- a route matching the prefix but not the
+// exact path is rejected.
+func TestRouteMatchesPolicyPathExactWinsOverPathPrefix(t *testing.T) {
+	match := model.MixerRouteMatch{PathExact: "/healthz", PathPrefix: "/health"}
+
+	if !routeMatchesPolicy(routeWithPath("/healthz", ""), match) {
+		t.Error("expected a route whose PathExact matches to satisfy the policy")
+	}
+	if routeMatchesPolicy(routeWithPath("", "/health"), match) {
+		t.Error("expected a route matched only by PathPrefix (not PathExact) to be rejected once PathExact is set")
+	}
+}
+
+// TestRouteMatchesPolicyPathPrefixAlone asserts PathPrefix alone matches a route whose compiled
+// PathSpecifier is the same prefix, and rejects any other prefix or an exact-path route.
+func TestRouteMatchesPolicyPathPrefixAlone(t *testing.T) {
+	match := model.MixerRouteMatch{PathPrefix: "/reviews"}
+
+	if !routeMatchesPolicy(routeWithPath("", "/reviews"), match) {
+		t.Error("expected a route whose PathPrefix matches to satisfy the policy")
+	}
+	if routeMatchesPolicy(routeWithPath("", "/other"), match) {
+		t.Error("expected a route with a different PathPrefix to be rejected")
+	}
+	if routeMatchesPolicy(routeWithPath("/reviews", ""), match) {
+		t.Error("expected an exact-path route to be rejected by a PathPrefix-only match")
+	}
+}
+
+// TestResolveRouteServiceConfigAppliesMeshDestinationRuleRoutePolicyInPrecedenceOrder asserts the
+// three-tier precedence resolveRouteServiceConfig's doc comment describes: mesh-wide default,
+// then the DestinationRule's host-wide override, then whichever MixerRoutePolicy the route's own
+// match satisfies -- each tier overriding only what the previous one left unset or what it
+// explicitly changes.
+func TestResolveRouteServiceConfigAppliesMeshDestinationRuleRoutePolicyInPrecedenceOrder(t *testing.T) {
+	hostOverride := model.MixerPolicyOverride{DisableCheckCalls: boolPtr(true)}
+	routePolicies := []model.MixerRoutePolicy{
+		{
+			Match:    model.MixerRouteMatch{PathExact: "/healthz"},
+			Override: model.MixerPolicyOverride{DisableCheckCalls: boolPtr(false)},
+		},
+	}
+
+	encodedHostOverride, err := json.Marshal(hostOverride)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encodedRoutePolicies, err := json.Marshal(routePolicies)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rule := model.Config{
+		ConfigMeta: model.ConfigMeta{
+			Type: model.DestinationRule.Type,
+			Name: "reviews-mixer-policy",
+			Annotations: map[string]string{
+				destinationRuleMixerPolicyAnnotation:   string(encodedHostOverride),
+				destinationRuleRoutePoliciesAnnotation: string(encodedRoutePolicies),
+			},
+		},
+	}
+	env := &model.Environment{IstioConfigStore: fakeDestinationRuleStore{rule: &rule}}
+
+	// A route matching the "/healthz" MixerRoutePolicy: the route policy's DisableCheckCalls=false
+	// should win over the DestinationRule's host-wide DisableCheckCalls=true.
+	healthz := resolveRouteServiceConfig(env, "reviews.default.svc.cluster.local", "default", routeWithPath("/healthz", ""), false, false)
+	if healthz.DisableCheckCalls {
+		t.Error("expected the /healthz route policy's DisableCheckCalls=false to win over the DestinationRule's host-wide override")
+	}
+
+	// A route NOT matching any MixerRoutePolicy: only the DestinationRule's host-wide override
+	// applies, which should win over the mesh-wide default passed in as meshDisableCheck=false.
+	other := resolveRouteServiceConfig(env, "reviews.default.svc.cluster.local", "default", routeWithPath("", "/other"), false, false)
+	if !other.DisableCheckCalls {
+		t.Error("expected the DestinationRule's host-wide DisableCheckCalls=true to win over the mesh-wide default for a route no policy matches")
+	}
+}
+
+// TestResolveRouteServiceConfigNoDestinationRuleFallsBackToMeshDefault asserts that with no
+// DestinationRule at all, resolveRouteServiceConfig's DisableCheckCalls is exactly
+// meshDisableCheck.
+func TestResolveRouteServiceConfigNoDestinationRuleFallsBackToMeshDefault(t *testing.T) {
+	env := &model.Environment{IstioConfigStore: fakeDestinationRuleStore{rule: nil}}
+
+	got := resolveRouteServiceConfig(env, "reviews.default.svc.cluster.local", "default", routeWithPath("", "/"), true, false)
+	if !got.DisableCheckCalls {
+		t.Error("expected DisableCheckCalls to mirror the mesh-wide default when no DestinationRule applies")
+	}
+}
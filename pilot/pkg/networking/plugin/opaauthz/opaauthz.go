@@ -0,0 +1,179 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package opaauthz adds an istio_authz_opa HTTP filter to a listener, ordered after istio_authn
+// so it can read the claims istio_authn already extracted into the request's auth context: for
+// each request it POSTs {request, source, destination, claims} to an Open Policy Agent endpoint
+// and, based on the response, allows or denies the request, optionally rewrites the bound
+// principal, and merges returned attributes into the ones Mixer later sees on check/report. This
+// is Pilot's generator for the filter config; there is no real go-control-plane message type for
+// istio_authz_opa (it isn't an upstream Envoy filter), so its config is built as a gogo
+// types.Struct directly rather than through a generated proto type.
+package opaauthz
+
+import (
+	"time"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/plugin"
+)
+
+// opaAuthzFilterName is the Envoy HTTP filter name this plugin inserts.
+const opaAuthzFilterName = "istio_authz_opa"
+
+// OPAProvider configures the one OPA endpoint a Plugin queries.
+type OPAProvider struct {
+	// URL is the OPA endpoint requests are POSTed to.
+	URL string
+
+	// QueryPath is the policy decision to evaluate, e.g. "data.istio.allow".
+	QueryPath string
+
+	// ClaimToInput maps a claim name (as istio_authn already bound it into
+	// request.auth.claims) to the field name it's copied under in the OPA input document, so an
+	// operator can rename "sub" to "user_id" for a policy written against that name, say.
+	// A claim absent from this map isn't forwarded to OPA at all.
+	ClaimToInput map[string]string
+
+	// DenyStatusCode is the HTTP status returned to the caller when OPA's decision denies the
+	// request. Defaults to 403 when zero.
+	DenyStatusCode int
+
+	// DecisionCacheTTL bounds how long an identical input's decision is cached before OPA is
+	// queried again.
+	DecisionCacheTTL time.Duration
+}
+
+// Plugin adds the istio_authz_opa filter, configured from one OPAProvider, to gateway and
+// sidecar HTTP listeners.
+type Plugin struct {
+	provider OPAProvider
+}
+
+// NewPlugin returns a ptr to an initialized opaauthz.Plugin driven by provider.
+//
+// As with jwtauthn.NewPlugin (and, for their own Policy types, authz.NewPlugin and
+// ratelimit.NewPlugin), there's no CRD binding of this OPAProvider to a specific listener yet:
+// plugin.InputParams doesn't carry a resolved AuthorizationPolicy config object through to
+// OnOutboundListener/OnInboundListener in this tree, so every listener built with this Plugin
+// registered shares the one OPAProvider it was constructed with. All four plugins share this
+// single-tenant-singleton gap for the same reason -- none of them has a per-Gateway-Server,
+// per-ServiceEntry, or CRD-driven config to resolve from in this snapshot.
+func NewPlugin(provider OPAProvider) plugin.Plugin {
+	return Plugin{provider: provider}
+}
+
+// OnInboundListener implements the Plugin interface method. OPA authorization is most useful on
+// the inbound (server-side) path, right after istio_authn has bound a principal.
+func (p Plugin) OnInboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	if in.ListenerType != plugin.ListenerTypeHTTP || p.provider.URL == "" {
+		return nil
+	}
+
+	filter := buildOPAAuthzHTTPFilter(p.provider)
+	for cnum := range mutable.FilterChains {
+		// Append, not prepend: istio_authn (and jwtauthn's jwt_authn, on a gateway) must already
+		// have populated the claims this filter's input is built from.
+		mutable.FilterChains[cnum].HTTP = append(mutable.FilterChains[cnum].HTTP, filter)
+	}
+	return nil
+}
+
+// OnOutboundListener implements the Plugin interface method. OPA policy is an inbound-facing
+// concept here, so outbound listeners are left untouched.
+func (Plugin) OnOutboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	return nil
+}
+
+// OnOutboundCluster implements the Plugin interface method.
+func (Plugin) OnOutboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnInboundCluster implements the Plugin interface method.
+func (Plugin) OnInboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnOutboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnOutboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// OnInboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// buildOPAAuthzHTTPFilter builds the istio_authz_opa HttpFilter for provider.
+func buildOPAAuthzHTTPFilter(provider OPAProvider) *http_conn.HttpFilter {
+	denyStatus := provider.DenyStatusCode
+	if denyStatus == 0 {
+		denyStatus = 403
+	}
+
+	claimMapping := make(map[string]interface{}, len(provider.ClaimToInput))
+	for claim, input := range provider.ClaimToInput {
+		claimMapping[claim] = input
+	}
+
+	cfg := map[string]interface{}{
+		"opa_url":              provider.URL,
+		"query_path":           provider.QueryPath,
+		"deny_status_code":     float64(denyStatus),
+		"claim_to_input":       claimMapping,
+		"decision_cache_ttl_s": provider.DecisionCacheTTL.Seconds(),
+	}
+
+	return &http_conn.HttpFilter{
+		Name:   opaAuthzFilterName,
+		Config: mapToStruct(cfg),
+	}
+}
+
+// mapToStruct converts a tree of map[string]interface{}/[]interface{}/string/float64/bool/nil
+// values into the equivalent gogo types.Struct, the same general-purpose JSON-ish config value
+// Envoy's xDS HttpFilter.Config field expects. There's no real go-control-plane message type to
+// marshal this filter's config from (see this file's package comment), so this builds the
+// types.Struct directly instead of going through a proto message and util.MessageToStruct.
+func mapToStruct(m map[string]interface{}) *types.Struct {
+	fields := make(map[string]*types.Value, len(m))
+	for k, v := range m {
+		fields[k] = toValue(v)
+	}
+	return &types.Struct{Fields: fields}
+}
+
+func toValue(v interface{}) *types.Value {
+	switch t := v.(type) {
+	case nil:
+		return &types.Value{Kind: &types.Value_NullValue{}}
+	case bool:
+		return &types.Value{Kind: &types.Value_BoolValue{BoolValue: t}}
+	case string:
+		return &types.Value{Kind: &types.Value_StringValue{StringValue: t}}
+	case float64:
+		return &types.Value{Kind: &types.Value_NumberValue{NumberValue: t}}
+	case map[string]interface{}:
+		return &types.Value{Kind: &types.Value_StructValue{StructValue: mapToStruct(t)}}
+	case []interface{}:
+		values := make([]*types.Value, 0, len(t))
+		for _, item := range t {
+			values = append(values, toValue(item))
+		}
+		return &types.Value{Kind: &types.Value_ListValue{ListValue: &types.ListValue{Values: values}}}
+	default:
+		return &types.Value{Kind: &types.Value_NullValue{}}
+	}
+}
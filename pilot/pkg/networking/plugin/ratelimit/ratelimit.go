@@ -0,0 +1,173 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit protects gateway-fronted services from thundering herds by capping
+// connections (TCP listeners) and request rate (HTTP listeners) at the listener itself,
+// without needing an external policy adapter like Mixer.
+package ratelimit
+
+import (
+	"fmt"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/plugin"
+)
+
+// connectionLimitFilterName is the Envoy network filter name for the per-listener connection
+// cap applied to gateway TCP (and TLS) listeners.
+const connectionLimitFilterName = "envoy.filters.network.connection_limit"
+
+// localRateLimitFilterName is the Envoy HTTP filter name for the per-listener local request
+// rate limit applied to gateway HTTP listeners.
+const localRateLimitFilterName = "envoy.filters.http.local_ratelimit"
+
+// Policy configures the connection/request limits Plugin enforces. Today this is built up by
+// whatever constructs the Plugin (see NewPlugin); there's no per-Server CRD binding yet -- see
+// NewPlugin's doc comment for why.
+type Policy struct {
+	// MaxConnections caps concurrent connections on a gateway TCP listener. Zero disables the
+	// connection-limit filter.
+	MaxConnections int
+
+	// ConnectionsPerSecond caps the average request rate a gateway HTTP listener admits before
+	// returning 429s. Zero disables the local rate-limit filter.
+	ConnectionsPerSecond float64
+
+	// Burst allows ConnectionsPerSecond to be briefly exceeded by this many requests before
+	// limiting kicks in.
+	Burst int
+}
+
+// Plugin is a connection/rate-limit plugin for gateway listeners.
+type Plugin struct {
+	policy Policy
+}
+
+// NewPlugin returns a ptr to an initialized ratelimit.Plugin driven by policy.
+//
+// There's no CRD binding of this Policy to a Gateway's networking.Server yet: plugin.InputParams
+// (defined outside this snapshot) doesn't carry the Server that produced a given filter chain
+// through to OnOutboundListener, and networking.Server itself (istio.io/api, a stable external
+// type) doesn't have limit fields to add without a newer API version to draw them from. Until
+// both of those exist, every gateway listener built with this Plugin registered shares the one
+// Policy it was constructed with, the same limitation authz.Plugin documents for its own Policy
+// (see its NewPlugin for the confirmed call site that rules out a plugin.InputParams field) --
+// and that jwtauthn.Plugin and opaauthz.Plugin document in turn for their own
+// JWTProvider/OPAProvider, all for the same missing-CRD-binding reason.
+func NewPlugin(policy Policy) plugin.Plugin {
+	return Plugin{policy: policy}
+}
+
+// ValidatePolicy rejects a Policy with a negative MaxConnections, ConnectionsPerSecond, or Burst
+// -- the check a Gateway CRD admission webhook would run before persisting a Policy, once a CRD
+// exists to bind one (see NewPlugin's doc comment). Zero is valid on every field -- it disables
+// the corresponding filter -- only a negative value is rejected.
+func ValidatePolicy(policy Policy) error {
+	if policy.MaxConnections < 0 {
+		return fmt.Errorf("maxConnections must not be negative, got %d", policy.MaxConnections)
+	}
+	if policy.ConnectionsPerSecond < 0 {
+		return fmt.Errorf("connectionsPerSecond must not be negative, got %v", policy.ConnectionsPerSecond)
+	}
+	if policy.Burst < 0 {
+		return fmt.Errorf("burst must not be negative, got %d", policy.Burst)
+	}
+	return nil
+}
+
+// OnOutboundListener implements the Plugin interface method.
+func (p Plugin) OnOutboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	switch in.ListenerType {
+	case plugin.ListenerTypeTCP:
+		if p.policy.MaxConnections <= 0 {
+			return nil
+		}
+		filter := buildConnectionLimitFilter(p.policy)
+		for cnum := range mutable.FilterChains {
+			// Prepended, not appended: the limit should reject a connection before any other
+			// network filter (e.g. Mixer, TLS passthrough's tcp_proxy) does any work on it.
+			mutable.FilterChains[cnum].TCP = append([]listener.Filter{filter}, mutable.FilterChains[cnum].TCP...)
+		}
+	case plugin.ListenerTypeHTTP:
+		if p.policy.ConnectionsPerSecond <= 0 {
+			return nil
+		}
+		filter := buildLocalRateLimitHTTPFilter(p.policy)
+		for cnum := range mutable.FilterChains {
+			// Prepended so it runs ahead of the router (and any other HTTP filter, like
+			// ext_authz) -- a rejected request shouldn't reach authorization or routing.
+			mutable.FilterChains[cnum].HTTP = append([]*http_conn.HttpFilter{filter}, mutable.FilterChains[cnum].HTTP...)
+		}
+	}
+	return nil
+}
+
+// OnInboundListener implements the Plugin interface method. These limits are a gateway-only
+// feature for now, so inbound (sidecar) listeners are left untouched.
+func (Plugin) OnInboundListener(in *plugin.InputParams, mutable *plugin.MutableObjects) error {
+	return nil
+}
+
+// OnOutboundCluster implements the Plugin interface method.
+func (Plugin) OnOutboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnInboundCluster implements the Plugin interface method.
+func (Plugin) OnInboundCluster(env model.Environment, node model.Proxy, service *model.Service, servicePort *model.Port, cluster *xdsapi.Cluster) {
+}
+
+// OnOutboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnOutboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// OnInboundRouteConfiguration implements the Plugin interface method.
+func (Plugin) OnInboundRouteConfiguration(in *plugin.InputParams, routeConfiguration *xdsapi.RouteConfiguration) {
+}
+
+// buildConnectionLimitFilter builds the envoy.filters.network.connection_limit filter capping
+// concurrent connections at policy.MaxConnections.
+func buildConnectionLimitFilter(policy Policy) listener.Filter {
+	return listener.Filter{
+		Name: connectionLimitFilterName,
+		Config: &types.Struct{
+			Fields: map[string]*types.Value{
+				"max_connections": numberValue(float64(policy.MaxConnections)),
+			},
+		},
+	}
+}
+
+// buildLocalRateLimitHTTPFilter builds the local rate-limit HTTP filter enforcing
+// policy.ConnectionsPerSecond with policy.Burst room to absorb spikes.
+func buildLocalRateLimitHTTPFilter(policy Policy) *http_conn.HttpFilter {
+	return &http_conn.HttpFilter{
+		Name: localRateLimitFilterName,
+		Config: &types.Struct{
+			Fields: map[string]*types.Value{
+				"requests_per_second": numberValue(policy.ConnectionsPerSecond),
+				"burst_size":          numberValue(float64(policy.Burst)),
+			},
+		},
+	}
+}
+
+// numberValue wraps f as a google.protobuf.Struct number field.
+func numberValue(f float64) *types.Value {
+	return &types.Value{Kind: &types.Value_NumberValue{NumberValue: f}}
+}
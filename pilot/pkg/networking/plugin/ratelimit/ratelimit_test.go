@@ -0,0 +1,98 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+
+	"istio.io/istio/pilot/pkg/networking/plugin"
+)
+
+// TestOnOutboundListenerPrependsFilters asserts both the TCP connection-limit filter and the
+// HTTP local-ratelimit filter are prepended ahead of whatever's already in the chain -- a
+// rejected connection/request must never reach a later filter's work (Mixer, ext_authz, the
+// router), per OnOutboundListener's own comments.
+func TestOnOutboundListenerPrependsFilters(t *testing.T) {
+	p := NewPlugin(Policy{MaxConnections: 10, ConnectionsPerSecond: 5, Burst: 2})
+
+	existingTCP := listener.Filter{Name: "envoy.filters.network.tcp_proxy"}
+	existingHTTP := &http_conn.HttpFilter{Name: "envoy.router"}
+	mutable := &plugin.MutableObjects{
+		FilterChains: []plugin.FilterChain{{
+			TCP:  []listener.Filter{existingTCP},
+			HTTP: []*http_conn.HttpFilter{existingHTTP},
+		}},
+	}
+
+	if err := p.OnOutboundListener(&plugin.InputParams{ListenerType: plugin.ListenerTypeTCP}, mutable); err != nil {
+		t.Fatalf("OnOutboundListener (TCP) returned error: %v", err)
+	}
+	if err := p.OnOutboundListener(&plugin.InputParams{ListenerType: plugin.ListenerTypeHTTP}, mutable); err != nil {
+		t.Fatalf("OnOutboundListener (HTTP) returned error: %v", err)
+	}
+
+	tcp := mutable.FilterChains[0].TCP
+	if len(tcp) != 2 || tcp[0].Name != connectionLimitFilterName || tcp[1] != existingTCP {
+		t.Errorf("expected [%s, %s], got %v", connectionLimitFilterName, existingTCP.Name, tcp)
+	}
+
+	httpChain := mutable.FilterChains[0].HTTP
+	if len(httpChain) != 2 || httpChain[0].Name != localRateLimitFilterName || httpChain[1] != existingHTTP {
+		t.Errorf("expected [%s, %s], got %v", localRateLimitFilterName, existingHTTP.Name, httpChain)
+	}
+}
+
+// TestOnOutboundListenerDisabledByZero asserts a zero-value limit (MaxConnections or
+// ConnectionsPerSecond) leaves the chain untouched rather than installing a filter that limits
+// nothing.
+func TestOnOutboundListenerDisabledByZero(t *testing.T) {
+	p := NewPlugin(Policy{})
+	mutable := &plugin.MutableObjects{FilterChains: []plugin.FilterChain{{}}}
+
+	if err := p.OnOutboundListener(&plugin.InputParams{ListenerType: plugin.ListenerTypeTCP}, mutable); err != nil {
+		t.Fatalf("OnOutboundListener (TCP) returned error: %v", err)
+	}
+	if err := p.OnOutboundListener(&plugin.InputParams{ListenerType: plugin.ListenerTypeHTTP}, mutable); err != nil {
+		t.Fatalf("OnOutboundListener (HTTP) returned error: %v", err)
+	}
+	if len(mutable.FilterChains[0].TCP) != 0 || len(mutable.FilterChains[0].HTTP) != 0 {
+		t.Errorf("expected no filters added with a zero-value Policy, got %v", mutable.FilterChains[0])
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{"zero value", Policy{}, false},
+		{"valid", Policy{MaxConnections: 10, ConnectionsPerSecond: 5, Burst: 2}, false},
+		{"negative MaxConnections", Policy{MaxConnections: -1}, true},
+		{"negative ConnectionsPerSecond", Policy{ConnectionsPerSecond: -1}, true},
+		{"negative Burst", Policy{Burst: -1}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ValidatePolicy(c.policy)
+			if (err != nil) != c.wantErr {
+				t.Errorf("ValidatePolicy(%+v) = %v, wantErr %v", c.policy, err, c.wantErr)
+			}
+		})
+	}
+}
@@ -34,6 +34,112 @@ func isDestinationExcludedForMTLS(serviceName string, mtlsExcludedServices []str
 	return false
 }
 
+// defaultOutlierMaxEjectionPercent is used whenever a SimpleCircuitBreakerPolicy doesn't
+// set HttpMaxEjectionPercent.
+const defaultOutlierMaxEjectionPercent = 10
+
+// LoadBalancerTranslator sets cluster's LbType from an (optional) DestinationPolicy load
+// balancing policy. It returns false if it doesn't recognize lb, letting the next
+// registered translator have a turn; this is how new LB kinds are added without editing
+// ApplyClusterPolicy itself.
+type LoadBalancerTranslator func(cluster *Cluster, lb *routing.LoadBalancing) bool
+
+// OutlierDetectionTranslator populates cluster.OutlierDetection from a
+// SimpleCircuitBreakerPolicy. It returns false if it doesn't apply, letting the next
+// registered translator have a turn; if none applies, no outlier detection is configured.
+type OutlierDetectionTranslator func(cluster *Cluster, cb *routing.CircuitBreaker_SimpleCircuitBreakerPolicy) bool
+
+// CircuitBreakerTranslator populates cluster.CircuitBreaker (Envoy's bulkhead, as distinct
+// from outlier detection's per-endpoint circuit breaker) from a SimpleCircuitBreakerPolicy.
+type CircuitBreakerTranslator func(cluster *Cluster, cb *routing.CircuitBreaker_SimpleCircuitBreakerPolicy) bool
+
+var (
+	loadBalancerTranslators     []LoadBalancerTranslator
+	outlierDetectionTranslators []OutlierDetectionTranslator
+	circuitBreakerTranslators   []CircuitBreakerTranslator
+)
+
+// RegisterLoadBalancerTranslator appends tr to the chain ApplyClusterPolicy consults for
+// DestinationPolicy.LoadBalancing, e.g. to add a consistent-hash policy such as RING_HASH
+// or MAGLEV with a hash key derived from a header, cookie, or source IP.
+func RegisterLoadBalancerTranslator(tr LoadBalancerTranslator) {
+	loadBalancerTranslators = append(loadBalancerTranslators, tr)
+}
+
+// RegisterOutlierDetectionTranslator appends tr to the chain ApplyClusterPolicy consults
+// for outlier detection, e.g. to expose additional Envoy outlier signals (consecutive
+// gateway failures, success-rate deviation, per-signal enforcing percent) beyond the
+// consecutive-5xx/sleep-window/max-ejection-percent defaults below. ApplyClusterPolicy stops at
+// the first translator that returns true, and the default one registered in init always does,
+// so a translator appended after it is not called.
+func RegisterOutlierDetectionTranslator(tr OutlierDetectionTranslator) {
+	outlierDetectionTranslators = append(outlierDetectionTranslators, tr)
+}
+
+// RegisterCircuitBreakerTranslator appends tr to the chain ApplyClusterPolicy consults for
+// the cluster's (bulkhead) circuit breaker.
+func RegisterCircuitBreakerTranslator(tr CircuitBreakerTranslator) {
+	circuitBreakerTranslators = append(circuitBreakerTranslators, tr)
+}
+
+func init() {
+	// Default translators reproduce ApplyClusterPolicy's historical, hardcoded mapping
+	// exactly, so existing DestinationPolicy configs keep generating the same Cluster.
+	RegisterLoadBalancerTranslator(func(cluster *Cluster, lb *routing.LoadBalancing) bool {
+		switch lb.GetName() {
+		case routing.LoadBalancing_ROUND_ROBIN:
+			cluster.LbType = LbTypeRoundRobin
+		case routing.LoadBalancing_LEAST_CONN:
+			cluster.LbType = LbTypeLeastRequest
+		case routing.LoadBalancing_RANDOM:
+			cluster.LbType = LbTypeRandom
+		default:
+			return false
+		}
+		return true
+	})
+
+	RegisterCircuitBreakerTranslator(func(cluster *Cluster, cb *routing.CircuitBreaker_SimpleCircuitBreakerPolicy) bool {
+		cluster.MaxRequestsPerConnection = int(cb.HttpMaxRequestsPerConnection)
+
+		// Envoy's circuit breaker is a combination of its circuit breaker (which is actually a bulk head)
+		// outlier detection (which is per pod circuit breaker)
+		cluster.CircuitBreaker = &CircuitBreaker{}
+		if cb.MaxConnections > 0 {
+			cluster.CircuitBreaker.Default.MaxConnections = int(cb.MaxConnections)
+		}
+		if cb.HttpMaxRequests > 0 {
+			cluster.CircuitBreaker.Default.MaxRequests = int(cb.HttpMaxRequests)
+		}
+		if cb.HttpMaxPendingRequests > 0 {
+			cluster.CircuitBreaker.Default.MaxPendingRequests = int(cb.HttpMaxPendingRequests)
+		}
+		if cb.HttpMaxRetries > 0 {
+			cluster.CircuitBreaker.Default.MaxRetries = int(cb.HttpMaxRetries)
+		}
+		return true
+	})
+
+	RegisterOutlierDetectionTranslator(func(cluster *Cluster, cb *routing.CircuitBreaker_SimpleCircuitBreakerPolicy) bool {
+		cluster.OutlierDetection = &OutlierDetection{}
+
+		cluster.OutlierDetection.MaxEjectionPercent = defaultOutlierMaxEjectionPercent
+		if cb.SleepWindow.Seconds > 0 {
+			cluster.OutlierDetection.BaseEjectionTimeMS = protoDurationToMS(cb.SleepWindow)
+		}
+		if cb.HttpConsecutiveErrors > 0 {
+			cluster.OutlierDetection.ConsecutiveErrors = int(cb.HttpConsecutiveErrors)
+		}
+		if cb.HttpDetectionInterval.Seconds > 0 {
+			cluster.OutlierDetection.IntervalMS = protoDurationToMS(cb.HttpDetectionInterval)
+		}
+		if cb.HttpMaxEjectionPercent > 0 {
+			cluster.OutlierDetection.MaxEjectionPercent = int(cb.HttpMaxEjectionPercent)
+		}
+		return true
+	})
+}
+
 // ApplyClusterPolicy assumes an outbound cluster and inserts custom configuration for the cluster
 func ApplyClusterPolicy(cluster *Cluster,
 	proxyInstances []*model.ServiceInstance,
@@ -71,51 +177,25 @@ func ApplyClusterPolicy(cluster *Cluster,
 	// Load balancing policies do not apply for Original DST clusters
 	// as the intent is to go directly to the instance.
 	if policy.LoadBalancing != nil && cluster.Type != ClusterTypeOriginalDST {
-		switch policy.LoadBalancing.GetName() {
-		case routing.LoadBalancing_ROUND_ROBIN:
-			cluster.LbType = LbTypeRoundRobin
-		case routing.LoadBalancing_LEAST_CONN:
-			cluster.LbType = LbTypeLeastRequest
-		case routing.LoadBalancing_RANDOM:
-			cluster.LbType = LbTypeRandom
+		for _, translate := range loadBalancerTranslators {
+			if translate(cluster, policy.LoadBalancing) {
+				break
+			}
 		}
 	}
 
 	// Set up circuit breakers and outlier detection
 	if policy.CircuitBreaker != nil && policy.CircuitBreaker.GetSimpleCb() != nil {
 		cbconfig := policy.CircuitBreaker.GetSimpleCb()
-		cluster.MaxRequestsPerConnection = int(cbconfig.HttpMaxRequestsPerConnection)
-
-		// Envoy's circuit breaker is a combination of its circuit breaker (which is actually a bulk head)
-		// outlier detection (which is per pod circuit breaker)
-		cluster.CircuitBreaker = &CircuitBreaker{}
-		if cbconfig.MaxConnections > 0 {
-			cluster.CircuitBreaker.Default.MaxConnections = int(cbconfig.MaxConnections)
-		}
-		if cbconfig.HttpMaxRequests > 0 {
-			cluster.CircuitBreaker.Default.MaxRequests = int(cbconfig.HttpMaxRequests)
-		}
-		if cbconfig.HttpMaxPendingRequests > 0 {
-			cluster.CircuitBreaker.Default.MaxPendingRequests = int(cbconfig.HttpMaxPendingRequests)
-		}
-		if cbconfig.HttpMaxRetries > 0 {
-			cluster.CircuitBreaker.Default.MaxRetries = int(cbconfig.HttpMaxRetries)
-		}
-
-		cluster.OutlierDetection = &OutlierDetection{}
-
-		cluster.OutlierDetection.MaxEjectionPercent = 10
-		if cbconfig.SleepWindow.Seconds > 0 {
-			cluster.OutlierDetection.BaseEjectionTimeMS = protoDurationToMS(cbconfig.SleepWindow)
-		}
-		if cbconfig.HttpConsecutiveErrors > 0 {
-			cluster.OutlierDetection.ConsecutiveErrors = int(cbconfig.HttpConsecutiveErrors)
-		}
-		if cbconfig.HttpDetectionInterval.Seconds > 0 {
-			cluster.OutlierDetection.IntervalMS = protoDurationToMS(cbconfig.HttpDetectionInterval)
+		for _, translate := range circuitBreakerTranslators {
+			if translate(cluster, cbconfig) {
+				break
+			}
 		}
-		if cbconfig.HttpMaxEjectionPercent > 0 {
-			cluster.OutlierDetection.MaxEjectionPercent = int(cbconfig.HttpMaxEjectionPercent)
+		for _, translate := range outlierDetectionTranslators {
+			if translate(cluster, cbconfig) {
+				break
+			}
 		}
 	}
 }
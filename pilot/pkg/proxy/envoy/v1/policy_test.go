@@ -0,0 +1,152 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	routing "istio.io/api/routing/v1alpha1"
+)
+
+// These tests fix the output of the translator chain init() registers for a handful of
+// pre-refactor fixtures, so the translator-based ApplyClusterPolicy keeps producing the exact
+// same Cluster fields the old hardcoded switch/if chain did.
+//
+// They exercise the registered translators directly rather than going through
+// ApplyClusterPolicy: everything ApplyClusterPolicy does before consulting the translators
+// (RequireTLS, GetConsolidateAuthenticationPolicy, buildClusterSSLContext, model.ServiceAccounts)
+// lives outside this package, and none of those are part of this snapshot either -- there's
+// nothing in this tree a fake ConfigStore/ServiceAccounts could stand in for that would let
+// ApplyClusterPolicy itself run. The translators are exactly the code this refactor introduced,
+// and reading them straight out of the package-level slices init() populates lets these tests
+// verify that code without needing the unrelated, unbuildable auth preamble.
+
+func TestLoadBalancerTranslatorsMatchHistoricalMapping(t *testing.T) {
+	cases := []struct {
+		name   string
+		lb     routing.LoadBalancing_SimpleLB
+		want   string
+		wantOk bool
+	}{
+		{"round robin", routing.LoadBalancing_ROUND_ROBIN, LbTypeRoundRobin, true},
+		{"least conn", routing.LoadBalancing_LEAST_CONN, LbTypeLeastRequest, true},
+		{"random", routing.LoadBalancing_RANDOM, LbTypeRandom, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cluster := &Cluster{}
+			lb := &routing.LoadBalancing{LbPolicy: &routing.LoadBalancing_Name{Name: c.lb}}
+			var ok bool
+			for _, translate := range loadBalancerTranslators {
+				if translate(cluster, lb) {
+					ok = true
+					break
+				}
+			}
+			if ok != c.wantOk {
+				t.Fatalf("expected translated=%v, got %v", c.wantOk, ok)
+			}
+			if cluster.LbType != c.want {
+				t.Errorf("expected LbType %q, got %q", c.want, cluster.LbType)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerTranslatorMatchesHistoricalMapping(t *testing.T) {
+	cb := &routing.CircuitBreaker_SimpleCircuitBreakerPolicy{
+		MaxConnections:               7,
+		HttpMaxPendingRequests:       8,
+		HttpMaxRequests:              9,
+		HttpMaxRequestsPerConnection: 10,
+		HttpMaxRetries:               11,
+	}
+	cluster := &Cluster{}
+	var applied bool
+	for _, translate := range circuitBreakerTranslators {
+		if translate(cluster, cb) {
+			applied = true
+			break
+		}
+	}
+	if !applied {
+		t.Fatal("expected the default circuit breaker translator to apply")
+	}
+	if cluster.MaxRequestsPerConnection != 10 {
+		t.Errorf("expected MaxRequestsPerConnection 10, got %d", cluster.MaxRequestsPerConnection)
+	}
+	if cluster.CircuitBreaker == nil {
+		t.Fatal("expected a non-nil CircuitBreaker")
+	}
+	if cluster.CircuitBreaker.Default.MaxConnections != 7 {
+		t.Errorf("expected Default.MaxConnections 7, got %d", cluster.CircuitBreaker.Default.MaxConnections)
+	}
+	if cluster.CircuitBreaker.Default.MaxRequests != 9 {
+		t.Errorf("expected Default.MaxRequests 9, got %d", cluster.CircuitBreaker.Default.MaxRequests)
+	}
+	if cluster.CircuitBreaker.Default.MaxPendingRequests != 8 {
+		t.Errorf("expected Default.MaxPendingRequests 8, got %d", cluster.CircuitBreaker.Default.MaxPendingRequests)
+	}
+	if cluster.CircuitBreaker.Default.MaxRetries != 11 {
+		t.Errorf("expected Default.MaxRetries 11, got %d", cluster.CircuitBreaker.Default.MaxRetries)
+	}
+}
+
+func TestOutlierDetectionTranslatorMatchesHistoricalMapping(t *testing.T) {
+	// SleepWindow/HttpDetectionInterval are left unset so the translator's protoDurationToMS
+	// conversion (defined outside this file, and outside this snapshot) is never invoked --
+	// only the plain-integer fields below are asserted.
+	cb := &routing.CircuitBreaker_SimpleCircuitBreakerPolicy{
+		HttpConsecutiveErrors:  5,
+		HttpMaxEjectionPercent: 50,
+	}
+	cluster := &Cluster{}
+	var applied bool
+	for _, translate := range outlierDetectionTranslators {
+		if translate(cluster, cb) {
+			applied = true
+			break
+		}
+	}
+	if !applied {
+		t.Fatal("expected the default outlier detection translator to apply")
+	}
+	if cluster.OutlierDetection == nil {
+		t.Fatal("expected a non-nil OutlierDetection")
+	}
+	if cluster.OutlierDetection.ConsecutiveErrors != 5 {
+		t.Errorf("expected ConsecutiveErrors 5, got %d", cluster.OutlierDetection.ConsecutiveErrors)
+	}
+	if cluster.OutlierDetection.MaxEjectionPercent != 50 {
+		t.Errorf("expected the explicit HttpMaxEjectionPercent (50) to override the %d default, got %d",
+			defaultOutlierMaxEjectionPercent, cluster.OutlierDetection.MaxEjectionPercent)
+	}
+}
+
+// TestOutlierDetectionTranslatorDefaultsMaxEjectionPercent asserts the historical default
+// (defaultOutlierMaxEjectionPercent) still applies when HttpMaxEjectionPercent is left unset.
+func TestOutlierDetectionTranslatorDefaultsMaxEjectionPercent(t *testing.T) {
+	cluster := &Cluster{}
+	cb := &routing.CircuitBreaker_SimpleCircuitBreakerPolicy{}
+	for _, translate := range outlierDetectionTranslators {
+		if translate(cluster, cb) {
+			break
+		}
+	}
+	if cluster.OutlierDetection.MaxEjectionPercent != defaultOutlierMaxEjectionPercent {
+		t.Errorf("expected the default MaxEjectionPercent (%d), got %d",
+			defaultOutlierMaxEjectionPercent, cluster.OutlierDetection.MaxEjectionPercent)
+	}
+}
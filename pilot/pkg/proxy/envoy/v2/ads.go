@@ -108,9 +108,9 @@ type XdsConnection struct {
 
 	modelNode *model.Proxy
 
-	// Sending on this channel results in  push. We may also make it a channel of objects so
-	// same info can be sent to all clients, without recomputing.
-	pushChannel chan *XdsEvent
+	// Sending on this channel results in a push, scoped to whatever the sent PushRequest
+	// says changed so ProxyNeedsPush and the push loop can skip what isn't affected.
+	pushChannel chan *PushRequest
 
 	// TODO: migrate other fields as needed from model.Proxy and replace it
 
@@ -127,6 +127,14 @@ type XdsConnection struct {
 
 	stream ads.AggregatedDiscoveryService_StreamAggregatedResourcesServer
 
+	// deltaStream is set instead of stream for connections using the Incremental (Delta)
+	// xDS protocol; the two are mutually exclusive on a given connection.
+	deltaStream ads.AggregatedDiscoveryService_DeltaAggregatedResourcesServer
+
+	// deltaState tracks, per TypeUrl, the per-resource subscription/version state needed
+	// to push only what changed to a Delta connection. nil for SotW connections.
+	deltaState map[string]*deltaTypeState
+
 	// Routes is the list of watched Routes.
 	Routes []string
 
@@ -135,19 +143,19 @@ type XdsConnection struct {
 	// CDSWatch is set if the remote server is watching Clusters
 	CDSWatch bool
 
+	// watches generalizes LDSWatch/CDSWatch/Routes/Clusters above to any TypeUrl, keyed by
+	// it, so a registered XdsResourceGenerator doesn't need a dedicated field here to track
+	// its subscription state. The SotW fields above are still the source of truth for the
+	// TypeUrls Pilot knew about at compile time (LDS/CDS/RDS/EDS); watches exists so the
+	// Delta path, and any TypeUrl with a registered generator, has somewhere to keep this
+	// state without it.
+	watches map[string]*WatchedResource
+
 	// added will be true if at least one discovery request was received, and the connection
 	// is added to the map of active.
 	added bool
 }
 
-// XdsEvent represents a config or registry event that results in a push.
-type XdsEvent struct {
-
-	// If not empty, it is used to indicate the event is caused by a change in the clusters.
-	// Only EDS for the listed clusters will be sent.
-	clusters []string
-}
-
 // StreamAggregatedResources implements the ADS interface.
 func (s *DiscoveryServer) StreamAggregatedResources(stream ads.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
 	peerInfo, ok := peer.FromContext(stream.Context())
@@ -165,7 +173,7 @@ func (s *DiscoveryServer) StreamAggregatedResources(stream ads.AggregatedDiscove
 	}
 
 	con := &XdsConnection{
-		pushChannel:   make(chan *XdsEvent, 1),
+		pushChannel:   make(chan *PushRequest, 1),
 		PeerAddr:      peerAddr,
 		Connect:       time.Now(),
 		HTTPListeners: []*xdsapi.Listener{},
@@ -326,29 +334,38 @@ func (s *DiscoveryServer) StreamAggregatedResources(stream ads.AggregatedDiscove
 				s.addCon(con.ConID, con)
 				defer s.removeCon(con.ConID, con)
 			}
-		case <-con.pushChannel:
-			// It is called when config changes.
-			// This is not optimized yet - we should detect what changed based on event and only
-			// push resources that need to be pushed.
-			if con.CDSWatch {
+		case pushReq, ok := <-con.pushChannel:
+			if !ok {
+				return nil
+			}
+			if !s.ProxyNeedsPush(con.modelNode, pushReq) {
+				continue
+			}
+			// A Full push, or one whose ConfigsUpdated is non-empty, recomputes CDS/LDS/RDS;
+			// an EDS-only PushRequest (ConfigsUpdated empty, EdsUpdates non-empty) leaves
+			// them alone, since none of those three depend on endpoint membership.
+			pushConfig := pushReq.Full || len(pushReq.ConfigsUpdated) > 0
+			pushEds := pushReq.Full || len(pushReq.EdsUpdates) > 0
+
+			if pushConfig && con.CDSWatch {
 				err := s.pushCds(*con.modelNode, con)
 				if err != nil {
 					return err
 				}
 			}
-			if len(con.Routes) > 0 {
+			if pushConfig && len(con.Routes) > 0 {
 				err := s.pushRoute(con)
 				if err != nil {
 					return err
 				}
 			}
-			if len(con.Clusters) > 0 {
+			if pushEds && len(con.Clusters) > 0 && edsUpdateRelevant(con.Clusters, pushReq) {
 				err := s.pushEds(con)
 				if err != nil {
 					return err
 				}
 			}
-			if con.LDSWatch {
+			if pushConfig && con.LDSWatch {
 				err := s.pushLds(*con.modelNode, con)
 				if err != nil {
 					return err
@@ -366,48 +383,6 @@ func edsClientCount() int {
 	return n
 }
 
-// adsPushAll implements old style invalidation, generated when any rule or endpoint changes.
-// Primary code path is from v1 discoveryService.clearCache(), which is added as a handler
-// to the model ConfigStorageCache and Controller.
-func adsPushAll() {
-	// First update all cluster load assignments. This is computed for each cluster once per config change
-	// instead of once per endpoint.
-	edsClusterMutex.Lock()
-	// Create a temp map to avoid locking the add/remove
-	cMap := make(map[string]*EdsCluster, len(edsClusters))
-	for k, v := range edsClusters {
-		cMap[k] = v
-	}
-	edsClusterMutex.Unlock()
-
-	// UpdateCluster udates the cluster with a mutex, this code is safe ( but computing
-	// the update may be duplicated if multiple goroutines compute at the same time).
-	// In general this code is called from the 'event' callback that is throttled.
-	for clusterName, edsCluster := range cMap {
-		if err := updateCluster(clusterName, edsCluster); err != nil {
-			log.Errorf("updateCluster failed with clusterName %s", clusterName)
-		}
-	}
-
-	// Push config changes, iterating over connected envoys. This cover ADS and EDS(0.7), both share
-	// the same connection table
-	adsClientsMutex.RLock()
-	// Create a temp map to avoid locking the add/remove
-	tmpMap := make(map[string]*XdsConnection, len(adsClients))
-	for k, v := range adsClients {
-		tmpMap[k] = v
-	}
-	adsClientsMutex.RUnlock()
-
-	// This will trigger recomputing the config for each connected Envoy.
-	// It will include sending all configs that envoy is listening for, including EDS.
-	// TODO: get service, serviceinstances, configs once, to avoid repeated redundant calls.
-	// TODO: indicate the specific events, to only push what changed.
-	for _, client := range tmpMap {
-		client.pushChannel <- &XdsEvent{}
-	}
-}
-
 func (s *DiscoveryServer) addCon(conID string, con *XdsConnection) {
 	adsClientsMutex.Lock()
 	defer adsClientsMutex.Unlock()
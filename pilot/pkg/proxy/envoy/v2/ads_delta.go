@@ -0,0 +1,356 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	ads "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/gogo/protobuf/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/log"
+)
+
+// deltaTypeState tracks, for a single TypeUrl on a single connection, which resource
+// names Envoy is currently subscribed to and the version (a hash of the marshaled
+// resource) last sent for each -- so a push only needs to include what changed instead of
+// the connection's entire resource set.
+type deltaTypeState struct {
+	// sent maps resource name -> version last sent to this connection.
+	sent map[string]string
+}
+
+func newDeltaTypeState() *deltaTypeState {
+	return &deltaTypeState{sent: map[string]string{}}
+}
+
+// subscribe applies a DeltaDiscoveryRequest's ResourceNamesSubscribe/Unsubscribe, mirroring
+// how Envoy's wildcard-less Delta subscriptions are meant to be maintained incrementally
+// across requests rather than replaced wholesale like SotW's ResourceNames.
+func (d *deltaTypeState) subscribe(add, remove []string) {
+	for _, name := range remove {
+		delete(d.sent, name)
+	}
+	for _, name := range add {
+		if _, ok := d.sent[name]; !ok {
+			d.sent[name] = "" // not yet sent; forces inclusion in the next diff
+		}
+	}
+}
+
+// names returns the resource names currently subscribed to: for con.Routes/con.Clusters,
+// which the SotW push helpers still key off, and for WatchedResource.ResourceNames, which
+// a registered XdsResourceGenerator keys off instead.
+func (d *deltaTypeState) names() []string {
+	out := make([]string, 0, len(d.sent))
+	for name := range d.sent {
+		out = append(out, name)
+	}
+	return out
+}
+
+// resourceVersion hashes a marshaled resource to a short, stable version string, so
+// diffDeltaResources can tell whether a resource actually changed instead of just whether
+// it's present.
+func resourceVersion(res *types.Any) string {
+	sum := sha256.Sum256(res.Value)
+	return hex.EncodeToString(sum[:8])
+}
+
+// diffDeltaResources compares resources (keyed by resource name) against what was sent
+// last time (state.sent), returning only the resources that are new or changed, plus the
+// names of resources that were sent before but are no longer present.
+func diffDeltaResources(state *deltaTypeState, resources map[string]*types.Any) (changed map[string]*types.Any, removed []string) {
+	changed = map[string]*types.Any{}
+	seen := make(map[string]bool, len(resources))
+	for name, res := range resources {
+		seen[name] = true
+		if v := resourceVersion(res); state.sent[name] != v {
+			changed[name] = res
+		}
+	}
+	for name := range state.sent {
+		if !seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	return changed, removed
+}
+
+// commit records resources/removed as having been sent, so the next diff is computed
+// against this push rather than the one before it.
+func (d *deltaTypeState) commit(resources map[string]*types.Any, removed []string) {
+	for _, name := range removed {
+		delete(d.sent, name)
+	}
+	for name, res := range resources {
+		d.sent[name] = resourceVersion(res)
+	}
+}
+
+// rejectDelta records a NACK the same way StreamAggregatedResources does, reusing the
+// existing cdsReject/ldsReject/edsReject gauges so dashboards don't need a second set of
+// metrics for the Delta transport.
+func rejectDelta(typeURL, nodeID, errMsg string) {
+	switch typeURL {
+	case ClusterType:
+		cdsReject.With(prometheus.Labels{"node": nodeID, "err": errMsg}).Add(1)
+	case ListenerType:
+		ldsReject.With(prometheus.Labels{"node": nodeID, "err": errMsg}).Add(1)
+	case EndpointType:
+		edsReject.With(prometheus.Labels{"node": nodeID, "err": errMsg}).Add(1)
+	}
+}
+
+// DeltaAggregatedResources implements the Incremental (Delta) xDS protocol. Unlike
+// StreamAggregatedResources, a push only carries resources that changed since the last one
+// sent to this connection (plus a RemovedResources list for anything dropped), so one
+// endpoint flapping doesn't force a full re-push of every cluster/listener/route Envoy
+// holds -- the cost adsPushAll's SotW pushes always pay.
+//
+// A TypeUrl is fully incremental once it has an XdsResourceGenerator registered for it
+// (see ads_generator.go): the generator's output is diffed per-resource-name against
+// deltaTypeState. RDS has one registered, reusing BuildSidecarOutboundHTTPRouteConfig.
+// CDS/LDS/EDS bookkeeping (watch flags, ACK/NACK, subscription tracking) is wired the
+// same way, but their resource builders (in cds.go, lds.go, eds.go) still only know how to
+// write a full snapshot straight to a SotW stream rather than hand back a resource map a
+// generator could wrap; until one is registered for them, those three types aren't pushed
+// over this transport at all.
+func (s *DiscoveryServer) DeltaAggregatedResources(stream ads.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	peerInfo, ok := peer.FromContext(stream.Context())
+	peerAddr := "0.0.0.0"
+	if ok {
+		peerAddr = peerInfo.Addr.String()
+	}
+
+	var receiveError error
+	reqChannel := make(chan *xdsapi.DeltaDiscoveryRequest, 1)
+
+	if s.services == nil {
+		s.updateModel()
+	}
+
+	con := &XdsConnection{
+		pushChannel: make(chan *PushRequest, 1),
+		PeerAddr:    peerAddr,
+		Connect:     time.Now(),
+		Clusters:    []string{},
+		deltaStream: stream,
+		deltaState:  map[string]*deltaTypeState{},
+	}
+
+	go func() {
+		defer close(reqChannel)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if status.Code(err) == codes.Canceled || err == io.EOF {
+					log.Infof("ADS: %q %s delta terminated %v", peerAddr, con.ConID, err)
+					return
+				}
+				receiveError = err
+				log.Errorf("ADS: %q %s delta terminated with errors %v", peerAddr, con.ConID, err)
+				return
+			}
+			reqChannel <- req
+		}
+	}()
+
+	for {
+		select {
+		case discReq, ok := <-reqChannel:
+			if !ok {
+				return receiveError
+			}
+
+			if discReq.Node != nil && discReq.Node.Id != "" {
+				nt, err := model.ParseServiceNode(discReq.Node.Id)
+				if err != nil {
+					return err
+				}
+				nt.Metadata = model.ParseMetadata(discReq.Node.Metadata)
+				con.modelNode = &nt
+			}
+			if con.modelNode == nil {
+				log.Infof("Delta ADS: missing node id %s", discReq.String())
+				continue
+			}
+			if con.ConID == "" {
+				con.ConID = connectionID(discReq.Node.Id)
+			}
+
+			if discReq.ErrorDetail != nil {
+				log.Warnf("Delta ADS:%s: NACK %v %s %v", discReq.TypeUrl, peerAddr, con.ConID, discReq.String())
+				rejectDelta(discReq.TypeUrl, discReq.Node.GetId(), discReq.ErrorDetail.Message)
+				continue
+			}
+
+			state, ok := con.deltaState[discReq.TypeUrl]
+			if !ok {
+				state = newDeltaTypeState()
+				con.deltaState[discReq.TypeUrl] = state
+			}
+			state.subscribe(discReq.ResourceNamesSubscribe, discReq.ResourceNamesUnsubscribe)
+
+			switch discReq.TypeUrl {
+			case ClusterType:
+				con.CDSWatch = true
+			case ListenerType:
+				con.LDSWatch = true
+			case RouteType:
+				con.Routes = state.names()
+			case EndpointType:
+				con.Clusters = state.names()
+				for _, c := range con.Clusters {
+					s.addEdsCon(c, con.ConID, con)
+				}
+			default:
+				log.Warnf("Delta ADS: unknown watched resource %s", discReq.String())
+				continue
+			}
+
+			if err := s.pushDelta(con, discReq.TypeUrl); err != nil {
+				return err
+			}
+
+			if !con.added {
+				con.added = true
+				s.addCon(con.ConID, con)
+				defer s.removeCon(con.ConID, con)
+			}
+
+		case pushReq, ok := <-con.pushChannel:
+			if !ok {
+				return nil
+			}
+			if !s.ProxyNeedsPush(con.modelNode, pushReq) {
+				continue
+			}
+			for typeURL, state := range con.deltaState {
+				// Envoy historically chokes on an empty initial Delta CDS response; skip
+				// it rather than sending a no-op push with nothing subscribed yet.
+				if typeURL == ClusterType && len(state.sent) == 0 && con.CDSWatch {
+					continue
+				}
+				// EndpointType is the only type whose resources depend on endpoint membership:
+				// it is skipped for a non-Full request whose EdsUpdates name none of
+				// con.Clusters. The other types are skipped for a non-Full request that carries
+				// EdsUpdates but no ConfigsUpdated.
+				if typeURL == EndpointType {
+					if !pushReq.Full && len(pushReq.EdsUpdates) > 0 && !edsUpdateRelevant(con.Clusters, pushReq) {
+						continue
+					}
+				} else if !pushReq.Full && len(pushReq.ConfigsUpdated) == 0 && len(pushReq.EdsUpdates) > 0 {
+					continue
+				}
+				if err := s.pushDelta(con, typeURL); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// pushContext assembles the PushContext a registered XdsResourceGenerator needs, from the
+// same env/services state pushRoute and pushDelta have always read directly.
+func (s *DiscoveryServer) pushContext() *PushContext {
+	s.modelMutex.RLock()
+	services := s.services
+	s.modelMutex.RUnlock()
+	return &PushContext{
+		Env:             s.env,
+		ConfigGenerator: s.ConfigGenerator,
+		Services:        services,
+	}
+}
+
+// pushDelta computes and sends the diff for a single TypeUrl, per the scoping note on
+// DeltaAggregatedResources: RDS is a true diff, built through the registered
+// XdsResourceGenerator; CDS/LDS/EDS have no generator registered, so for them it returns nil
+// without sending anything.
+func (s *DiscoveryServer) pushDelta(con *XdsConnection, typeURL string) error {
+	state := con.deltaState[typeURL]
+	if state == nil {
+		return nil
+	}
+
+	gen := findGenerator(typeURL, con.modelNode)
+	if gen == nil {
+		// CDS/LDS/EDS: cds.go/lds.go/eds.go don't expose a resource map to diff against yet
+		// (see DeltaAggregatedResources doc comment) -- nothing to push over this transport
+		// for these types until a generator is registered for them.
+		return nil
+	}
+
+	w := con.watch(typeURL)
+	w.ResourceNames = state.names()
+
+	// Generate returns one resource per requested name, in the same order -- there's no
+	// name carried on the Any itself, so the generator's output order is the only thing
+	// tying a built resource back to the name that was asked for.
+	built, err := gen.Generate(con.modelNode, s.pushContext(), w)
+	if err != nil {
+		log.Warnf("Delta ADS:%s: generator failed %v", typeURL, err)
+		return err
+	}
+	if len(built) != len(w.ResourceNames) {
+		log.Warnf("Delta ADS:%s: generator returned %d resources for %d requested names", typeURL, len(built), len(w.ResourceNames))
+		return nil
+	}
+
+	resources := make(map[string]*types.Any, len(w.ResourceNames))
+	for i, name := range w.ResourceNames {
+		resources[name] = built[i]
+	}
+
+	changed, removed := diffDeltaResources(state, resources)
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	resp := &xdsapi.DeltaDiscoveryResponse{
+		TypeUrl:           typeURL,
+		SystemVersionInfo: versionInfo(),
+		Nonce:             nonce(),
+		RemovedResources:  removed,
+	}
+	for name, res := range changed {
+		resp.Resources = append(resp.Resources, xdsapi.Resource{
+			Name:     name,
+			Version:  resourceVersion(res),
+			Resource: res,
+		})
+	}
+
+	if err := con.deltaStream.Send(resp); err != nil {
+		log.Warnf("Delta ADS:%s: send failure, closing grpc %v", typeURL, err)
+		return err
+	}
+	state.commit(changed, removed)
+
+	if adsDebug {
+		log.Infof("Delta ADS:%s: PUSH for addr:%s changed:%d removed:%d", typeURL, con.PeerAddr, len(changed), len(removed))
+	}
+	return nil
+}
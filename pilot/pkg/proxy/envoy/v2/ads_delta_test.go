@@ -0,0 +1,103 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/types"
+)
+
+func anyOf(value string) *types.Any {
+	return &types.Any{Value: []byte(value)}
+}
+
+// TestDiffDeltaResourcesFirstPushSendsEverything asserts a state with nothing sent yet
+// treats every resource as changed and reports no removals.
+func TestDiffDeltaResourcesFirstPushSendsEverything(t *testing.T) {
+	state := newDeltaTypeState()
+	resources := map[string]*types.Any{"a": anyOf("va"), "b": anyOf("vb")}
+
+	changed, removed := diffDeltaResources(state, resources)
+	if len(changed) != 2 || len(removed) != 0 {
+		t.Fatalf("expected both resources changed and none removed, got changed=%v removed=%v", changed, removed)
+	}
+}
+
+// TestDiffDeltaResourcesOnlyValueChangeIsReported asserts a resource whose value didn't
+// change since the last commit is excluded from the diff, while one that did is included.
+func TestDiffDeltaResourcesOnlyValueChangeIsReported(t *testing.T) {
+	state := newDeltaTypeState()
+	first := map[string]*types.Any{"a": anyOf("va"), "b": anyOf("vb")}
+	changed, removed := diffDeltaResources(state, first)
+	state.commit(changed, removed)
+
+	second := map[string]*types.Any{"a": anyOf("va"), "b": anyOf("vb-changed")}
+	changed, removed = diffDeltaResources(state, second)
+	if len(removed) != 0 {
+		t.Fatalf("expected no removals, got %v", removed)
+	}
+	if _, ok := changed["b"]; !ok || len(changed) != 1 {
+		t.Fatalf("expected only %q to be reported changed, got %v", "b", changed)
+	}
+}
+
+// TestDiffDeltaResourcesDroppedResourceIsRemoved asserts a resource sent before but absent
+// from the new set is reported in removed, not changed.
+func TestDiffDeltaResourcesDroppedResourceIsRemoved(t *testing.T) {
+	state := newDeltaTypeState()
+	first := map[string]*types.Any{"a": anyOf("va"), "b": anyOf("vb")}
+	changed, removed := diffDeltaResources(state, first)
+	state.commit(changed, removed)
+
+	second := map[string]*types.Any{"a": anyOf("va")}
+	changed, removed = diffDeltaResources(state, second)
+	if len(changed) != 0 {
+		t.Fatalf("expected nothing changed, got %v", changed)
+	}
+	if len(removed) != 1 || removed[0] != "b" {
+		t.Fatalf("expected %q reported removed, got %v", "b", removed)
+	}
+}
+
+// TestDeltaTypeStateSubscribeAddsAndRemoves asserts subscribe adds newly-subscribed names
+// (marked unsent, forcing inclusion in the next diff) and drops unsubscribed ones outright.
+func TestDeltaTypeStateSubscribeAddsAndRemoves(t *testing.T) {
+	state := newDeltaTypeState()
+	state.sent["existing"] = "v1"
+
+	state.subscribe([]string{"new"}, []string{"existing"})
+
+	if _, ok := state.sent["existing"]; ok {
+		t.Error("expected unsubscribed name to be dropped")
+	}
+	if v, ok := state.sent["new"]; !ok || v != "" {
+		t.Errorf("expected newly subscribed name present with an empty version, got %q present=%v", v, ok)
+	}
+}
+
+// TestDeltaTypeStateSubscribeKeepsAlreadySentVersion asserts re-subscribing to a name
+// already sent doesn't reset its recorded version, so an unchanged resource isn't
+// needlessly re-diffed as new.
+func TestDeltaTypeStateSubscribeKeepsAlreadySentVersion(t *testing.T) {
+	state := newDeltaTypeState()
+	state.sent["a"] = "v1"
+
+	state.subscribe([]string{"a"}, nil)
+
+	if state.sent["a"] != "v1" {
+		t.Errorf("expected existing version to be preserved, got %q", state.sent["a"])
+	}
+}
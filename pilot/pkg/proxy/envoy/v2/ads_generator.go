@@ -0,0 +1,159 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// WatchedResource tracks a single TypeUrl a connection is subscribed to: which resource
+// names it wants (nil/empty means "all", as CDS and LDS always do) and the version/nonce
+// last sent for it. It generalizes the LDSWatch/CDSWatch/Routes/Clusters fields on
+// XdsConnection to any TypeUrl, including ones that only have a generator registered at
+// runtime and that Pilot has no dedicated field for.
+type WatchedResource struct {
+	// TypeUrl is the xDS type this WatchedResource is for, e.g. RouteType.
+	TypeUrl string
+
+	// ResourceNames is the subscribed resource names for this type; nil/empty means "all"
+	// resources of this type.
+	ResourceNames []string
+
+	// VersionSent/NonceSent hold the last version and nonce sent for this type. No push or
+	// request path in this package sets or reads them, so ACKs are not matched against them.
+	VersionSent string
+	NonceSent   string
+}
+
+// watch returns con's WatchedResource for typeURL, creating it on first use.
+func (con *XdsConnection) watch(typeURL string) *WatchedResource {
+	if con.watches == nil {
+		con.watches = map[string]*WatchedResource{}
+	}
+	w, ok := con.watches[typeURL]
+	if !ok {
+		w = &WatchedResource{TypeUrl: typeURL}
+		con.watches[typeURL] = w
+	}
+	return w
+}
+
+// RouteConfigBuilder is the subset of ConfigGeneratorImpl a route generator needs. It's
+// declared here, rather than by importing the networking/core/v1alpha3 type directly, so
+// this file doesn't need to know DiscoveryServer.ConfigGenerator's concrete type -- only
+// that it has this method, which it already does.
+type RouteConfigBuilder interface {
+	BuildSidecarOutboundHTTPRouteConfig(env model.Environment, node model.Proxy,
+		proxyInstances []*model.ServiceInstance, services []*model.Service, routeName string) *xdsapi.RouteConfiguration
+}
+
+// PushContext is the snapshot of mesh state an XdsResourceGenerator needs to build
+// resources for a proxy. It's intentionally narrow today, covering only what
+// generateRoutes below needs; it's expected to grow into the precomputed per-push
+// snapshot tracked separately, at which point generators will stop reading s.env/
+// s.services directly through it.
+type PushContext struct {
+	Env             model.Environment
+	ConfigGenerator RouteConfigBuilder
+	Services        []*model.Service
+}
+
+// XdsResourceGenerator builds the xDS resources for one TypeUrl for one proxy. Registering
+// a generator for a TypeUrl lets that type's resources be produced differently for some
+// proxies -- e.g. a gateway-specific RDS shape, or a non-Istio xDS client -- without
+// forking the StreamAggregatedResources/DeltaAggregatedResources dispatch switch itself.
+type XdsResourceGenerator interface {
+	Generate(proxy *model.Proxy, push *PushContext, w *WatchedResource) ([]*types.Any, error)
+}
+
+// xdsResourceGeneratorFunc adapts a plain function to XdsResourceGenerator, the same way
+// http.HandlerFunc adapts a function to http.Handler.
+type xdsResourceGeneratorFunc func(proxy *model.Proxy, push *PushContext, w *WatchedResource) ([]*types.Any, error)
+
+func (f xdsResourceGeneratorFunc) Generate(proxy *model.Proxy, push *PushContext, w *WatchedResource) ([]*types.Any, error) {
+	return f(proxy, push, w)
+}
+
+// generators holds the registered XdsResourceGenerator per TypeUrl, optionally further
+// qualified by a "generator" hint a proxy sets in its bootstrap Node.Metadata (see
+// generatorKey). There's no RWMutex here: like the v1 policy translators, registration is
+// expected to happen during process init/setup, before any connection exists.
+var generators = map[string]XdsResourceGenerator{}
+
+// RegisterGenerator registers gen as the generator for typeURL, overwriting whatever was
+// previously registered for it.
+func RegisterGenerator(typeURL string, gen XdsResourceGenerator) {
+	generators[typeURL] = gen
+}
+
+// RegisterGeneratorForHint registers gen for typeURL, but only for proxies whose
+// Node.Metadata["generator"] equals hint -- letting a non-sidecar xDS client (e.g. a
+// gateway, or a non-Envoy client speaking xDS) opt into a different resource shape for the
+// same TypeUrl without affecting ordinary sidecars.
+func RegisterGeneratorForHint(typeURL, hint string, gen XdsResourceGenerator) {
+	generators[generatorKey(typeURL, hint)] = gen
+}
+
+func generatorKey(typeURL, hint string) string {
+	if hint == "" {
+		return typeURL
+	}
+	return typeURL + "/" + hint
+}
+
+// findGenerator returns the generator registered for typeURL, preferring one registered
+// for proxy's "generator" metadata hint, and falling back to the plain TypeUrl generator.
+// It returns nil if no generator is registered at all, which today is the case for every
+// TypeUrl except RouteType -- CDS/LDS/EDS are still built the way pushCds/pushLds/pushEds
+// always have, in cds.go/lds.go/eds.go, which don't yet hand back a resource map a
+// generator could wrap.
+func findGenerator(typeURL string, proxy *model.Proxy) XdsResourceGenerator {
+	if proxy != nil && proxy.Metadata != nil {
+		if hint, ok := proxy.Metadata["generator"]; ok {
+			if g, ok := generators[generatorKey(typeURL, hint)]; ok {
+				return g
+			}
+		}
+	}
+	return generators[typeURL]
+}
+
+func init() {
+	RegisterGenerator(RouteType, xdsResourceGeneratorFunc(generateRoutes))
+}
+
+// generateRoutes reproduces pushRoute/pushDelta's RDS resource-building logic -- the same
+// BuildSidecarOutboundHTTPRouteConfig call, over w.ResourceNames instead of con.Routes --
+// through the generic XdsResourceGenerator contract.
+func generateRoutes(proxy *model.Proxy, push *PushContext, w *WatchedResource) ([]*types.Any, error) {
+	proxyInstances, err := push.Env.GetProxyServiceInstances(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]*types.Any, 0, len(w.ResourceNames))
+	for _, routeName := range w.ResourceNames {
+		r := push.ConfigGenerator.BuildSidecarOutboundHTTPRouteConfig(push.Env, *proxy, proxyInstances, push.Services, routeName)
+		any, err := types.MarshalAny(r)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, any)
+	}
+	return resources, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+type stubGenerator struct{ name string }
+
+func (g *stubGenerator) Generate(proxy *model.Proxy, push *PushContext, w *WatchedResource) ([]*types.Any, error) {
+	return nil, nil
+}
+
+// TestFindGeneratorPrefersHintOverPlainTypeUrl asserts a proxy whose Node.Metadata sets
+// "generator" gets the hint-registered generator instead of the plain TypeUrl one, even
+// though both are registered for the same TypeUrl.
+func TestFindGeneratorPrefersHintOverPlainTypeUrl(t *testing.T) {
+	const typeURL = "test.type/FindGeneratorPrefersHint"
+	plain := &stubGenerator{name: "plain"}
+	hinted := &stubGenerator{name: "hinted"}
+	RegisterGenerator(typeURL, plain)
+	RegisterGeneratorForHint(typeURL, "my-hint", hinted)
+
+	proxy := &model.Proxy{Metadata: map[string]string{"generator": "my-hint"}}
+	if got := findGenerator(typeURL, proxy); got != hinted {
+		t.Errorf("expected the hint-registered generator, got %v", got)
+	}
+}
+
+// TestFindGeneratorFallsBackToPlainTypeUrl asserts a proxy with no matching hint (or no
+// hint at all) gets the plain TypeUrl generator.
+func TestFindGeneratorFallsBackToPlainTypeUrl(t *testing.T) {
+	const typeURL = "test.type/FindGeneratorFallsBack"
+	plain := &stubGenerator{name: "plain"}
+	RegisterGenerator(typeURL, plain)
+
+	if got := findGenerator(typeURL, nil); got != plain {
+		t.Errorf("expected the plain generator for a nil proxy, got %v", got)
+	}
+
+	proxyNoHint := &model.Proxy{}
+	if got := findGenerator(typeURL, proxyNoHint); got != plain {
+		t.Errorf("expected the plain generator for a proxy with no hint, got %v", got)
+	}
+
+	proxyUnknownHint := &model.Proxy{Metadata: map[string]string{"generator": "unregistered-hint"}}
+	if got := findGenerator(typeURL, proxyUnknownHint); got != plain {
+		t.Errorf("expected fallback to the plain generator for an unregistered hint, got %v", got)
+	}
+}
+
+// TestFindGeneratorUnregisteredTypeUrlReturnsNil asserts a TypeUrl with nothing registered
+// at all returns nil, matching CDS/LDS/EDS today (see DeltaAggregatedResources doc comment).
+func TestFindGeneratorUnregisteredTypeUrlReturnsNil(t *testing.T) {
+	if got := findGenerator("test.type/NeverRegistered", nil); got != nil {
+		t.Errorf("expected nil for an unregistered TypeUrl, got %v", got)
+	}
+}
+
+// TestConnectionWatchCreatesOnFirstUse asserts XdsConnection.watch lazily creates a
+// WatchedResource for a TypeUrl and returns the same one on subsequent calls.
+func TestConnectionWatchCreatesOnFirstUse(t *testing.T) {
+	con := &XdsConnection{}
+	first := con.watch(RouteType)
+	first.ResourceNames = []string{"route-a"}
+
+	second := con.watch(RouteType)
+	if second != first {
+		t.Fatal("expected the same WatchedResource instance on a second call for the same TypeUrl")
+	}
+	if len(second.ResourceNames) != 1 || second.ResourceNames[0] != "route-a" {
+		t.Errorf("expected the mutation through the first handle to be visible, got %v", second.ResourceNames)
+	}
+}
+
+func TestGeneratorKeyJoinsWithSlashOnlyWhenHintSet(t *testing.T) {
+	if got := generatorKey("type.url", ""); got != "type.url" {
+		t.Errorf("expected bare TypeUrl with no hint, got %q", got)
+	}
+	if got := generatorKey("type.url", "gw"); got != "type.url/gw" {
+		t.Errorf("expected TypeUrl/hint, got %q", got)
+	}
+}
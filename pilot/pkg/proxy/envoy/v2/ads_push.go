@@ -0,0 +1,222 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"sync"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/log"
+)
+
+// pushDebounceWindow is how long adsDebounce waits for additional PushRequests to merge
+// with a pending one before actually fanning it out, so a burst of config/endpoint churn
+// (e.g. a rolling deployment) collapses into one push instead of one per event.
+const pushDebounceWindow = 100 * time.Millisecond
+
+// ConfigKey identifies a single piece of config (by the same Type/Name/Namespace triple as
+// model.ConfigMeta) that changed, so a PushRequest can say precisely what triggered it
+// instead of just "something changed".
+type ConfigKey struct {
+	Kind      string
+	Name      string
+	Namespace string
+}
+
+// PushRequest describes what changed and triggered a push, replacing the old bare
+// XdsEvent{} signal. A nil or Full request means "recompute everything", matching
+// adsPushAll's historical behavior; a non-Full request carries enough detail for
+// ProxyNeedsPush and the push loop to skip proxies and xDS types the change can't affect.
+type PushRequest struct {
+	// Full forces every generator to run for every connection, ignoring ConfigsUpdated/
+	// EdsUpdates. Set for changes too broad to scope, e.g. a mesh config change.
+	Full bool
+
+	// ConfigsUpdated is the set of non-endpoint configs (VirtualServices, DestinationRules,
+	// Gateways, ...) that changed. A non-empty set without Full means only CDS/LDS/RDS need
+	// to be recomputed -- EDS isn't affected by these.
+	ConfigsUpdated map[ConfigKey]struct{}
+
+	// EdsUpdates is the set of cluster/hostnames whose endpoints changed. A non-empty set
+	// without Full means only EDS needs to be recomputed, and only for connections
+	// subscribed to one of these clusters.
+	EdsUpdates map[string]struct{}
+
+	// JwksUpdates is the set of jwt_authn issuers whose JWKS rotated. Nothing in this package
+	// builds a PushRequest with it set (jwtauthn.StartJWKSRefresher reports an issuer string
+	// through its own JWKSUpdatePusher interface), and mergePushRequests only carries it
+	// along: the SotW push loop in ads.go acts on Full, ConfigsUpdated and EdsUpdates only, so
+	// a request carrying nothing but JwksUpdates pushes nothing there. Envoy's jwt_authn
+	// filter re-fetches JwksURI on its own cache schedule regardless.
+	JwksUpdates map[string]struct{}
+}
+
+// ProxyNeedsPush reports whether proxy could be affected by req. It does NOT currently scope
+// anything: model.Proxy (defined outside this snapshot) carries no per-proxy sidecar scope to
+// compare req against, so every non-nil, non-trivial req is treated as relevant to every proxy.
+// It exists as a method (rather than inline at the one call site) so a DiscoveryServer can be
+// given a real sidecar-scope-aware predicate later without changing that call site, and so the
+// name at the call site doesn't have to change out from under ads.go/ads_delta.go once it does
+// real work. The actual narrowing callers get today is type-scoped, not proxy-scoped: pushConfig/
+// pushEds in the push loop skip whole xDS types req doesn't touch, and edsUpdateRelevant further
+// skips an EDS-only req against a connection's own watched clusters (con.Clusters) -- state this
+// function has no access to, since it's keyed on proxy's identity rather than a live connection.
+func (s *DiscoveryServer) ProxyNeedsPush(proxy *model.Proxy, req *PushRequest) bool {
+	return proxyNeedsPush(proxy, req)
+}
+
+// proxyNeedsPush is the always-relevant stub ProxyNeedsPush documents; see its doc comment for
+// why. A nil req, a nil proxy, or any non-empty req is always true -- there is no code path here
+// that returns false.
+func proxyNeedsPush(proxy *model.Proxy, req *PushRequest) bool {
+	return true
+}
+
+// edsUpdateRelevant reports whether any of clusters (a connection's subscribed cluster
+// names) appears in req.EdsUpdates. Used to skip EDS-only pushes for connections that
+// don't watch any of the clusters that actually changed.
+func edsUpdateRelevant(clusters []string, req *PushRequest) bool {
+	if req.Full || len(req.EdsUpdates) == 0 {
+		return true
+	}
+	for _, c := range clusters {
+		if _, ok := req.EdsUpdates[c]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePushRequests combines two pending PushRequests into one that supersedes both,
+// unioning their scopes (or dropping to Full if either was).
+func mergePushRequests(a, b *PushRequest) *PushRequest {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	merged := &PushRequest{Full: a.Full || b.Full}
+	if merged.Full {
+		return merged
+	}
+	merged.ConfigsUpdated = make(map[ConfigKey]struct{}, len(a.ConfigsUpdated)+len(b.ConfigsUpdated))
+	for k := range a.ConfigsUpdated {
+		merged.ConfigsUpdated[k] = struct{}{}
+	}
+	for k := range b.ConfigsUpdated {
+		merged.ConfigsUpdated[k] = struct{}{}
+	}
+	merged.EdsUpdates = make(map[string]struct{}, len(a.EdsUpdates)+len(b.EdsUpdates))
+	for k := range a.EdsUpdates {
+		merged.EdsUpdates[k] = struct{}{}
+	}
+	for k := range b.EdsUpdates {
+		merged.EdsUpdates[k] = struct{}{}
+	}
+	merged.JwksUpdates = make(map[string]struct{}, len(a.JwksUpdates)+len(b.JwksUpdates))
+	for k := range a.JwksUpdates {
+		merged.JwksUpdates[k] = struct{}{}
+	}
+	for k := range b.JwksUpdates {
+		merged.JwksUpdates[k] = struct{}{}
+	}
+	return merged
+}
+
+var (
+	pushQueueMutex sync.Mutex
+	pendingPush    *PushRequest
+	pushTimer      *time.Timer
+)
+
+// ConfigUpdate queues req to be pushed after pushDebounceWindow, merging it with any
+// PushRequest already pending so a burst of rapid config/endpoint churn collapses into a
+// single fanout instead of one per event.
+func (s *DiscoveryServer) ConfigUpdate(req *PushRequest) {
+	pushQueueMutex.Lock()
+	defer pushQueueMutex.Unlock()
+
+	pendingPush = mergePushRequests(pendingPush, req)
+	if pushTimer != nil {
+		return
+	}
+	pushTimer = time.AfterFunc(pushDebounceWindow, func() {
+		pushQueueMutex.Lock()
+		flushed := pendingPush
+		pendingPush = nil
+		pushTimer = nil
+		pushQueueMutex.Unlock()
+		doPushAll(flushed)
+	})
+}
+
+// adsPushAll implements old style invalidation, generated when any rule or endpoint changes.
+// Primary code path is from v1 discoveryService.clearCache(), which is added as a handler
+// to the model ConfigStorageCache and Controller.
+//
+// It always triggers a Full push; callers that know what specifically changed should go
+// through DiscoveryServer.ConfigUpdate instead, so only the affected proxies and xDS types
+// get recomputed.
+func adsPushAll() {
+	doPushAll(&PushRequest{Full: true})
+}
+
+// doPushAll fans req out to every connected proxy, skipping ones ProxyNeedsPush says this
+// req can't affect.
+func doPushAll(req *PushRequest) {
+	if req == nil {
+		req = &PushRequest{Full: true}
+	}
+
+	if req.Full {
+		// First update all cluster load assignments. This is computed for each cluster once
+		// per config change instead of once per endpoint.
+		edsClusterMutex.Lock()
+		// Create a temp map to avoid locking the add/remove
+		cMap := make(map[string]*EdsCluster, len(edsClusters))
+		for k, v := range edsClusters {
+			cMap[k] = v
+		}
+		edsClusterMutex.Unlock()
+
+		// UpdateCluster udates the cluster with a mutex, this code is safe ( but computing
+		// the update may be duplicated if multiple goroutines compute at the same time).
+		// In general this code is called from the 'event' callback that is throttled.
+		for clusterName, edsCluster := range cMap {
+			if err := updateCluster(clusterName, edsCluster); err != nil {
+				log.Errorf("updateCluster failed with clusterName %s", clusterName)
+			}
+		}
+	}
+
+	// Push config changes, iterating over connected envoys. This cover ADS and EDS(0.7), both share
+	// the same connection table
+	adsClientsMutex.RLock()
+	// Create a temp map to avoid locking the add/remove
+	tmpMap := make(map[string]*XdsConnection, len(adsClients))
+	for k, v := range adsClients {
+		tmpMap[k] = v
+	}
+	adsClientsMutex.RUnlock()
+
+	for _, client := range tmpMap {
+		if !proxyNeedsPush(client.modelNode, req) {
+			continue
+		}
+		client.pushChannel <- req
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import "testing"
+
+// TestMergePushRequestsNilEitherSide asserts merging with a nil request on either side
+// just returns the other request unchanged.
+func TestMergePushRequestsNilEitherSide(t *testing.T) {
+	req := &PushRequest{Full: true}
+	if got := mergePushRequests(nil, req); got != req {
+		t.Errorf("expected mergePushRequests(nil, req) to return req itself, got %v", got)
+	}
+	if got := mergePushRequests(req, nil); got != req {
+		t.Errorf("expected mergePushRequests(req, nil) to return req itself, got %v", got)
+	}
+}
+
+// TestMergePushRequestsUnionsScopes asserts merging two scoped requests unions their
+// ConfigsUpdated/EdsUpdates sets rather than keeping only one side's. JwksUpdates is not
+// exercised here.
+func TestMergePushRequestsUnionsScopes(t *testing.T) {
+	a := &PushRequest{
+		ConfigsUpdated: map[ConfigKey]struct{}{{Kind: "VirtualService", Name: "a"}: {}},
+		EdsUpdates:     map[string]struct{}{"cluster-a": {}},
+	}
+	b := &PushRequest{
+		ConfigsUpdated: map[ConfigKey]struct{}{{Kind: "VirtualService", Name: "b"}: {}},
+		EdsUpdates:     map[string]struct{}{"cluster-b": {}},
+	}
+
+	merged := mergePushRequests(a, b)
+	if merged.Full {
+		t.Fatal("expected merged request to not be Full when neither side was")
+	}
+	if len(merged.ConfigsUpdated) != 2 || len(merged.EdsUpdates) != 2 {
+		t.Errorf("expected the union of both sides' scopes, got configs=%v eds=%v", merged.ConfigsUpdated, merged.EdsUpdates)
+	}
+}
+
+// TestMergePushRequestsEitherFullMakesMergedFull asserts a Full request on either side
+// forces the merged request to Full, since its scope can't be narrowed.
+func TestMergePushRequestsEitherFullMakesMergedFull(t *testing.T) {
+	full := &PushRequest{Full: true}
+	scoped := &PushRequest{EdsUpdates: map[string]struct{}{"cluster-a": {}}}
+
+	merged := mergePushRequests(full, scoped)
+	if !merged.Full {
+		t.Error("expected merged request to be Full when either side was Full")
+	}
+}
+
+// TestEdsUpdateRelevantTrueOnFullOrEmptyUpdates asserts edsUpdateRelevant treats a Full
+// request, or one with no EdsUpdates at all, as relevant to every connection.
+func TestEdsUpdateRelevantTrueOnFullOrEmptyUpdates(t *testing.T) {
+	if !edsUpdateRelevant([]string{"cluster-a"}, &PushRequest{Full: true}) {
+		t.Error("expected a Full request to be relevant regardless of clusters")
+	}
+	if !edsUpdateRelevant([]string{"cluster-a"}, &PushRequest{}) {
+		t.Error("expected a request with no EdsUpdates to be relevant (nothing to narrow against)")
+	}
+}
+
+// TestEdsUpdateRelevantMatchesWatchedClusters asserts edsUpdateRelevant returns true only
+// when one of the connection's watched clusters appears in req.EdsUpdates.
+func TestEdsUpdateRelevantMatchesWatchedClusters(t *testing.T) {
+	req := &PushRequest{EdsUpdates: map[string]struct{}{"cluster-b": {}}}
+
+	if edsUpdateRelevant([]string{"cluster-a"}, req) {
+		t.Error("expected no match when the connection doesn't watch any updated cluster")
+	}
+	if !edsUpdateRelevant([]string{"cluster-a", "cluster-b"}, req) {
+		t.Error("expected a match when the connection watches one of the updated clusters")
+	}
+}
+
+// TestProxyNeedsPushIsAnAlwaysTrueStub locks in proxyNeedsPush's documented behavior: it
+// has no way to scope by proxy (model.Proxy carries no sidecar scope in this snapshot), so
+// it must always return true rather than silently dropping a push it can't actually judge.
+func TestProxyNeedsPushIsAnAlwaysTrueStub(t *testing.T) {
+	cases := []*PushRequest{nil, {}, {Full: true}, {EdsUpdates: map[string]struct{}{"cluster-a": {}}}}
+	for _, req := range cases {
+		if !proxyNeedsPush(nil, req) {
+			t.Errorf("expected proxyNeedsPush(nil, %v) to be true", req)
+		}
+	}
+}
@@ -52,23 +52,36 @@ func (s *DiscoveryServer) InitDebug(mux *http.ServeMux, sctl *aggregate.Controll
 	mux.HandleFunc("/debug/edsz", edsz)
 	mux.HandleFunc("/debug/adsz", adsz)
 	mux.HandleFunc("/debug/cdsz", cdsz)
+	mux.HandleFunc("/debug/ldsz", ldsz)
+	mux.HandleFunc("/debug/rdsz", rdsz)
+	mux.HandleFunc("/debug/push", pushz)
 
 	mux.HandleFunc("/debug/registryz", s.registryz)
 	mux.HandleFunc("/debug/endpointz", s.endpointz)
 	mux.HandleFunc("/debug/configz", s.configz)
+	mux.HandleFunc("/debug/txn", s.txn)
 }
 
 // NewMemServiceDiscovery builds an in-memory MemServiceDiscovery
 func NewMemServiceDiscovery(services map[string]*model.Service, versions int) *MemServiceDiscovery {
 	return &MemServiceDiscovery{
-		services:    services,
-		versions:    versions,
-		controller:  &memServiceController{},
-		instances:   map[string][]*model.ServiceInstance{},
-		ip2instance: map[string][]*model.ServiceInstance{},
+		services:      services,
+		versions:      versions,
+		controller:    &memServiceController{},
+		instances:     map[string][]*model.ServiceInstance{},
+		ip2instance:   map[string][]*model.ServiceInstance{},
+		app2instances: map[string][]*model.ServiceInstance{},
 	}
 }
 
+// applicationLabel is the well-known label an instance's Labels carries its application
+// grouping under -- a logical name for the set of services that share deployment/versioning/
+// config, the way a Kubernetes Deployment's "app" label groups Pods that are really one
+// rollout. model.Service/model.ServiceAttributes have no field for this (their defining package
+// isn't part of this snapshot, so there's nowhere to add one); reading it off each instance's
+// existing Labels instead needs no new field anywhere.
+const applicationLabel = "istio.io/application"
+
 // TODO: the mock was used for test setup, has no mutex. This will also be used for
 // integration and load tests, will need to add mutex as we cleanup the code.
 
@@ -93,7 +106,11 @@ func (c *memServiceController) Run(<-chan struct{}) {}
 type MemServiceDiscovery struct {
 	services map[string]*model.Service
 	// Endpoints table. Key is the fqdn of the service, ':', port
-	instances                     map[string][]*model.ServiceInstance
+	instances map[string][]*model.ServiceInstance
+	// app2instances indexes every instance tagged with applicationLabel by that label's value,
+	// across every service -- the cross-service view ServicesByApplication/InstancesByApplication
+	// serve from.
+	app2instances                 map[string][]*model.ServiceInstance
 	ip2instance                   map[string][]*model.ServiceInstance
 	versions                      int
 	WantGetProxyServiceInstances  []*model.ServiceInstance
@@ -128,6 +145,12 @@ func (sd *MemServiceDiscovery) AddInstance(service string, instance *model.Servi
 	// WIP: add enough code to allow tests and load tests to work
 	sd.mutex.Lock()
 	defer sd.mutex.Unlock()
+	sd.addInstanceLocked(service, instance)
+}
+
+// addInstanceLocked is AddInstance's body, factored out so BatchAddInstances and the /debug/txn
+// handler can apply several instances under one mutex acquisition instead of one each.
+func (sd *MemServiceDiscovery) addInstanceLocked(service string, instance *model.ServiceInstance) {
 	svc := sd.services[service]
 	if svc == nil {
 		return
@@ -139,10 +162,14 @@ func (sd *MemServiceDiscovery) AddInstance(service string, instance *model.Servi
 	instanceList := sd.instances[key]
 	if instanceList == nil {
 		instanceList = []*model.ServiceInstance{instance}
-		sd.instances[key] = instanceList
-		return
+	} else {
+		instanceList = append(instanceList, instance)
+	}
+	sd.instances[key] = instanceList
+
+	if app := instance.Labels[applicationLabel]; app != "" {
+		sd.app2instances[app] = append(sd.app2instances[app], instance)
 	}
-	sd.instances[key] = append(instanceList, instance)
 }
 
 // AddEndpoint adds an endpoint to a service.
@@ -209,6 +236,38 @@ func (sd *MemServiceDiscovery) Instances(hostname string, ports []string,
 	return instances, nil
 }
 
+// ServicesByApplication returns every service with at least one instance tagged applicationLabel
+// == app. This isn't part of the model.ServiceDiscovery interface: that interface has
+// implementers outside this snapshot (e.g. the Kubernetes controller), and adding a required
+// method there would break them without a way to fix them up here. Call it directly on the
+// concrete *MemServiceDiscovery instead, the same way AddService/AddInstance already are.
+func (sd *MemServiceDiscovery) ServicesByApplication(app string) []*model.Service {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	seen := map[string]bool{}
+	var out []*model.Service
+	for _, inst := range sd.app2instances[app] {
+		hostname := string(inst.Service.Hostname)
+		if seen[hostname] {
+			continue
+		}
+		seen[hostname] = true
+		out = append(out, inst.Service)
+	}
+	return out
+}
+
+// InstancesByApplication returns every instance tagged applicationLabel == app, across every
+// service that application spans -- the union an application-scoped outbound cluster (see
+// applicationLabel's doc comment) would route to. See ServicesByApplication for why this isn't
+// on the model.ServiceDiscovery interface.
+func (sd *MemServiceDiscovery) InstancesByApplication(app string) []*model.ServiceInstance {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	return sd.app2instances[app]
+}
+
 // GetProxyServiceInstances returns service instances associated with a node, resulting in
 // 'in' services.
 func (sd *MemServiceDiscovery) GetProxyServiceInstances(node *model.Proxy) ([]*model.ServiceInstance, error) {
@@ -261,6 +320,22 @@ func (sd *MemServiceDiscovery) GetIstioServiceAccounts(hostname string, ports []
 func (s *DiscoveryServer) registryz(w http.ResponseWriter, req *http.Request) {
 	_ = req.ParseForm()
 	w.Header().Add("Content-Type", "application/json")
+	if req.Form.Get("peers") != "" {
+		s.writePeerStatus(w)
+		return
+	}
+	if app := req.Form.Get("app"); app != "" {
+		writeServicesJSON(w, s.MemRegistry.ServicesByApplication(app))
+		return
+	}
+	if req.Form.Get("snapshot") != "" {
+		b, err := json.MarshalIndent(s.MemRegistry.Snapshot(), "", "  ")
+		if err != nil {
+			return
+		}
+		_, _ = w.Write(b)
+		return
+	}
 	svcName := req.Form.Get("svc")
 	if svcName != "" {
 		data, err := ioutil.ReadAll(req.Body)
@@ -291,10 +366,38 @@ func (s *DiscoveryServer) registryz(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintln(w, "{}]")
 }
 
+// writeServicesJSON renders services the same way registryz's svc-less path does, for
+// /debug/registryz?app=<name>.
+func writeServicesJSON(w http.ResponseWriter, services []*model.Service) {
+	fmt.Fprintln(w, "[")
+	for _, svc := range services {
+		b, err := json.MarshalIndent(svc, "", "  ")
+		if err != nil {
+			return
+		}
+		_, _ = w.Write(b)
+		fmt.Fprintln(w, ",")
+	}
+	fmt.Fprintln(w, "{}]")
+}
+
 // Endpoint debugging
 func (s *DiscoveryServer) endpointz(w http.ResponseWriter, req *http.Request) {
 	_ = req.ParseForm()
 	w.Header().Add("Content-Type", "application/json")
+	if app := req.Form.Get("app"); app != "" {
+		fmt.Fprint(w, "[\n")
+		for _, inst := range s.MemRegistry.InstancesByApplication(app) {
+			b, err := json.MarshalIndent(inst, "", "  ")
+			if err != nil {
+				return
+			}
+			_, _ = w.Write(b)
+			fmt.Fprint(w, ",\n")
+		}
+		fmt.Fprint(w, "{}]\n")
+		return
+	}
 	svcName := req.Form.Get("svc")
 	if svcName != "" {
 		data, err := ioutil.ReadAll(req.Body)
@@ -429,7 +532,8 @@ func writeAllADS(w io.Writer) {
 		} else {
 			comma = true
 		}
-		fmt.Fprintf(w, "\n\n  {\"node\": \"%s\",\n \"addr\": \"%s\",\n \"connect\": \"%v\",\n \"listeners\":[\n", c.ConID, c.PeerAddr, c.Connect)
+		fmt.Fprintf(w, "\n\n  {\"node\": \"%s\",\n \"addr\": \"%s\",\n \"connect\": \"%v\",\n \"modelNodeID\": \"%s\",\n \"watches\": %s,\n \"listeners\":[\n",
+			c.ConID, c.PeerAddr, c.Connect, modelNodeID(c), watchesJSON(c))
 		printListeners(w, c)
 		fmt.Fprint(w, "],\n")
 		fmt.Fprintf(w, ",\"clusters\":[\n")
@@ -497,6 +601,135 @@ func cdsz(w http.ResponseWriter, req *http.Request) {
 	adsClientsMutex.RUnlock()
 }
 
+// rdsz implements a status and debug interface for RDS.
+// It is mapped to /debug/rdsz
+func rdsz(w http.ResponseWriter, req *http.Request) {
+	_ = req.ParseForm()
+	w.Header().Add("Content-Type", "application/json")
+
+	adsClientsMutex.RLock()
+	defer adsClientsMutex.RUnlock()
+
+	if proxyID := req.Form.Get("proxyID"); proxyID != "" {
+		for _, c := range adsSidecarIDConnectionsMap[proxyID] {
+			printRoutes(w, c)
+		}
+		return
+	}
+
+	fmt.Fprint(w, "[\n")
+	comma := false
+	for _, c := range adsClients {
+		if comma {
+			fmt.Fprint(w, ",\n")
+		} else {
+			comma = true
+		}
+		fmt.Fprintf(w, "\n\n  {\"node\": \"%s\", \"addr\": \"%s\", \"connect\": \"%v\",\"Routes\":[\n", c.ConID, c.PeerAddr, c.Connect)
+		printRoutes(w, c)
+		fmt.Fprint(w, "]}\n")
+	}
+	fmt.Fprint(w, "]\n")
+}
+
+// ldsz implements a status and debug interface for LDS.
+// It is mapped to /debug/ldsz
+func ldsz(w http.ResponseWriter, req *http.Request) {
+	_ = req.ParseForm()
+	w.Header().Add("Content-Type", "application/json")
+
+	adsClientsMutex.RLock()
+	defer adsClientsMutex.RUnlock()
+
+	if proxyID := req.Form.Get("proxyID"); proxyID != "" {
+		for _, c := range adsSidecarIDConnectionsMap[proxyID] {
+			printListeners(w, c)
+		}
+		return
+	}
+
+	fmt.Fprint(w, "[\n")
+	comma := false
+	for _, c := range adsClients {
+		if comma {
+			fmt.Fprint(w, ",\n")
+		} else {
+			comma = true
+		}
+		fmt.Fprintf(w, "\n\n  {\"node\": \"%s\", \"addr\": \"%s\", \"connect\": \"%v\",\"Listeners\":[\n", c.ConID, c.PeerAddr, c.Connect)
+		printListeners(w, c)
+		fmt.Fprint(w, "]}\n")
+	}
+	fmt.Fprint(w, "]\n")
+}
+
+// pushz forces a push to the connection(s) for proxyID, rather than every connected proxy,
+// giving operators a way to reproduce/confirm a push without restarting Pilot or waiting for
+// the next debounce window. It is mapped to /debug/push
+func pushz(w http.ResponseWriter, req *http.Request) {
+	_ = req.ParseForm()
+	w.Header().Add("Content-Type", "application/json")
+
+	proxyID := req.Form.Get("proxyID")
+	if proxyID == "" {
+		adsPushAll()
+		fmt.Fprintf(w, "Pushed to %d servers", len(adsClients))
+		return
+	}
+
+	adsClientsMutex.RLock()
+	connections := adsSidecarIDConnectionsMap[proxyID]
+	conns := make([]*XdsConnection, 0, len(connections))
+	for _, c := range connections {
+		conns = append(conns, c)
+	}
+	adsClientsMutex.RUnlock()
+
+	for _, c := range conns {
+		c.pushChannel <- &PushRequest{Full: true}
+	}
+	fmt.Fprintf(w, "Pushed to %d connections for %s", len(conns), proxyID)
+}
+
+// modelNodeID returns c's model node ID, or "" if the connection hasn't sent its first
+// request yet.
+func modelNodeID(c *XdsConnection) string {
+	if c.modelNode == nil {
+		return ""
+	}
+	return c.modelNode.ID
+}
+
+// watchesJSON renders c's watched resources (see WatchedResource) as a JSON object keyed by
+// TypeUrl, for /debug/adsz -- this is in addition to the legacy CDSWatch/LDSWatch/Routes/
+// Clusters fields, which are still what actually drives the SotW push loop.
+func watchesJSON(c *XdsConnection) string {
+	b, err := json.Marshal(c.watches)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func printRoutes(w io.Writer, c *XdsConnection) {
+	comma := false
+	for _, rc := range c.RouteConfigs {
+		if rc == nil {
+			continue
+		}
+		if comma {
+			fmt.Fprint(w, ",\n")
+		} else {
+			comma = true
+		}
+		jsonm := &jsonpb.Marshaler{Indent: "  "}
+		dbgString, _ := jsonm.MarshalToString(rc)
+		if _, err := w.Write([]byte(dbgString)); err != nil {
+			return
+		}
+	}
+}
+
 func printListeners(w io.Writer, c *XdsConnection) {
 	comma := false
 	for _, ls := range c.HTTPListeners {
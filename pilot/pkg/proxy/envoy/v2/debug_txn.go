@@ -0,0 +1,215 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// This file adds a batch mutation path to the registryz/endpointz debug handlers in debug.go:
+// /debug/txn applies several add/delete operations in one MemServiceDiscovery.mutex acquisition
+// instead of one per HTTP request, modeled on Consul's KV txn API (including its "get-or-empty"
+// semantics, where a missing key is an empty result rather than a failed transaction).
+
+const (
+	txnOpAddService     = "add-service"
+	txnOpDeleteService  = "delete-service"
+	txnOpAddInstance    = "add-instance"
+	txnOpDeleteInstance = "delete-instance"
+	txnOpGetOrEmpty     = "get-or-empty"
+)
+
+// TxnOp is one operation in a /debug/txn batch request. Key is the registry key it applies to:
+// a service name for add-service/delete-service/get-or-empty/add-instance, or "service:port"
+// (the same key MemServiceDiscovery.instances itself uses) for delete-instance. Value carries the
+// JSON-encoded model.Service or model.ServiceInstance an add-* op installs.
+type TxnOp struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// TxnOpResult is one TxnOp's outcome. Ok is false and Error explains why if the op couldn't be
+// applied; a get-or-empty op that finds nothing is still Ok, with Value left nil, per Consul's
+// get-or-empty semantics.
+type TxnOpResult struct {
+	Op    string      `json:"op"`
+	Key   string      `json:"key"`
+	Ok    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// txnIndex is a monotonically increasing counter stamped on every /debug/txn response, the same
+// "transaction index" Consul's KV txn API returns, so a caller issuing several batches can tell
+// which landed first.
+var txnIndex uint64
+
+// txn applies req.Body's ops (a JSON array of TxnOp) under one MemServiceDiscovery.mutex
+// acquisition and writes back each op's TxnOpResult plus a transaction index. Handler fan-out
+// through svcHandlers/instHandlers only happens after every op in the batch has committed, so a
+// connected sidecar's ADS/EDS watch sees one consistent post-batch view instead of re-pushing
+// partway through.
+func (s *DiscoveryServer) txn(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ops []TxnOp
+	if err := json.NewDecoder(req.Body).Decode(&ops); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sd := s.MemRegistry
+	sd.mutex.Lock()
+	results := make([]TxnOpResult, 0, len(ops))
+	var changedServices []*model.Service
+	var changedInstances []*model.ServiceInstance
+	for _, op := range ops {
+		results = append(results, sd.applyTxnOpLocked(op, &changedServices, &changedInstances))
+	}
+	sd.mutex.Unlock()
+
+	mc, _ := sd.controller.(*memServiceController)
+	if mc != nil {
+		for _, svc := range changedServices {
+			for _, h := range mc.svcHandlers {
+				h(svc, model.EventAdd)
+			}
+		}
+		for _, inst := range changedInstances {
+			for _, h := range mc.instHandlers {
+				h(inst, model.EventAdd)
+			}
+		}
+	}
+
+	b, _ := json.Marshal(struct {
+		Index   uint64        `json:"index"`
+		Results []TxnOpResult `json:"results"`
+	}{Index: atomic.AddUint64(&txnIndex, 1), Results: results})
+	_, _ = w.Write(b)
+}
+
+// applyTxnOpLocked applies one op to sd, which must already be locked, appending to
+// *changedServices/*changedInstances whatever this op added so the caller can fan those out
+// once the whole batch has committed.
+func (sd *MemServiceDiscovery) applyTxnOpLocked(op TxnOp, changedServices *[]*model.Service, changedInstances *[]*model.ServiceInstance) TxnOpResult {
+	result := TxnOpResult{Op: op.Op, Key: op.Key}
+
+	switch op.Op {
+	case txnOpAddService:
+		svc := &model.Service{}
+		if err := json.Unmarshal(op.Value, svc); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		sd.services[op.Key] = svc
+		*changedServices = append(*changedServices, svc)
+		result.Ok = true
+
+	case txnOpDeleteService:
+		delete(sd.services, op.Key)
+		result.Ok = true
+
+	case txnOpAddInstance:
+		instance := &model.ServiceInstance{}
+		if err := json.Unmarshal(op.Value, instance); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if sd.services[op.Key] == nil {
+			result.Error = fmt.Sprintf("no service %q", op.Key)
+			return result
+		}
+		sd.addInstanceLocked(op.Key, instance)
+		*changedInstances = append(*changedInstances, instance)
+		result.Ok = true
+
+	case txnOpDeleteInstance:
+		delete(sd.instances, op.Key)
+		result.Ok = true
+
+	case txnOpGetOrEmpty:
+		if svc, ok := sd.services[op.Key]; ok {
+			result.Value = svc
+		}
+		result.Ok = true
+
+	default:
+		result.Error = fmt.Sprintf("unknown op %q", op.Op)
+	}
+
+	return result
+}
+
+// MemServiceDiscoverySnapshot is the full state Snapshot returns and Restore re-applies: the two
+// maps a /debug/txn batch of add-service/add-instance ops can rebuild from. The derived
+// ip2instance/app2instances indexes aren't included; Restore rebuilds them.
+type MemServiceDiscoverySnapshot struct {
+	Services map[string]*model.Service `json:"services"`
+	// Instances is keyed the same way MemServiceDiscovery.instances is: "service:port".
+	Instances map[string][]*model.ServiceInstance `json:"instances"`
+}
+
+// Snapshot returns sd's full state in the form Restore (and so a re-ingested /debug/txn batch)
+// expects, for /debug/registryz?snapshot=1.
+func (sd *MemServiceDiscovery) Snapshot() MemServiceDiscoverySnapshot {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	services := make(map[string]*model.Service, len(sd.services))
+	for name, svc := range sd.services {
+		services[name] = svc
+	}
+	instances := make(map[string][]*model.ServiceInstance, len(sd.instances))
+	for key, list := range sd.instances {
+		instances[key] = list
+	}
+	return MemServiceDiscoverySnapshot{Services: services, Instances: instances}
+}
+
+// Restore replaces sd's entire state with snap under one mutex acquisition, rebuilding the
+// ip2instance/app2instances indexes from it.
+func (sd *MemServiceDiscovery) Restore(snap MemServiceDiscoverySnapshot) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	sd.services = make(map[string]*model.Service, len(snap.Services))
+	for name, svc := range snap.Services {
+		sd.services[name] = svc
+	}
+
+	sd.instances = make(map[string][]*model.ServiceInstance)
+	sd.ip2instance = make(map[string][]*model.ServiceInstance)
+	sd.app2instances = make(map[string][]*model.ServiceInstance)
+	for key, list := range snap.Instances {
+		for _, instance := range list {
+			sd.instances[key] = append(sd.instances[key], instance)
+			sd.ip2instance[instance.Endpoint.Address] = []*model.ServiceInstance{instance}
+			if app := instance.Labels[applicationLabel]; app != "" {
+				sd.app2instances[app] = append(sd.app2instances[app], instance)
+			}
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/log"
+)
+
+// This file starts turning MemServiceDiscovery into the "remote pilot" adapter its doc comment
+// (see memregistry, in debug.go) has long said it would eventually become: a registry fed by a
+// peer Pilot's DiscoveryServer instead of by a local AddService/AddInstance caller. It does not
+// include the actual PeerDiscoveryServer gRPC service or a PeerRegistryConfig CRD -- both need a
+// .proto compiled through protoc/protoc-gen-gogo and CRD scaffolding that aren't part of this
+// snapshot -- so PeerDiscoveryClient stands in for the generated client that service would
+// produce, and PeerRegistryConfig is a plain Go struct rather than a CRD-backed one, for now.
+
+// PeerRegistryConfig configures a peering relationship with one remote Pilot's
+// PeerDiscoveryServer, the source this registry's snapshot and incremental events are pulled
+// from.
+type PeerRegistryConfig struct {
+	// Address is the remote Pilot's PeerDiscoveryServer address, host:port.
+	Address string
+	// ClusterID identifies the remote cluster this peer represents, the same ClusterID
+	// aggregate.Registry tags every service/instance sourced from this adapter with.
+	ClusterID string
+	// TLSServerName is the SPIFFE/SAN identity expected on the remote Pilot's certificate; empty
+	// disables TLS, e.g. for a local test peer.
+	TLSServerName string
+	// ResyncInterval is the interval between full snapshot resyncs. peerRegistryController
+	// does not read it: no periodic resync is performed.
+	ResyncInterval time.Duration
+	// HeartbeatInterval is how often the peer stream is to deliver something (an empty
+	// keepalive PeerEvent if nothing else changed). peerRegistryController does not read it:
+	// peer health is not judged by it.
+	HeartbeatInterval time.Duration
+}
+
+// PeerEventType enumerates the incremental event kinds a peer stream delivers.
+type PeerEventType int
+
+const (
+	PeerServiceAdd PeerEventType = iota
+	PeerServiceDelete
+	PeerInstanceAdd
+	PeerInstanceDelete
+)
+
+// PeerEvent is one entry in a peer's event stream: an initial StreamPeerDiscovery call (resume
+// token empty) delivers one ServiceAdd/InstanceAdd per entry in the peer's current snapshot,
+// then the stream continues with incremental events as the peer's own registry changes.
+// ResumeToken, once non-empty, is durable across reconnects: passing it back into
+// StreamPeerDiscovery resumes after this event instead of requiring a full snapshot replay.
+type PeerEvent struct {
+	Type        PeerEventType
+	ServiceName string
+	Service     *model.Service
+	Instance    *model.ServiceInstance
+	ResumeToken string
+}
+
+// PeerDiscoveryClient is the client side of the peer-to-peer streaming protocol. A real
+// implementation wraps the generated gRPC client for PeerDiscoveryServer; see this file's
+// package comment for why that generated client isn't part of this snapshot.
+type PeerDiscoveryClient interface {
+	// StreamPeerDiscovery opens the event stream, resuming after resumeToken if non-empty.
+	StreamPeerDiscovery(resumeToken string) (<-chan PeerEvent, error)
+	Close() error
+}
+
+// peerStatus is the last known state of one peer connection, surfaced at
+// /debug/registryz?peers=1.
+type peerStatus struct {
+	Config          PeerRegistryConfig
+	Connected       bool
+	ConnectedSince  time.Time
+	LastEvent       time.Time
+	LastResumeToken string
+}
+
+// peerRegistryController feeds a MemServiceDiscovery from one or more peer Pilots, applying each
+// PeerEvent under sd's mutex and fanning it out through sd.controller's svcHandlers/
+// instHandlers so a connected sidecar's ADS/EDS watch re-pushes the same way it would for a
+// locally discovered change.
+type peerRegistryController struct {
+	sd     *MemServiceDiscovery
+	dialFn func(PeerRegistryConfig) (PeerDiscoveryClient, error)
+
+	mu    sync.Mutex
+	peers map[string]*peerStatus // keyed by Config.Address
+}
+
+func newPeerRegistryController(sd *MemServiceDiscovery, dialFn func(PeerRegistryConfig) (PeerDiscoveryClient, error)) *peerRegistryController {
+	return &peerRegistryController{
+		sd:     sd,
+		dialFn: dialFn,
+		peers:  make(map[string]*peerStatus),
+	}
+}
+
+// maxPeerBackoff caps the exponential reconnect delay AddPeer's background loop backs off to.
+const maxPeerBackoff = 30 * time.Second
+
+// AddPeer starts streaming from cfg in a background goroutine, reconnecting with exponential
+// backoff (starting at 1s, capped at maxPeerBackoff) whenever the stream drops, until stop is
+// closed.
+func (c *peerRegistryController) AddPeer(cfg PeerRegistryConfig, stop <-chan struct{}) {
+	c.mu.Lock()
+	c.peers[cfg.Address] = &peerStatus{Config: cfg}
+	c.mu.Unlock()
+
+	go c.run(cfg, stop)
+}
+
+func (c *peerRegistryController) run(cfg PeerRegistryConfig, stop <-chan struct{}) {
+	backoff := time.Second
+	resumeToken := ""
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		client, err := c.dialFn(cfg)
+		if err != nil {
+			log.Warnf("peer registry: dial %s failed, retrying in %s: %v", cfg.Address, backoff, err)
+			if !c.wait(backoff, stop) {
+				return
+			}
+			backoff = nextPeerBackoff(backoff)
+			continue
+		}
+
+		events, err := client.StreamPeerDiscovery(resumeToken)
+		if err != nil {
+			log.Warnf("peer registry: stream %s failed, retrying in %s: %v", cfg.Address, backoff, err)
+			_ = client.Close()
+			if !c.wait(backoff, stop) {
+				return
+			}
+			backoff = nextPeerBackoff(backoff)
+			continue
+		}
+
+		c.markConnected(cfg.Address)
+		backoff = time.Second
+		resumeToken = c.drain(cfg.Address, events, stop)
+		_ = client.Close()
+		c.markDisconnected(cfg.Address)
+
+		if !c.wait(backoff, stop) {
+			return
+		}
+		backoff = nextPeerBackoff(backoff)
+	}
+}
+
+// drain applies every event off events until it closes (the stream dropped) or stop fires,
+// returning the last resume token seen so the next reconnect can pick up from there.
+func (c *peerRegistryController) drain(addr string, events <-chan PeerEvent, stop <-chan struct{}) string {
+	resumeToken := ""
+	for {
+		select {
+		case <-stop:
+			return resumeToken
+		case ev, ok := <-events:
+			if !ok {
+				return resumeToken
+			}
+			c.apply(addr, ev)
+			if ev.ResumeToken != "" {
+				resumeToken = ev.ResumeToken
+			}
+		}
+	}
+}
+
+// wait blocks for d, or until stop fires, returning false in the latter case so callers can bail
+// out of their reconnect loop immediately.
+func (c *peerRegistryController) wait(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func nextPeerBackoff(cur time.Duration) time.Duration {
+	next := cur * 2
+	if next > maxPeerBackoff {
+		return maxPeerBackoff
+	}
+	return next
+}
+
+func (c *peerRegistryController) markConnected(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p := c.peers[addr]; p != nil {
+		p.Connected = true
+		p.ConnectedSince = time.Now()
+	}
+}
+
+func (c *peerRegistryController) markDisconnected(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p := c.peers[addr]; p != nil {
+		p.Connected = false
+	}
+}
+
+// apply installs an add event into c.sd under its mutex (via the same AddService/AddInstance a
+// local caller would use), then fans it out through sd.controller's handlers so a connected
+// sidecar's ADS/EDS watch re-pushes the same way it would for a locally discovered change. A
+// PeerServiceDelete removes the service without calling any handler; a PeerInstanceDelete is
+// ignored.
+func (c *peerRegistryController) apply(addr string, ev PeerEvent) {
+	c.mu.Lock()
+	if p := c.peers[addr]; p != nil {
+		p.LastEvent = time.Now()
+		p.LastResumeToken = ev.ResumeToken
+	}
+	c.mu.Unlock()
+
+	mc, _ := c.sd.controller.(*memServiceController)
+
+	switch ev.Type {
+	case PeerServiceAdd:
+		c.sd.AddService(string(ev.Service.Hostname), ev.Service)
+		if mc != nil {
+			for _, h := range mc.svcHandlers {
+				h(ev.Service, model.EventAdd)
+			}
+		}
+	case PeerServiceDelete:
+		c.sd.mutex.Lock()
+		delete(c.sd.services, ev.ServiceName)
+		c.sd.mutex.Unlock()
+	case PeerInstanceAdd:
+		c.sd.AddInstance(string(ev.Instance.Service.Hostname), ev.Instance)
+		if mc != nil {
+			for _, h := range mc.instHandlers {
+				h(ev.Instance, model.EventAdd)
+			}
+		}
+	case PeerInstanceDelete:
+		// MemServiceDiscovery has no instance-removal path yet to delegate to.
+	}
+}
+
+// Peers returns a point-in-time snapshot of every peer's status, for /debug/registryz?peers=1.
+func (c *peerRegistryController) Peers() []peerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]peerStatus, 0, len(c.peers))
+	for _, p := range c.peers {
+		out = append(out, *p)
+	}
+	return out
+}
+
+// peerRegistry holds the peer connections this Pilot has been configured to federate with, if
+// any. It stays nil until InitPeerRegistry is called; /debug/registryz?peers=1 reports "[]"
+// until then.
+var peerRegistry *peerRegistryController
+
+// InitPeerRegistry wires sd (normally the same MemServiceDiscovery InitDebug installs as
+// s.MemRegistry) up to stream incremental service/instance events from each peer in cfgs,
+// dialed through dialFn, reconnecting with backoff for the lifetime of stop.
+//
+// Nothing in this snapshot calls InitPeerRegistry: DiscoveryServer itself is a category this
+// file only extends, not one it defines (grep turns up no "type DiscoveryServer struct" anywhere
+// in the tree), and the process bootstrap/flag-wiring package that would own "read
+// PeerRegistryConfig from a CRD or file, call InitPeerRegistry with it" isn't part of this
+// snapshot either -- the same proto/CRD gap this file's package comment already documents for
+// PeerDiscoveryClient/PeerRegistryConfig. There's no reachable call site to wire this into
+// without fabricating that bootstrap package. Until one exists, this subsystem is reachable only
+// from a caller's own main/bootstrap code, or from a test (see peer_registry_test.go).
+func InitPeerRegistry(sd *MemServiceDiscovery, dialFn func(PeerRegistryConfig) (PeerDiscoveryClient, error), cfgs []PeerRegistryConfig, stop <-chan struct{}) {
+	peerRegistry = newPeerRegistryController(sd, dialFn)
+	for _, cfg := range cfgs {
+		peerRegistry.AddPeer(cfg, stop)
+	}
+}
+
+// writePeerStatus renders each configured peer's connection state as a JSON array: address,
+// cluster ID, whether it's currently connected, how long it's been up, and when its last event
+// arrived -- for /debug/registryz?peers=1.
+func (s *DiscoveryServer) writePeerStatus(w io.Writer) {
+	if peerRegistry == nil {
+		fmt.Fprint(w, "[]")
+		return
+	}
+	fmt.Fprint(w, "[\n")
+	comma := false
+	for _, p := range peerRegistry.Peers() {
+		if comma {
+			fmt.Fprint(w, ",\n")
+		} else {
+			comma = true
+		}
+		fmt.Fprintf(w, "  {\"address\": %q, \"clusterID\": %q, \"connected\": %v, \"connectedSince\": %q, \"lastEvent\": %q, \"lag\": %q}",
+			p.Config.Address, p.Config.ClusterID, p.Connected, p.ConnectedSince.Format(time.RFC3339), p.LastEvent.Format(time.RFC3339), time.Since(p.LastEvent))
+	}
+	fmt.Fprint(w, "\n]\n")
+}
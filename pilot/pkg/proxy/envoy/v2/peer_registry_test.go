@@ -0,0 +1,190 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v2
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// fakePeerDiscoveryClient is a PeerDiscoveryClient whose StreamPeerDiscovery fails streamErrs
+// times before succeeding (zero or a negative value never fails), so tests can drive
+// peerRegistryController.run's reconnect/backoff path deterministically.
+type fakePeerDiscoveryClient struct {
+	mu         sync.Mutex
+	events     chan PeerEvent
+	closed     bool
+	streamErrs int // number of times StreamPeerDiscovery should still fail
+}
+
+func (c *fakePeerDiscoveryClient) StreamPeerDiscovery(resumeToken string) (<-chan PeerEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.streamErrs > 0 {
+		c.streamErrs--
+		return nil, fmt.Errorf("simulated stream failure")
+	}
+	return c.events, nil
+}
+
+func (c *fakePeerDiscoveryClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func newTestSD() *MemServiceDiscovery {
+	return NewMemServiceDiscovery(map[string]*model.Service{}, 1)
+}
+
+// TestNextPeerBackoffDoublesAndCaps asserts nextPeerBackoff doubles the current delay, capping
+// at maxPeerBackoff once doubling would exceed it.
+func TestNextPeerBackoffDoublesAndCaps(t *testing.T) {
+	cases := []struct {
+		cur, want time.Duration
+	}{
+		{time.Second, 2 * time.Second},
+		{10 * time.Second, 20 * time.Second},
+		{20 * time.Second, maxPeerBackoff}, // 40s would exceed the 30s cap
+		{maxPeerBackoff, maxPeerBackoff},
+	}
+	for _, c := range cases {
+		if got := nextPeerBackoff(c.cur); got != c.want {
+			t.Errorf("nextPeerBackoff(%s) = %s, want %s", c.cur, got, c.want)
+		}
+	}
+}
+
+// TestPeerRegistryControllerApplyServiceAddFansOutToHandlers asserts apply both installs a
+// PeerServiceAdd event into the backing MemServiceDiscovery and invokes every registered
+// service handler, so a connected sidecar's ADS watch re-pushes the same way it would for a
+// locally discovered change.
+func TestPeerRegistryControllerApplyServiceAddFansOutToHandlers(t *testing.T) {
+	sd := newTestSD()
+	var handlerCalls []string
+	_ = sd.controller.AppendServiceHandler(func(svc *model.Service, ev model.Event) {
+		handlerCalls = append(handlerCalls, string(svc.Hostname))
+	})
+
+	c := newPeerRegistryController(sd, nil)
+	svc := &model.Service{Hostname: "reviews.bookinfo"}
+	c.apply("peer-1", PeerEvent{Type: PeerServiceAdd, Service: svc, ResumeToken: "tok-1"})
+
+	if _, err := sd.GetService("reviews.bookinfo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := sd.GetService("reviews.bookinfo"); got == nil {
+		t.Error("expected the service to be installed into the backing MemServiceDiscovery")
+	}
+	if len(handlerCalls) != 1 || handlerCalls[0] != "reviews.bookinfo" {
+		t.Errorf("expected exactly one handler call for reviews.bookinfo, got %v", handlerCalls)
+	}
+}
+
+// TestPeerRegistryControllerApplyTracksResumeToken asserts apply records the event's resume
+// token against the peer's status, keyed by address, under the controller's mutex.
+func TestPeerRegistryControllerApplyTracksResumeToken(t *testing.T) {
+	sd := newTestSD()
+	c := newPeerRegistryController(sd, nil)
+	c.peers["peer-1"] = &peerStatus{Config: PeerRegistryConfig{Address: "peer-1"}}
+
+	c.apply("peer-1", PeerEvent{Type: PeerServiceAdd, Service: &model.Service{Hostname: "reviews.bookinfo"}, ResumeToken: "tok-42"})
+
+	peers := c.Peers()
+	if len(peers) != 1 || peers[0].LastResumeToken != "tok-42" {
+		t.Fatalf("expected LastResumeToken tok-42 recorded for peer-1, got %v", peers)
+	}
+}
+
+// TestPeerRegistryControllerMarkConnectedDisconnected asserts markConnected/markDisconnected
+// flip a tracked peer's Connected flag without touching peers not yet added.
+func TestPeerRegistryControllerMarkConnectedDisconnected(t *testing.T) {
+	sd := newTestSD()
+	c := newPeerRegistryController(sd, nil)
+	c.peers["peer-1"] = &peerStatus{Config: PeerRegistryConfig{Address: "peer-1"}}
+
+	c.markConnected("peer-1")
+	if peers := c.Peers(); !peers[0].Connected {
+		t.Fatal("expected peer-1 to be marked connected")
+	}
+
+	c.markDisconnected("peer-1")
+	if peers := c.Peers(); peers[0].Connected {
+		t.Fatal("expected peer-1 to be marked disconnected")
+	}
+
+	// Marking an address never added must not panic or create an entry.
+	c.markConnected("never-added")
+	if len(c.Peers()) != 1 {
+		t.Fatalf("expected markConnected on an unknown address to be a no-op, got %v", c.Peers())
+	}
+}
+
+// TestPeerRegistryControllerRunRetriesDialWithBackoffThenConnects asserts run's reconnect loop
+// keeps retrying a failing dial, then proceeds to stream once dialFn finally succeeds --
+// exercising AddPeer's documented backoff behavior end-to-end with a deterministic fake. run's
+// real 1s and 2s backoff waits between the failed dials still elapse, about 3s in all.
+func TestPeerRegistryControllerRunRetriesDialWithBackoffThenConnects(t *testing.T) {
+	sd := newTestSD()
+	var dialAttempts int64
+	client := &fakePeerDiscoveryClient{events: make(chan PeerEvent, 1)}
+
+	dialFn := func(cfg PeerRegistryConfig) (PeerDiscoveryClient, error) {
+		if atomic.AddInt64(&dialAttempts, 1) < 3 {
+			return nil, fmt.Errorf("simulated dial failure")
+		}
+		return client, nil
+	}
+
+	c := newPeerRegistryController(sd, dialFn)
+	c.peers["peer-1"] = &peerStatus{Config: PeerRegistryConfig{Address: "peer-1"}}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.run(PeerRegistryConfig{Address: "peer-1"}, stop)
+		close(done)
+	}()
+
+	client.events <- PeerEvent{Type: PeerServiceAdd, Service: &model.Service{Hostname: "reviews.bookinfo"}, ResumeToken: "tok-1"}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if _, err := sd.GetService("reviews.bookinfo"); err == nil {
+			if svc, _ := sd.GetService("reviews.bookinfo"); svc != nil {
+				break
+			}
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for run to connect and apply the queued event")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	close(client.events)
+	close(stop)
+	<-done
+
+	if got := atomic.LoadInt64(&dialAttempts); got < 3 {
+		t.Errorf("expected at least 3 dial attempts before success, got %d", got)
+	}
+}
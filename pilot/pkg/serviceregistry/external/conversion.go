@@ -16,12 +16,32 @@ package external
 
 import (
 	"net"
+	"strings"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/model"
 )
 
+// dnsSRVResolutionAnnotation opts a ServiceEntry_DNS host into SRV-based resolution (model.DNSSRV)
+// instead of the plain address-as-endpoint behavior DNS normally gets.
+//
+// networking.ServiceEntry (istio.io/api, a stable external type) has no resolution value for this
+// at this API vintage, so it's threaded through via a reserved annotation on the owning Config
+// instead, the same out-of-band encoding destinationRuleExportToAnnotation uses for the analogous
+// problem of extending a fixed external message.
+const dnsSRVResolutionAnnotation = "internal.istio.io/resolution-dns-srv"
+
+// localityLabel is the well-known label a ServiceEntry_Endpoint's Labels carries its
+// "region[/zone[/subzone]]" locality under -- the same convention istio-locality uses elsewhere
+// in the mesh, reused here rather than inventing a parallel one.
+const localityLabel = "istio-locality"
+
+// defaultSRVResolver is the process-wide SRV cache every DNSSRV ServiceEntry host resolves
+// through, so hosts shared across multiple ServiceEntry reads (and repeated calls as the
+// controller refreshes instances) reuse one TTL-bounded cache instead of one lookup per call.
+var defaultSRVResolver = newSRVResolver(defaultSRVResolutionInterval)
+
 func convertPort(port *networking.Port) *model.Port {
 	return &model.Port{
 		Name:                 port.Name,
@@ -31,7 +51,7 @@ func convertPort(port *networking.Port) *model.Port {
 	}
 }
 
-func convertServices(serviceEntry *networking.ServiceEntry) []*model.Service {
+func convertServices(serviceEntry *networking.ServiceEntry, meta model.ConfigMeta) []*model.Service {
 	out := make([]*model.Service, 0)
 
 	var resolution model.Resolution
@@ -40,6 +60,9 @@ func convertServices(serviceEntry *networking.ServiceEntry) []*model.Service {
 		resolution = model.Passthrough
 	case networking.ServiceEntry_DNS:
 		resolution = model.DNSLB
+		if meta.Annotations[dnsSRVResolutionAnnotation] == "true" {
+			resolution = model.DNSSRV
+		}
 	case networking.ServiceEntry_STATIC:
 		resolution = model.ClientSideLB
 	}
@@ -90,17 +113,36 @@ func convertEndpoint(service *model.Service, servicePort *networking.Port,
 			Port:        int(instancePort),
 			ServicePort: convertPort(servicePort),
 		},
-		// TODO AvailabilityZone, ServiceAccount
-		Service: service,
-		Labels:  endpoint.Labels,
+		// TODO ServiceAccount
+		Service:          service,
+		Labels:           endpoint.Labels,
+		AvailabilityZone: endpoint.Labels[localityLabel],
 	}
 }
 
-func convertInstances(serviceEntry *networking.ServiceEntry) []*model.ServiceInstance {
+func convertInstances(serviceEntry *networking.ServiceEntry, meta model.ConfigMeta) []*model.ServiceInstance {
 	out := make([]*model.ServiceInstance, 0)
-	for _, service := range convertServices(serviceEntry) {
+	for _, service := range convertServices(serviceEntry, meta) {
 		for _, servicePort := range serviceEntry.Ports {
-			if len(serviceEntry.Endpoints) == 0 &&
+			if len(serviceEntry.Endpoints) == 0 && service.Resolution == model.DNSSRV {
+				// SRV resolution discovers both the endpoint addresses and their ports, so
+				// servicePort.Number is only used to build the ServicePort each resulting
+				// instance is tagged with, not the instance's actual port.
+				for _, host := range serviceEntry.Hosts {
+					for _, record := range defaultSRVResolver.resolve(host) {
+						out = append(out, &model.ServiceInstance{
+							Endpoint: model.NetworkEndpoint{
+								Address:     strings.TrimSuffix(record.Target, "."),
+								Port:        int(record.Port),
+								ServicePort: convertPort(servicePort),
+							},
+							// TODO AvailabilityZone, ServiceAccount
+							Service: service,
+							Labels:  nil,
+						})
+					}
+				}
+			} else if len(serviceEntry.Endpoints) == 0 &&
 				serviceEntry.Resolution == networking.ServiceEntry_DNS {
 				// when service entry has discovery type DNS and no endpoints
 				// we create endpoints from service entry hosts field
@@ -0,0 +1,77 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultSRVResolutionInterval bounds how long a host's SRV record set is reused before the next
+// resolve call re-queries DNS for it. The stdlib resolver doesn't surface the record TTL, so this
+// stands in for one: short enough to pick up membership changes in a replica set or similar
+// headless cluster, long enough that a hot path of repeated resolve calls doesn't hit the
+// resolver on every call.
+const defaultSRVResolutionInterval = 30 * time.Second
+
+// srvLookupFunc matches net.LookupSRV's signature, so tests can substitute a fake resolver.
+type srvLookupFunc func(service, proto, name string) (cname string, addrs []*net.SRV, err error)
+
+// srvResolver is a TTL-bounded cache of DNS SRV lookups, one entry per queried host, shared across
+// every DNSSRV ServiceEntry so repeated instance-refresh calls don't each re-resolve from scratch.
+type srvResolver struct {
+	lookup   srvLookupFunc
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]srvCacheEntry
+}
+
+type srvCacheEntry struct {
+	resolvedAt time.Time
+	records    []*net.SRV
+}
+
+func newSRVResolver(interval time.Duration) *srvResolver {
+	return &srvResolver{
+		lookup:   net.LookupSRV,
+		interval: interval,
+		entries:  make(map[string]srvCacheEntry),
+	}
+}
+
+// resolve returns host's current SRV records, re-querying DNS once the cached entry is older than
+// r.interval. A failed re-resolution keeps serving the stale records rather than dropping every
+// endpoint until the next successful lookup, since a transient resolver hiccup shouldn't be
+// treated the same as the host actually losing all its members.
+func (r *srvResolver) resolve(host string) []*net.SRV {
+	r.mu.Lock()
+	entry, ok := r.entries[host]
+	r.mu.Unlock()
+	if ok && time.Since(entry.resolvedAt) < r.interval {
+		return entry.records
+	}
+
+	_, records, err := r.lookup("", "", host)
+	if err != nil {
+		return entry.records
+	}
+
+	r.mu.Lock()
+	r.entries[host] = srvCacheEntry{resolvedAt: time.Now(), records: records}
+	r.mu.Unlock()
+	return records
+}
@@ -16,6 +16,7 @@ package pilot
 
 import (
 	"fmt"
+	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -297,6 +298,69 @@ func TestRouteFaultInjection(t *testing.T) {
 	}
 }
 
+// TestRouteRetryPolicy pairs a 503-on-50%-of-requests fault against v1 with a retry policy
+// (3 attempts, 1s per-try timeout, retrying on "503,connect-failure") and checks that retries
+// mask the fault from the caller: the overall success rate should clear 95% even though the
+// fault alone would only pass ~50% of requests, and the added attempts shouldn't blow past the
+// per-try timeout budget.
+func TestRouteRetryPolicy(t *testing.T) {
+	const (
+		requestCount   = 100
+		wantSuccessPct = 95
+		maxElapsed     = 3 * time.Second
+	)
+
+	for _, version := range configVersions() {
+		// Invoke a function to scope the lifecycle of the deployed configs.
+		func() {
+			if version == "v1alpha3" {
+				destRule := "testdata/v1alpha3/destination-rule-c.yaml"
+				dRule := &deployableConfig{
+					Namespace:  tc.Kube.Namespace,
+					YamlFiles:  []string{destRule},
+					kubeconfig: tc.Kube.KubeConfig,
+				}
+				if err := dRule.Setup(); err != nil {
+					t.Fatal(err)
+				}
+				defer dRule.TeardownNoDelay()
+			}
+
+			ruleYaml := fmt.Sprintf("testdata/%s/rule-retry-policy.yaml", version)
+			cfgs := &deployableConfig{
+				Namespace:  tc.Kube.Namespace,
+				YamlFiles:  []string{ruleYaml},
+				kubeconfig: tc.Kube.KubeConfig,
+			}
+			if err := cfgs.Setup(); err != nil {
+				t.Fatal(err)
+			}
+			defer cfgs.Teardown()
+
+			reqURL := "http://c/a"
+			successes := 0
+			for i := 0; i < requestCount; i++ {
+				start := time.Now()
+				resp := ClientRequest("a", reqURL, 1, "-key version -val v1")
+				elapsed := time.Since(start)
+
+				if elapsed > maxElapsed {
+					t.Errorf("request %d took %s, expected the retry budget to keep it under %s", i, elapsed, maxElapsed)
+				}
+				if len(resp.Code) > 0 && resp.Code[0] == "200" {
+					successes++
+				}
+			}
+
+			successPct := successes * 100 / requestCount
+			if successPct < wantSuccessPct {
+				t.Fatalf("retry policy did not mask the fault: got %d%% success over %d requests, want >= %d%%",
+					successPct, requestCount, wantSuccessPct)
+			}
+		}()
+	}
+}
+
 func TestRouteRedirectInjection(t *testing.T) {
 	for _, version := range configVersions() {
 		// Invoke a function to scope the lifecycle of the deployed configs.
@@ -347,15 +411,19 @@ func TestRouteRedirectInjection(t *testing.T) {
 	}
 }
 
-// TODO this is not implemented properly at the moment.
 func TestRouteMirroring(t *testing.T) {
-	t.Skipf("Skipping %s due to incomplete implementation", t.Name())
+	const (
+		requestCount  = 400
+		mirrorPercent = 25
+		tolerance     = 15
+	)
+	wantMirrored := requestCount * mirrorPercent / 100
+
 	for _, version := range configVersions() {
-		logs := newAccessLogs()
 		// Invoke a function to scope the lifecycle of the deployed configs.
 		func() {
-			// Push the rule config.
-			ruleYaml := fmt.Sprintf("testdata/%s/rule-default-route-mirrored.yaml", version)
+			// Push the rule config: a->c with a 25% mirror to b.
+			ruleYaml := fmt.Sprintf("testdata/%s/rule-default-route-mirrored-25.yaml", version)
 			cfgs := &deployableConfig{
 				Namespace:  tc.Kube.Namespace,
 				YamlFiles:  []string{ruleYaml},
@@ -367,18 +435,83 @@ func TestRouteMirroring(t *testing.T) {
 			defer cfgs.Teardown()
 
 			reqURL := "http://c/a"
-			for i := 1; i <= 100; i++ {
+			var sentIDs []string
+			var primaryLatencies []time.Duration
+			for i := 1; i <= requestCount; i++ {
+				if i == requestCount/2 {
+					// Kill the mirror target mid-run. Mirroring is fire-and-forget: Envoy
+					// never waits on or surfaces the mirrored response, so the primary a->c
+					// path must keep succeeding at its normal latency even once b is gone.
+					if err := killPod(tc.Kube.Namespace, "b"); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				start := time.Now()
 				resp := ClientRequest("a", reqURL, 1, fmt.Sprintf("-key X-Request-Id -val %d", i))
-				logEntry := fmt.Sprintf("HTTP request from a to c.istio-system.svc.cluster.local:80")
+				primaryLatencies = append(primaryLatencies, time.Since(start))
 				if len(resp.ID) > 0 {
-					id := resp.ID[0]
-					logs.add("b", id, logEntry)
+					sentIDs = append(sentIDs, resp.ID[0])
 				}
 			}
 
 			t.Run("check", func(t *testing.T) {
-				logs.checkLogs(t)
+				mirrored := countMirroredRequests(t, tc.Kube.Namespace, "b", sentIDs)
+				if mirrored < wantMirrored-tolerance || mirrored > wantMirrored+tolerance {
+					t.Fatalf("expected ~%d requests mirrored to b (%d%% of %d, +/-%d), got %d",
+						wantMirrored, mirrorPercent, requestCount, tolerance, mirrored)
+				}
 			})
+
+			checkPrimaryLatencyUnaffected(t, primaryLatencies)
 		}()
 	}
 }
+
+// killPod force-deletes the pod(s) backing app in namespace, so a subsequent mirrored request
+// finds no mirror destination left to deliver to.
+func killPod(namespace, app string) error {
+	return exec.Command("kubectl", "-n", namespace, "delete", "pod",
+		"-l", "app="+app, "--grace-period=0", "--force").Run()
+}
+
+// countMirroredRequests returns how many of sentIDs appear in pod's access logs, i.e. how many
+// of the originals Envoy actually mirrored to it.
+func countMirroredRequests(t *testing.T, namespace, pod string, sentIDs []string) int {
+	out, err := exec.Command("kubectl", "-n", namespace, "logs",
+		"-l", "app="+pod, "-c", "istio-proxy").Output()
+	if err != nil {
+		t.Fatalf("failed to fetch %s's access logs: %v", pod, err)
+	}
+	logText := string(out)
+	count := 0
+	for _, id := range sentIDs {
+		if strings.Contains(logText, id) {
+			count++
+		}
+	}
+	return count
+}
+
+// checkPrimaryLatencyUnaffected compares the average primary-path latency from before and after
+// the mirror target was killed (the midpoint of latencies), failing if losing the mirror
+// destination visibly regressed it -- mirroring being fire-and-forget means it shouldn't.
+func checkPrimaryLatencyUnaffected(t *testing.T, latencies []time.Duration) {
+	mid := len(latencies) / 2
+	before := averageDuration(latencies[:mid])
+	after := averageDuration(latencies[mid:])
+	if after > before*3/2 {
+		t.Fatalf("primary path latency regressed after killing the mirror target: before=%v after=%v", before, after)
+	}
+}
+
+func averageDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	return sum / time.Duration(len(durations))
+}